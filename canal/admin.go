@@ -0,0 +1,108 @@
+package canal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Handler admin http api
+//
+//	GET  /jobs
+//	GET  /jobs/{id}
+//	POST /jobs/{id}/pause
+//	POST /jobs/{id}/resume
+//	POST /jobs/{id}/gtid      {"gtid": "uuid:1-100", "force": true}
+//	POST /jobs/{id}/snapshot
+//	GET  /metrics             prometheus text format
+func (s *supervisor) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Jobs())
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.Status(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, s.Jobs())
+	})
+	mux.HandleFunc("POST /jobs/{id}/pause", s.control(s.Pause))
+	mux.HandleFunc("POST /jobs/{id}/resume", s.control(s.Resume))
+	mux.HandleFunc("POST /jobs/{id}/snapshot", s.control(s.Snapshot))
+	mux.HandleFunc("POST /jobs/{id}/gtid", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Gtid  string `json:"gtid"`
+			Force bool   `json:"force"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Gtid == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"gtid\": \"...\", \"force\": bool}"})
+			return
+		}
+		s.control(func(id string) error {
+			return s.SetGTID(id, body.Gtid, body.Force)
+		})(w, r)
+	})
+
+	return mux
+}
+
+func (s *supervisor) control(fn func(id string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if _, err := s.get(id); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := fn(id); err != nil {
+			code := http.StatusConflict
+			if errors.Is(err, ErrBadGTID) {
+				code = http.StatusBadRequest
+			}
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		st, _ := s.Status(id)
+		writeJSON(w, http.StatusOK, st)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeMetrics(w io.Writer, jobs []JobStatus) {
+	metrics := []struct {
+		name, typ, help string
+		value           func(st JobStatus) float64
+	}{
+		{"canal_up", "gauge", "job is running", func(st JobStatus) float64 { return bool2float(st.State == JobRunning) }},
+		{"canal_lag_seconds", "gauge", "delay of the last binlog event behind the source", func(st JobStatus) float64 { return st.Lag }},
+		{"canal_restarts_total", "counter", "job restarts", func(st JobStatus) float64 { return float64(st.Restarts) }},
+		{"canal_heartbeat_lag_seconds", "gauge", "end-to-end lag of the last heartbeat", func(st JobStatus) float64 { return st.HeartbeatLag }},
+		{"canal_stream_stalled", "gauge", "no heartbeat received within Heartbeat.StallAfter", func(st JobStatus) float64 { return bool2float(st.Stalled) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, st := range jobs {
+			fmt.Fprintf(w, "%s{id=%q} %g\n", m.name, st.Id, m.value(st))
+		}
+	}
+}
+
+func bool2float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package canal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testGTID = "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5"
+
+func TestAdminGTID(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSupervisor()
+	if err := s.Add("a", Container{WorkDir: dir}, RestartPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	// a paused job only applies the admin actions, it never connects
+	j := s.job("a")
+	j.paused = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- j.run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		// position in master.info after the call
+		want string
+	}{
+		{"not a gtid set", `{"gtid": "uuid:1-x"}`, http.StatusBadRequest, ""},
+		{"missing interval", `{"gtid": "3E11FA47-71CA-11E1-9E33-C80AA9429562"}`, http.StatusBadRequest, ""},
+		{"first position", `{"gtid": "` + testGTID + `"}`, http.StatusOK, testGTID},
+		{"already set", `{"gtid": "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-9"}`, http.StatusConflict, testGTID},
+		{"bad gtid with force", `{"gtid": "x", "force": true}`, http.StatusBadRequest, testGTID},
+		{"force", `{"gtid": "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-9", "force": true}`, http.StatusOK, "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-9"},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(srv.URL+"/jobs/a/gtid", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: status %d (%s), expected %d", tt.name, resp.StatusCode, b, tt.code)
+		}
+		got, _ := os.ReadFile(filepath.Join(dir, "a", "master.info"))
+		if string(got) != tt.want {
+			t.Errorf("%s: master.info %q, expected %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	var b strings.Builder
+	writeMetrics(&b, []JobStatus{{Id: "a", State: JobRunning, Restarts: 3}})
+	for _, want := range []string{
+		"# TYPE canal_up gauge\ncanal_up{id=\"a\"} 1\n",
+		"# TYPE canal_restarts_total counter\ncanal_restarts_total{id=\"a\"} 3\n",
+		"# TYPE canal_lag_seconds gauge\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics miss %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -2,9 +2,12 @@ package canal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"regexp"
+	"slices"
 	"sync"
 	"time"
 
@@ -39,20 +42,43 @@ type Container struct {
 	log        *slog.Logger
 	TableCehck tableCheck
 	ViaSsh     *viaSSh
+	// optional, measure the end-to-end lag through a heartbeat table
+	Heartbeat *Heartbeat
 }
 
 func ViaSsh(addr, user, password string) *viaSSh {
 	return &viaSSh{addr, user, password}
 }
 
+// Run starts the job and stops it on SIGINT/SIGTERM, see RunContext
 func Run(id string, container Container, gtid_executed ...string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	utils.SignalNotify().Close(cancel)
+
+	err := RunContext(ctx, id, container, gtid_executed...)
+	if IsCanceled(err) {
+		return nil
+	}
+	return err
+}
+
+// RunContext runs the job until ctx is done or replication fails.
+//
+// on return the in-flight events are drained, the handler flush is called and
+// MasterInfo is saved, the error is a *JobError (errors.Is(err, context.Canceled) on cancel)
+func RunContext(ctx context.Context, id string, container Container, gtid_executed ...string) error {
 	container.log = slog.New(log.SlogDefaultWithId(id))
 
 	cfg := canal.NewDefaultConfig()
 	if container.Handler == nil {
-		cfg.Logger.Info("Handler not nil, exit")
-		return nil
+		return &JobError{Id: id, Op: "init", Err: ErrNoHandler}
+	}
+	h, ok := container.Handler.(*defaultEventHandler)
+	if !ok {
+		return &JobError{Id: id, Op: "init", Err: fmt.Errorf("%w: %s", ErrNoHandler, container.Handler.String())}
 	}
+
 	cfg.Addr = container.Addr
 	cfg.User = container.User
 	cfg.Password = container.Password
@@ -69,13 +95,19 @@ func Run(id string, container Container, gtid_executed ...string) error {
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		})
 		if err != nil {
-			return err
+			return &JobError{Id: id, Op: "ssh", Err: err}
 		}
 		defer sconn.Close()
 
 		go func() {
-
-			for range time.Tick(cfg.HeartbeatPeriod) {
+			ticker := time.NewTicker(cfg.HeartbeatPeriod)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
 				_, _, err := sconn.SendRequest("hello", true, nil)
 				if err != nil {
 					container.log.Error(err.Error())
@@ -96,21 +128,34 @@ func Run(id string, container Container, gtid_executed ...string) error {
 	cfg.IncludeTableRegex = container.Filter.include
 	cfg.ExcludeTableRegex = container.Filter.exclude
 
+	var hb Heartbeat
+	if container.Heartbeat != nil {
+		hb = container.Heartbeat.withDefault()
+		if len(cfg.IncludeTableRegex) > 0 {
+			cfg.IncludeTableRegex = append(slices.Clip(cfg.IncludeTableRegex), hb.match())
+		}
+		var exclude []*regexp.Regexp
+		cfg.ExcludeTableRegex, exclude = hb.exempt(cfg.ExcludeTableRegex)
+		h.setExclude(exclude)
+	} else {
+		h.setExclude(nil)
+	}
+
 	c, err := canal.NewCanal(cfg)
 	if err != nil {
 		container.log.Error(err.Error())
-		return err
+		return &JobError{Id: id, Op: "init", Err: err}
 	}
+	closeCanal := sync.OnceFunc(c.Close)
+	defer closeCanal()
 
-	wg := &sync.WaitGroup{}
-
-	h, ok := container.Handler.(*defaultEventHandler)
-	if ok {
-		h.setCanal(c)
-		wg.Add(1)
-		go h.work(wg, container.log, 200)
-	}
+	h.setCanal(c)
 	c.SetEventHandler(h)
+	if container.Heartbeat != nil {
+		h.setHeartbeat(id, &hb)
+	} else {
+		h.setHeartbeat(id, nil)
+	}
 
 	var gtidSet mysql.GTIDSet
 
@@ -119,19 +164,26 @@ func Run(id string, container Container, gtid_executed ...string) error {
 	}
 
 	if err := h.MasterInfo.Init(&container.WorkDir, id); err != nil {
-		cfg.Logger.Error("MasterInfo Init ", err)
-		return err
+		container.log.Error("MasterInfo Init", "error", err)
+		return &JobError{Id: id, Op: "masterinfo", Err: err}
 	}
 
 	g, err := h.MasterInfo.Load()
 	if err != nil {
 		container.log.Error(err.Error())
-		return err
+		return &JobError{Id: id, Op: "masterinfo", Err: err}
+	}
+	gtidSet, err = mysql.ParseGTIDSet("mysql", g)
+	if err != nil {
+		container.log.Error("MasterInfo Load", "gtid", g, "error", err)
+		return &JobError{Id: id, Op: "masterinfo", Err: fmt.Errorf("%w %q: %v", ErrBadGTID, g, err)}
 	}
-	gtidSet, _ = mysql.ParseGTIDSet("mysql", g)
 
 	if len(gtid_executed) == 1 && gtid_executed[0] != "" {
-		gtidSet, _ = mysql.ParseGTIDSet("mysql", gtid_executed[0])
+		gtidSet, err = mysql.ParseGTIDSet("mysql", gtid_executed[0])
+		if err != nil {
+			return &JobError{Id: id, Op: "start", Err: fmt.Errorf("%w %q: %v", ErrBadGTID, gtid_executed[0], err)}
+		}
 	}
 
 	if container.Prepare != nil {
@@ -139,7 +191,7 @@ func Run(id string, container Container, gtid_executed ...string) error {
 		query := "select table_schema as database_name, table_name from information_schema.tables where table_type != 'view'  order by database_name, table_name"
 		r, err := c.Execute(query)
 		if err != nil {
-			return fmt.Errorf("get dbs: %v", err)
+			return &JobError{Id: id, Op: "prepare", Err: fmt.Errorf("get dbs: %w", err)}
 		}
 		var dbs []string
 		for _, row := range r.Values {
@@ -148,6 +200,10 @@ func Run(id string, container Container, gtid_executed ...string) error {
 			table := string(row[1].AsString())
 
 			t := db + "." + table
+			// the heartbeat table is not user data
+			if container.Heartbeat != nil && t == hb.name() {
+				continue
+			}
 			if container.Filter.Match(t) {
 				dbs = append(dbs, t)
 			}
@@ -157,25 +213,48 @@ func Run(id string, container Container, gtid_executed ...string) error {
 		err = container.Prepare(gtidSet, &container, dbs)
 		if err != nil {
 			container.log.Error(err.Error())
-			return err
+			return &JobError{Id: id, Op: "prepare", Err: err}
 		}
 
 	}
 
 	if gtidSet == nil || gtidSet.String() == "" {
-		cfg.Logger.Error("gtid_executed not set, or use Container.Prepare")
-		return nil
+		container.log.Error("gtid_executed not set, or use Container.Prepare")
+		return &JobError{Id: id, Op: "start", Err: ErrNoGTID}
 	}
 
-	utils.SignalNotify().Close(func() {
-		c.Close()
-		err := h.MasterInfo.Close()
-		container.log.Info("masterinfo save error:", err)
-		container.log.Info("sig close")
+	stop, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	workErr := make(chan error, 1)
+	go func() {
+		workErr <- h.work(stop, container.log, 200)
+	}()
+	if container.Heartbeat != nil {
+		go hb.write(stop, id, &container, container.log)
+		go h.watch(stop, hb, container.log)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeCanal()
+		case <-c.Ctx().Done():
+		}
+	}()
 
-	})
 	err = c.StartFromGTID(gtidSet)
-	wg.Wait()
-	container.log.Info(id, "exit")
-	return err
+
+	// Close pushes the last synced position into the handler before work drains it
+	closeCanal()
+	stopWork()
+	err = errors.Join(err, <-workErr)
+	container.log.Info("exit", "id", id)
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		return nil
+	}
+	return &JobError{Id: id, Op: "run", Err: err}
 }
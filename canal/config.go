@@ -0,0 +1,205 @@
+package canal
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/zhujintao/kit-go/mysql"
+	"gopkg.in/yaml.v3"
+)
+
+// Sink sets the handler callbacks of a job, set Container.Prepare to support snapshots
+//
+// c.Handler is a DefaultHandler() unless the sink replaces it
+type Sink func(c *Container, options map[string]string) error
+
+// Checkpoint returns the MasterInfo backend of a job
+type Checkpoint func(c *Container, options map[string]string) (MasterInfoInterface, error)
+
+var (
+	pluginLock  sync.RWMutex
+	sinks       = map[string]Sink{"stdout": stdoutSink}
+	checkpoints = map[string]Checkpoint{"file": fileCheckpoint}
+)
+
+func RegisterSink(name string, fn Sink) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+	sinks[name] = fn
+}
+
+func RegisterCheckpoint(name string, fn Checkpoint) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+	checkpoints[name] = fn
+}
+
+type PluginConfig struct {
+	Type    string            `yaml:"type"`
+	Options map[string]string `yaml:"options"`
+}
+
+type JobConfig struct {
+	Id     string `yaml:"id"`
+	Source struct {
+		Addr     string `yaml:"addr"`
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+		ViaSsh   *struct {
+			Addr     string `yaml:"addr"`
+			User     string `yaml:"user"`
+			Password string `yaml:"password"`
+		} `yaml:"via_ssh"`
+	} `yaml:"source"`
+	Filter struct {
+		Include []string `yaml:"include"`
+		Exclude []string `yaml:"exclude"`
+	} `yaml:"filter"`
+	Sink       PluginConfig `yaml:"sink"`
+	Checkpoint PluginConfig `yaml:"checkpoint"`
+	Restart    struct {
+		// never, on-failure, always
+		Mode        string        `yaml:"mode"`
+		MaxRestarts int           `yaml:"max_restarts"`
+		Backoff     time.Duration `yaml:"backoff"`
+		MaxBackoff  time.Duration `yaml:"max_backoff"`
+	} `yaml:"restart"`
+	Gtid      string     `yaml:"gtid"`
+	Heartbeat *Heartbeat `yaml:"heartbeat"`
+}
+
+// listen: ":8080"
+// jobs:
+//   - id: order
+//     source: {addr: 127.0.0.1:3306, user: root, password: root}
+//     filter: {include: ['order\..*'], exclude: []}
+//     sink: {type: stdout}
+//     checkpoint: {type: file, options: {dir: ./data}}
+//     restart: {mode: on-failure, backoff: 1s, max_backoff: 1m}
+//     heartbeat: {table: kit_canal.heartbeat, interval: 1s, stall_after: 30s}
+type SupervisorConfig struct {
+	Listen string      `yaml:"listen"`
+	Jobs   []JobConfig `yaml:"jobs"`
+}
+
+// LoadSupervisor reads job definitions from a yaml file, sinks and checkpoints
+// other than stdout and file must be registered first
+func LoadSupervisor(file string) (*supervisor, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg SupervisorConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	s := NewSupervisor().SetListen(cfg.Listen)
+	for _, jc := range cfg.Jobs {
+		c, policy, err := jc.build()
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jc.Id, err)
+		}
+		if err := s.Add(jc.Id, c, policy, jc.Gtid); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (jc JobConfig) build() (Container, RestartPolicy, error) {
+	c := Container{
+		Addr:      jc.Source.Addr,
+		User:      jc.Source.User,
+		Password:  jc.Source.Password,
+		Filter:    FilterTable().Include(jc.Filter.Include...).Exclude(jc.Filter.Exclude...),
+		Handler:   DefaultHandler(),
+		Heartbeat: jc.Heartbeat,
+	}
+	if jc.Source.ViaSsh != nil {
+		c.ViaSsh = ViaSsh(jc.Source.ViaSsh.Addr, jc.Source.ViaSsh.User, jc.Source.ViaSsh.Password)
+	}
+
+	policy := RestartPolicy{MaxRestarts: jc.Restart.MaxRestarts, Backoff: jc.Restart.Backoff, MaxBackoff: jc.Restart.MaxBackoff}
+	switch jc.Restart.Mode {
+	case "", "on-failure":
+		policy.Mode = RestartOnFailure
+	case "never":
+		policy.Mode = RestartNever
+	case "always":
+		policy.Mode = RestartAlways
+	default:
+		return c, policy, fmt.Errorf("unknown restart mode %q", jc.Restart.Mode)
+	}
+
+	pluginLock.RLock()
+	sink, sinkOk := sinks[jc.Sink.Type]
+	checkpoint, checkpointOk := checkpoints[jc.Checkpoint.Type]
+	pluginLock.RUnlock()
+
+	if jc.Sink.Type == "" {
+		sink, sinkOk = stdoutSink, true
+	}
+	if jc.Checkpoint.Type == "" {
+		checkpoint, checkpointOk = fileCheckpoint, true
+	}
+	if !sinkOk {
+		return c, policy, fmt.Errorf("unknown sink %q", jc.Sink.Type)
+	}
+	if !checkpointOk {
+		return c, policy, fmt.Errorf("unknown checkpoint %q", jc.Checkpoint.Type)
+	}
+
+	if err := sink(&c, jc.Sink.Options); err != nil {
+		return c, policy, err
+	}
+	h, ok := c.Handler.(*defaultEventHandler)
+	if !ok {
+		return c, policy, ErrNoHandler
+	}
+	m, err := checkpoint(&c, jc.Checkpoint.Options)
+	if err != nil {
+		return c, policy, err
+	}
+	h.MasterInfo = m
+	return c, policy, nil
+}
+
+// options dir, default current path
+func fileCheckpoint(c *Container, options map[string]string) (MasterInfoInterface, error) {
+	c.WorkDir = options["dir"]
+	if c.WorkDir == "" {
+		c.WorkDir = "."
+	}
+	return &masterInfo{}, nil
+}
+
+// print the dml of each row
+func stdoutSink(c *Container, options map[string]string) error {
+	h := c.Handler.(*defaultEventHandler)
+	dml := &mysql.DmlDefault{}
+	h.SetOnRow(func(e *RowsEvent) error {
+		switch e.Action {
+		case canal.InsertAction:
+			for _, row := range e.Rows {
+				s, v := dml.Insert(e.Table, row)
+				fmt.Println(e.Header.LogPos, s, v)
+			}
+		case canal.UpdateAction:
+			for i := 0; i+1 < len(e.Rows); i += 2 {
+				s, v := dml.Update(e.Table, e.Rows[i], e.Rows[i+1])
+				fmt.Println(e.Header.LogPos, s, v)
+			}
+		case canal.DeleteAction:
+			for _, row := range e.Rows {
+				s, v := dml.Delete(e.Table, row)
+				fmt.Println(e.Header.LogPos, s, v)
+			}
+		}
+		return nil
+	})
+	return nil
+}
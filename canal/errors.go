@@ -0,0 +1,49 @@
+package canal
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrNoHandler = errors.New("handler must be canal.DefaultHandler()")
+	ErrNoGTID    = errors.New("gtid_executed not set, or use Container.Prepare")
+	ErrBadGTID   = errors.New("invalid gtid set")
+)
+
+// JobError is returned by RunContext, Op is the stage that failed
+type JobError struct {
+	Id  string
+	Op  string
+	Err error
+}
+
+func (e *JobError) Error() string {
+	return "canal " + e.Id + " " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// IsCanceled reports the job was stopped by its context, not by a failure.
+// a joined error is canceled only if all of its errors are, a failed flush or
+// MasterInfo save on the way out is not hidden by the cancel
+func IsCanceled(err error) bool {
+	for {
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			errs := e.Unwrap()
+			for _, err := range errs {
+				if !IsCanceled(err) {
+					return false
+				}
+			}
+			return len(errs) > 0
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package canal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsCanceled(t *testing.T) {
+	flush := errors.New("flush: connection refused")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"wrapped", fmt.Errorf("start: %w", context.Canceled), true},
+		{"job error", &JobError{Id: "a", Op: "run", Err: context.Canceled}, true},
+		{"joined cancels", errors.Join(context.Canceled, fmt.Errorf("sync: %w", context.Canceled)), true},
+		{"failure", flush, false},
+		{"cancel and failed flush", &JobError{Id: "a", Op: "run", Err: errors.Join(context.Canceled, flush)}, false},
+		{"failed save on the way out", fmt.Errorf("stop: %w", errors.Join(fmt.Errorf("masterinfo save: %w", flush), context.DeadlineExceeded)), false},
+	}
+	for _, tt := range tests {
+		if got := IsCanceled(tt.err); got != tt.want {
+			t.Errorf("%s: IsCanceled(%v) = %v, expected %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
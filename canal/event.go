@@ -1,7 +1,11 @@
 package canal
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"regexp"
 	"sync"
 	"time"
 
@@ -30,28 +34,37 @@ type defaultEventHandler struct {
 	onRow       func(e *canal.RowsEvent) error
 	onDDL       func(header *EventHeader, nextPos Position, queryEvent *QueryEvent) error
 	onPosSynced func(header *EventHeader, pos Position, set GTIDSet, force bool) error
+	onFlush     func() error
+	onXID       func(header *EventHeader, nextPos Position) error
 	canal       *canal.Canal
 	ch          chan any
 	*canal.DummyEventHandler
 	MasterInfo MasterInfoInterface
 	log        *slog.Logger
+
+	mu        sync.RWMutex
+	gtid      string
+	lag       time.Duration
+	heartbeat *heartbeatState
+	// Filter.Exclude regexes matching the heartbeat table, not given to canal
+	exclude []*regexp.Regexp
 }
 
-func (h *defaultEventHandler) work(wd *sync.WaitGroup, log *slog.Logger, interval int) {
+// work saves the synced gtid until stop is done, then drains the pending
+// positions, flushes the sink and forces a final MasterInfo save. A failed save
+// closes the canal and is returned, so the job is not taken for a clean stop
+func (h *defaultEventHandler) work(stop context.Context, log *slog.Logger, interval int) error {
 	h.log = log
 	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
 	defer ticker.Stop()
-	defer wd.Done()
 	lastsaveTime := time.Now()
 	var gtidSet string
+	var saveErr error
 	for {
 		var write bool
 		select {
-		case <-h.canal.Ctx().Done():
-			time.Sleep(1 * time.Second)
-			h.log.Info("work exit")
-
-			return
+		case <-stop.Done():
+			return errors.Join(saveErr, h.drain(gtidSet))
 		case <-ticker.C:
 			write = true
 		case v := <-h.ch:
@@ -65,19 +78,48 @@ func (h *defaultEventHandler) work(wd *sync.WaitGroup, log *slog.Logger, interva
 				}
 			}
 		}
-		if write {
+		if write && gtidSet != "" && saveErr == nil {
 			err := h.MasterInfo.Save(gtidSet)
 			if err != nil {
-				h.log.Error("work MasterInfo.save", err)
+				h.log.Error("work MasterInfo.save", "error", err)
+				saveErr = fmt.Errorf("masterinfo save: %w", err)
 				h.canal.Close()
-
 			}
-
 		}
+	}
+}
 
+func (h *defaultEventHandler) drain(gtidSet string) error {
+drain:
+	for {
+		select {
+		case v := <-h.ch:
+			if v, ok := v.(gtidSave); ok {
+				gtidSet = v.gtidSet
+			}
+		default:
+			break drain
+		}
 	}
 
+	var errs []error
+	if h.onFlush != nil {
+		if err := h.onFlush(); err != nil {
+			errs = append(errs, fmt.Errorf("flush: %w", err))
+		}
+	}
+	if gtidSet != "" {
+		if err := h.MasterInfo.Save(gtidSet); err != nil {
+			errs = append(errs, fmt.Errorf("masterinfo save: %w", err))
+		}
+	}
+	if err := h.MasterInfo.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("masterinfo close: %w", err))
+	}
+	h.log.Info("work exit", "gtid", gtidSet)
+	return errors.Join(errs...)
 }
+
 func (h *defaultEventHandler) setCanal(c *Canal) {
 	h.canal = c
 }
@@ -94,9 +136,38 @@ func (h *defaultEventHandler) SetOnPosSynced(fn func(header *EventHeader, pos Po
 	h.onPosSynced = fn
 }
 
+// fn is called once the job stops, before the final MasterInfo save
+func (h *defaultEventHandler) SetOnFlush(fn func() error) {
+	h.onFlush = fn
+}
+
+// fn is called at the commit of every source transaction, flush a mysql.DmlBatch here
+func (h *defaultEventHandler) SetOnXID(fn func(header *EventHeader, nextPos Position) error) {
+	h.onXID = fn
+}
+
 func (h *defaultEventHandler) String() string { return "DefaultEventHandler" }
 
+// Status last synced gtid and the delay of the last event behind the source
+func (h *defaultEventHandler) Status() (string, time.Duration) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.gtid, h.lag
+}
+
+func (h *defaultEventHandler) track(header *EventHeader, set GTIDSet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if set != nil {
+		h.gtid = set.String()
+	}
+	if header != nil && header.Timestamp > 0 {
+		h.lag = max(time.Since(time.Unix(int64(header.Timestamp), 0)), 0)
+	}
+}
+
 func (h *defaultEventHandler) OnPosSynced(header *EventHeader, pos Position, set GTIDSet, force bool) error {
+	h.track(header, set)
 	h.ch <- gtidSave{set.String(), force}
 	if h.onPosSynced == nil {
 		return h.canal.Ctx().Err()
@@ -104,6 +175,10 @@ func (h *defaultEventHandler) OnPosSynced(header *EventHeader, pos Position, set
 	return h.onPosSynced(header, pos, set, force)
 }
 func (h *defaultEventHandler) OnRow(e *canal.RowsEvent) error {
+	h.track(e.Header, nil)
+	if h.onHeartbeat(e) || h.excluded(e) {
+		return h.canal.Ctx().Err()
+	}
 
 	if h.onRow == nil {
 		return h.canal.Ctx().Err()
@@ -111,6 +186,13 @@ func (h *defaultEventHandler) OnRow(e *canal.RowsEvent) error {
 	return h.onRow(e)
 }
 
+func (h *defaultEventHandler) OnXID(header *EventHeader, nextPos Position) error {
+	if h.onXID == nil {
+		return h.canal.Ctx().Err()
+	}
+	return h.onXID(header, nextPos)
+}
+
 func (h *defaultEventHandler) OnDDL(header *replication.EventHeader, nextPos Position, queryEvent *QueryEvent) error {
 
 	h.ch <- gtidSave{queryEvent.GSet.String(), true}
@@ -10,10 +10,11 @@ require (
 	github.com/siddontang/go v0.0.0-20180604090527-bdc77568d726
 	github.com/siddontang/go-log v0.0.0-20190221022429-1e957dd83bed
 	github.com/zhujintao/kit-go/log v0.0.0-20250423065603-65cda42ce36a
-	github.com/zhujintao/kit-go/mysql v0.0.0-20250301084922-173e8b5c2672
+	github.com/zhujintao/kit-go/mysql v0.2.0
 	github.com/zhujintao/kit-go/utils v0.0.0-20250414091825-969f7b32093a
-	golang.org/x/crypto v0.36.0
+	golang.org/x/crypto v0.37.0
 	golang.org/x/sync v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 	tailscale.com v1.80.3
 )
 
@@ -31,7 +32,7 @@ require (
 	go.uber.org/atomic v1.11.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.27.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 )
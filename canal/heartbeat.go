@@ -0,0 +1,218 @@
+package canal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Heartbeat updates a row of Table on the source every Interval, the row comes
+// back through the binlog and gives the end-to-end lag even when the source is idle.
+//
+// heartbeat rows are not passed to the handler OnRow, one table is shared by all ids.
+// the table is kept by Filter.Exclude and left out of the Prepare tables, the lag
+// is read from DefaultHandler().Heartbeat() or the supervisor metrics
+type Heartbeat struct {
+	// db.table, default kit_canal.heartbeat
+	Table string `yaml:"table"`
+	// default 1s
+	Interval time.Duration `yaml:"interval"`
+	// no heartbeat received for StallAfter raises the stream stalled alarm, default 10 * Interval
+	StallAfter time.Duration `yaml:"stall_after"`
+}
+
+type heartbeatState struct {
+	schema string
+	table  string
+	id     string
+	last   time.Time
+	lag    time.Duration
+	stall  bool
+}
+
+func (hb Heartbeat) withDefault() Heartbeat {
+	if hb.Table == "" {
+		hb.Table = "kit_canal.heartbeat"
+	}
+	if hb.Interval <= 0 {
+		hb.Interval = time.Second
+	}
+	if hb.StallAfter <= 0 {
+		hb.StallAfter = 10 * hb.Interval
+	}
+	return hb
+}
+
+func (hb Heartbeat) split() (string, string) {
+	schema, table, ok := strings.Cut(hb.Table, ".")
+	if !ok {
+		return "kit_canal", schema
+	}
+	return schema, table
+}
+
+// include regex so the heartbeat table is not filtered out by canal
+func (hb Heartbeat) match() string {
+	schema, table := hb.split()
+	return fmt.Sprintf(`%s\.%s$`, regexp.QuoteMeta(schema), regexp.QuoteMeta(table))
+}
+
+// exempt splits exclude into the regexes canal can apply and the ones that also
+// drop the heartbeat table, those are applied to the rows by the handler instead
+func (hb Heartbeat) exempt(exclude []string) (keep []string, rows []*regexp.Regexp) {
+	name := hb.name()
+	for _, val := range exclude {
+		reg, err := regexp.Compile(val)
+		if err != nil || !reg.MatchString(name) {
+			keep = append(keep, val)
+			continue
+		}
+		rows = append(rows, reg)
+	}
+	return keep, rows
+}
+
+// name db.table as matched by the table filter
+func (hb Heartbeat) name() string {
+	schema, table := hb.split()
+	return schema + "." + table
+}
+
+// write runs until ctx is done, errors are logged and retried on the next tick
+func (hb Heartbeat) write(ctx context.Context, id string, c *Container, log *slog.Logger) {
+	cli := c.client()
+	if cli == nil {
+		log.Error("heartbeat connect failed")
+		return
+	}
+	defer cli.Close()
+
+	schema, table := hb.split()
+	name := "`" + schema + "`.`" + table + "`"
+	for _, sql := range []string{
+		"CREATE DATABASE IF NOT EXISTS `" + schema + "`",
+		"CREATE TABLE IF NOT EXISTS " + name + " (id VARCHAR(128) NOT NULL PRIMARY KEY, ts BIGINT NOT NULL)",
+	} {
+		if _, err := cli.Execute(sql); err != nil {
+			log.Error("heartbeat", "sql", sql, "error", err)
+			return
+		}
+	}
+
+	ticker := time.NewTicker(hb.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := cli.Execute("REPLACE INTO "+name+" (id, ts) VALUES (?, ?)", id, time.Now().UnixMicro()); err != nil {
+			log.Warn("heartbeat write", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watch raises the stream stalled alarm once and clears it when heartbeats come back
+func (h *defaultEventHandler) watch(ctx context.Context, hb Heartbeat, log *slog.Logger) {
+	ticker := time.NewTicker(hb.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h.mu.Lock()
+		st := h.heartbeat
+		since := time.Since(st.last)
+		stall := since > hb.StallAfter
+		changed := stall != st.stall
+		st.stall = stall
+		lag := st.lag
+		h.mu.Unlock()
+
+		if changed && stall {
+			log.Error("stream stalled", "since", since.Truncate(time.Millisecond), "table", hb.Table)
+		}
+		if changed && !stall {
+			log.Info("stream recovered", "lag", lag)
+		}
+	}
+}
+
+func (h *defaultEventHandler) setHeartbeat(id string, hb *Heartbeat) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hb == nil {
+		h.heartbeat = nil
+		return
+	}
+	schema, table := hb.split()
+	h.heartbeat = &heartbeatState{schema: schema, table: table, id: id, last: time.Now()}
+}
+
+// onHeartbeat reports whether e is a heartbeat row, they are not passed to OnRow
+func (h *defaultEventHandler) onHeartbeat(e *RowsEvent) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.heartbeat
+	if st == nil || e.Table == nil || e.Table.Schema != st.schema || e.Table.Name != st.table {
+		return false
+	}
+	for _, row := range e.Rows {
+		if len(row) < 2 || fmt.Sprint(stringValue(row[0])) != st.id {
+			continue
+		}
+		ts, ok := row[1].(int64)
+		if !ok {
+			continue
+		}
+		st.last = time.Now()
+		st.lag = max(st.last.Sub(time.UnixMicro(ts)), 0)
+	}
+	return true
+}
+
+func (h *defaultEventHandler) setExclude(exclude []*regexp.Regexp) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.exclude = exclude
+}
+
+// excluded reports whether e is dropped by an exclude regex kept from canal by Heartbeat.exempt
+func (h *defaultEventHandler) excluded(e *RowsEvent) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.exclude) == 0 || e.Table == nil {
+		return false
+	}
+	name := e.Table.Schema + "." + e.Table.Name
+	for _, reg := range h.exclude {
+		if reg.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Heartbeat end-to-end lag, time of the last heartbeat received and the stream stalled alarm
+func (h *defaultEventHandler) Heartbeat() (time.Duration, time.Time, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.heartbeat == nil {
+		return 0, time.Time{}, false
+	}
+	return h.heartbeat.lag, h.heartbeat.last, h.heartbeat.stall
+}
+
+func stringValue(v any) any {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
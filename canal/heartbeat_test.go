@@ -0,0 +1,83 @@
+package canal
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+func TestHeartbeatExempt(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    string
+		exclude  []string
+		wantKeep []string
+		wantRows int
+	}{
+		{name: "no exclude", exclude: nil, wantKeep: nil},
+		{name: "other db", exclude: []string{`mysql\..*`}, wantKeep: []string{`mysql\..*`}},
+		{name: "heartbeat db", exclude: []string{`mysql\..*`, `kit_canal\..*`}, wantKeep: []string{`mysql\..*`}, wantRows: 1},
+		{name: "all tables", exclude: []string{`.*\..*`}, wantRows: 1},
+		{name: "custom table", table: "ops.hb", exclude: []string{`ops\.h.*`, `kit_canal\..*`}, wantKeep: []string{`kit_canal\..*`}, wantRows: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb := Heartbeat{Table: tt.table}.withDefault()
+			keep, rows := hb.exempt(tt.exclude)
+			if len(keep) != len(tt.wantKeep) {
+				t.Fatalf("kept regexes %q, expected %q", keep, tt.wantKeep)
+			}
+			for i := range keep {
+				if keep[i] != tt.wantKeep[i] {
+					t.Errorf("kept regex %d is %s, expected %s", i, keep[i], tt.wantKeep[i])
+				}
+			}
+			if len(rows) != tt.wantRows {
+				t.Errorf("got %d row regexes, expected %d", len(rows), tt.wantRows)
+			}
+			// what canal is given never drops the heartbeat table
+			f := FilterTable().Exclude(keep...)
+			if !f.Match(hb.name()) {
+				t.Errorf("heartbeat table %s filtered out by %q", hb.name(), keep)
+			}
+		})
+	}
+}
+
+func TestHeartbeatRows(t *testing.T) {
+	h := DefaultHandler()
+	hb := Heartbeat{}.withDefault()
+	h.setHeartbeat("job1", &hb)
+	h.setExclude([]*regexp.Regexp{regexp.MustCompile(`kit_canal\..*`)})
+
+	ts := time.Now().Add(-time.Second).UnixMicro()
+	tests := []struct {
+		name          string
+		schema, table string
+		rows          [][]interface{}
+		heartbeat     bool
+		excluded      bool
+	}{
+		{name: "heartbeat", schema: "kit_canal", table: "heartbeat", rows: [][]interface{}{{[]byte("job1"), ts}}, heartbeat: true},
+		{name: "other id", schema: "kit_canal", table: "heartbeat", rows: [][]interface{}{{"job2", ts}}, heartbeat: true},
+		{name: "excluded", schema: "kit_canal", table: "other", excluded: true},
+		{name: "user table", schema: "app", table: "users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &RowsEvent{Table: &schema.Table{Schema: tt.schema, Name: tt.table}, Rows: tt.rows}
+			if got := h.onHeartbeat(e); got != tt.heartbeat {
+				t.Errorf("onHeartbeat returned %v, expected %v", got, tt.heartbeat)
+			}
+			if got := !tt.heartbeat && h.excluded(e); got != tt.excluded {
+				t.Errorf("excluded returned %v, expected %v", got, tt.excluded)
+			}
+		})
+	}
+	lag, _, stalled := h.Heartbeat()
+	if lag < time.Second || stalled {
+		t.Errorf("Heartbeat() lag=%s stalled=%v, expected lag >= 1s and not stalled", lag, stalled)
+	}
+}
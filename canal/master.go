@@ -25,13 +25,17 @@ type masterInfo struct {
 }
 
 func (m *masterInfo) Save(set string) error {
+	return m.save(set, false)
+}
+
+func (m *masterInfo) save(set string, force bool) error {
 
 	m.Lock()
 	defer m.Unlock()
 
 	m.Gtid = set
 	now := time.Now()
-	if now.Sub(m.lastsaveTime) < time.Second {
+	if !force && now.Sub(m.lastsaveTime) < time.Second {
 		return nil
 	}
 	m.lastsaveTime = now
@@ -40,8 +44,15 @@ func (m *masterInfo) Save(set string) error {
 
 }
 
+// Close always writes the last gtid, Save may skip it within a second
 func (m *masterInfo) Close() error {
-	return m.Save(m.Gtid)
+	m.RLock()
+	set := m.Gtid
+	m.RUnlock()
+	if set == "" {
+		return nil
+	}
+	return m.save(set, true)
 }
 
 func (m *masterInfo) Init(dir *string, id string) error {
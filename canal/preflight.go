@@ -0,0 +1,36 @@
+package canal
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zhujintao/kit-go/mysql"
+)
+
+func (c *Container) client() *mysql.Conn {
+	cfg := &mysql.Config{Addr: c.Addr, User: c.User, Password: c.Password}
+	if c.ViaSsh != nil {
+		return mysql.NewClientViaSSH(c.ViaSsh.Addr, c.ViaSsh.User, c.ViaSsh.Password, cfg)
+	}
+	return mysql.NewClient(cfg)
+}
+
+// Preflight checks the source settings and the grants of Container.User before Run,
+// the error lists every missing setting or privilege
+//
+//	if err := canal.Preflight(ctx, c); err != nil {
+//		fmt.Println(err)
+//		return
+//	}
+func Preflight(ctx context.Context, c Container) error {
+	cli := c.client()
+	if cli == nil {
+		return errors.New("preflight connect failed")
+	}
+	defer cli.Close()
+	p, err := cli.Preflight(ctx)
+	if err != nil {
+		return err
+	}
+	return p.Err()
+}
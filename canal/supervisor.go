@@ -0,0 +1,446 @@
+package canal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/zhujintao/kit-go/log"
+)
+
+type RestartMode int
+
+const (
+	RestartNever RestartMode = iota
+	RestartOnFailure
+	RestartAlways
+)
+
+var ErrJobStopped = errors.New("job stopped")
+
+// MaxRestarts 0 is unlimited
+//
+// Backoff default 1s, doubled after every restart up to MaxBackoff (default 1m),
+// a run that lasted MaxBackoff resets it
+type RestartPolicy struct {
+	Mode        RestartMode
+	MaxRestarts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+type JobState string
+
+const (
+	JobStarting   JobState = "starting"
+	JobRunning    JobState = "running"
+	JobPaused     JobState = "paused"
+	JobRestarting JobState = "restarting"
+	JobStopped    JobState = "stopped"
+)
+
+type JobStatus struct {
+	Id       string   `json:"id"`
+	State    JobState `json:"state"`
+	Gtid     string   `json:"gtid"`
+	Lag      float64  `json:"lag_seconds"`
+	Restarts int      `json:"restarts"`
+	Error    string   `json:"error,omitempty"`
+	// only with Container.Heartbeat
+	HeartbeatLag  float64   `json:"heartbeat_lag_seconds,omitempty"`
+	LastHeartbeat time.Time `json:"last_heartbeat,omitzero"`
+	Stalled       bool      `json:"stalled"`
+}
+
+type action struct {
+	fn   func() error
+	done chan error
+}
+
+type job struct {
+	id        string
+	container Container
+	policy    RestartPolicy
+	gtid      []string
+
+	mu sync.Mutex
+	// Container.Prepare runs on the first start and after Snapshot, not on every restart
+	prepare  bool
+	snapshot bool
+	state    JobState
+	paused   bool
+	restarts int
+	lastErr  error
+	cancel   context.CancelFunc
+	actions  []action
+	wake     chan struct{}
+	exited   chan struct{}
+}
+
+type supervisor struct {
+	mu     sync.Mutex
+	jobs   []*job
+	listen string
+}
+
+// run many canal ids in one process
+func NewSupervisor() *supervisor {
+	return &supervisor{}
+}
+
+// gtid_executed is only used by the first start, restarts resume from MasterInfo
+func (s *supervisor) Add(id string, container Container, policy RestartPolicy, gtid_executed ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.job(id) != nil {
+		return fmt.Errorf("canal %s already added", id)
+	}
+	if container.Handler == nil {
+		container.Handler = DefaultHandler()
+	}
+	s.jobs = append(s.jobs, &job{
+		id:        id,
+		container: container,
+		policy:    policy,
+		gtid:      gtid_executed,
+		prepare:   true,
+		state:     JobStarting,
+		wake:      make(chan struct{}, 1),
+		exited:    make(chan struct{}),
+	})
+	return nil
+}
+
+// admin http api address, empty is disabled
+func (s *supervisor) SetListen(addr string) *supervisor {
+	s.listen = addr
+	return s
+}
+
+func (s *supervisor) job(id string) *job {
+	for _, j := range s.jobs {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// Run blocks until every job has stopped, cancel ctx to stop them all
+func (s *supervisor) Run(ctx context.Context) error {
+	s.mu.Lock()
+	jobs := append([]*job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	if s.listen != "" {
+		srv := &http.Server{Addr: s.listen, Handler: s.Handler()}
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("canal admin", "listen", s.listen, "error", err)
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs))
+	for i, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = j.run(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *supervisor) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []JobStatus
+	for _, j := range s.jobs {
+		list = append(list, j.status())
+	}
+	return list
+}
+
+func (s *supervisor) Status(id string) (JobStatus, error) {
+	j, err := s.get(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return j.status(), nil
+}
+
+// Pause stops the job, MasterInfo is saved and it stays stopped until Resume
+func (s *supervisor) Pause(id string) error {
+	j, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	return j.do(func() error {
+		j.paused = true
+		return nil
+	})
+}
+
+func (s *supervisor) Resume(id string) error {
+	j, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	return j.do(func() error {
+		j.paused = false
+		return nil
+	})
+}
+
+// SetGTID restarts the job from gset, like syncer.SetGTID it is only applied when
+// the job has no position yet, force overwrites it like setgset.Force
+func (s *supervisor) SetGTID(id, gset string, force bool) error {
+	j, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	return j.do(func() error {
+		return j.checkpoint(gset, force)
+	})
+}
+
+// Snapshot restarts the job through Container.Prepare with an empty gtid set, so
+// the tables are exported again and the binlog follows from the gtid of the export
+func (s *supervisor) Snapshot(id string) error {
+	j, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	if j.container.Prepare == nil {
+		return fmt.Errorf("canal %s: Container.Prepare not set", id)
+	}
+	return j.do(func() error {
+		j.prepare = true
+		j.snapshot = true
+		return nil
+	})
+}
+
+func (s *supervisor) get(id string) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.job(id)
+	if j == nil {
+		return nil, fmt.Errorf("canal %s not found", id)
+	}
+	return j, nil
+}
+
+func (j *job) status() JobStatus {
+	j.mu.Lock()
+	st := JobStatus{Id: j.id, State: j.state, Restarts: j.restarts}
+	if j.lastErr != nil {
+		st.Error = j.lastErr.Error()
+	}
+	j.mu.Unlock()
+
+	if h, ok := j.container.Handler.(*defaultEventHandler); ok {
+		gtid, lag := h.Status()
+		st.Gtid = gtid
+		st.Lag = lag.Seconds()
+		if j.container.Heartbeat != nil {
+			lag, last, stalled := h.Heartbeat()
+			st.HeartbeatLag = lag.Seconds()
+			st.LastHeartbeat = last
+			st.Stalled = stalled
+		}
+	}
+	return st
+}
+
+// do stops the running job and applies fn before it starts again
+func (j *job) do(fn func() error) error {
+	a := action{fn: fn, done: make(chan error, 1)}
+	j.mu.Lock()
+	j.actions = append(j.actions, a)
+	if j.cancel != nil {
+		j.cancel()
+	}
+	j.mu.Unlock()
+
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case err := <-a.done:
+		return err
+	case <-j.exited:
+		return ErrJobStopped
+	}
+}
+
+func (j *job) checkpoint(gset string, force bool) error {
+	if _, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, gset); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrBadGTID, gset, err)
+	}
+	h, ok := j.container.Handler.(*defaultEventHandler)
+	if !ok {
+		return ErrNoHandler
+	}
+	if h.MasterInfo == nil {
+		h.MasterInfo = &masterInfo{}
+	}
+	dir := j.container.WorkDir
+	if err := h.MasterInfo.Init(&dir, j.id); err != nil {
+		return err
+	}
+	cur, err := h.MasterInfo.Load()
+	if err != nil {
+		return err
+	}
+	if cur != "" && !force {
+		return fmt.Errorf("canal %s position already set: %s", j.id, cur)
+	}
+	if err := h.MasterInfo.Save(gset); err != nil {
+		return err
+	}
+	j.gtid = nil
+	return h.MasterInfo.Close()
+}
+
+// startPrepare the Prepare of one start, j.mu is held
+func (j *job) startPrepare() Prepare {
+	prepare, snapshot := j.container.Prepare, j.snapshot
+	if !j.prepare || prepare == nil {
+		return nil
+	}
+	if !snapshot {
+		return prepare
+	}
+	return func(gtidSet GTIDSet, c *Container, tables []string) error {
+		empty, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, "")
+		if err != nil {
+			return err
+		}
+		if err := prepare(empty, c, tables); err != nil {
+			return err
+		}
+		return gtidSet.Update(empty.String())
+	}
+}
+
+func (j *job) setState(state JobState) {
+	j.mu.Lock()
+	j.state = state
+	j.mu.Unlock()
+}
+
+func (j *job) run(ctx context.Context) error {
+	logger := slog.New(log.SlogDefaultWithId(j.id))
+	defer close(j.exited)
+	defer j.setState(JobStopped)
+
+	initial := j.policy.Backoff
+	if initial <= 0 {
+		initial = time.Second
+	}
+	backoff := initial
+	maxBackoff := j.policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+
+	for {
+		j.mu.Lock()
+		for _, a := range j.actions {
+			a.done <- a.fn()
+		}
+		j.actions = nil
+		if j.paused {
+			j.state = JobPaused
+			j.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-j.wake:
+			}
+			continue
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		j.cancel = cancel
+		j.state = JobRunning
+		gtid := j.gtid
+		j.gtid = nil
+		container := j.container
+		container.Prepare = j.startPrepare()
+		j.mu.Unlock()
+
+		started := time.Now()
+		err := RunContext(runCtx, j.id, container, gtid...)
+		if time.Since(started) >= maxBackoff {
+			backoff = initial
+		}
+		// Prepare is done once the job got to replication
+		var jobErr *JobError
+		prepared := err == nil || errors.As(err, &jobErr) && jobErr.Op == "run"
+		// stopped by do, apply the actions and start again
+		interrupted := runCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+
+		j.mu.Lock()
+		if prepared {
+			j.prepare, j.snapshot = false, false
+		}
+		j.cancel = nil
+		if !IsCanceled(err) {
+			j.lastErr = err
+		}
+		j.mu.Unlock()
+
+		if ctx.Err() != nil {
+			if IsCanceled(err) {
+				return nil
+			}
+			return err
+		}
+		if interrupted {
+			continue
+		}
+
+		switch j.policy.Mode {
+		case RestartNever:
+			return err
+		case RestartOnFailure:
+			if err == nil {
+				return nil
+			}
+		}
+		if j.policy.MaxRestarts > 0 && j.restarts >= j.policy.MaxRestarts {
+			logger.Error("restart limit reached", "restarts", j.restarts, "error", err)
+			return err
+		}
+
+		j.mu.Lock()
+		j.restarts++
+		j.state = JobRestarting
+		j.mu.Unlock()
+
+		logger.Warn("restart", "after", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-j.wake:
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
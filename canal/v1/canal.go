@@ -41,6 +41,8 @@ type syncer struct {
 
 	syncCh chan interface{}
 	ctx    context.Context
+	// ctx of the caller, Run returns its error only
+	parent context.Context
 	canal  *canal.Canal
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
@@ -48,6 +50,10 @@ type syncer struct {
 	cfg    *canal.Config
 	path   string
 	ddlacl *ddlAcl
+
+	// first error saving or closing the master info, Run returns it
+	errOnce sync.Once
+	err     error
 }
 
 type ddlAcl struct {
@@ -141,10 +147,24 @@ func (f *filterTable) Exclude(table ...string) *filterTable {
 
 }
 
+// New is NewContext with a background context, it exits on error
 func New(id string, cfg Master, filter *filterTable) *syncer {
+	s, err := NewContext(context.Background(), id, cfg, filter)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return s
+}
 
-	s := &syncer{}
-	s.ctx, s.cancel = context.WithCancel(context.Background())
+// NewContext cancel ctx to stop Run, the syncer is closed and the master info saved
+func NewContext(ctx context.Context, id string, cfg Master, filter *filterTable) (*syncer, error) {
+
+	if filter == nil {
+		filter = FilterTable()
+	}
+	s := &syncer{parent: ctx}
+	s.ctx, s.cancel = context.WithCancel(ctx)
 	dialer := &net.Dialer{}
 	//streamHandler, _ := log.NewStreamHandler(os.Stdout)
 	config := &canal.Config{
@@ -164,8 +184,8 @@ func New(id string, cfg Master, filter *filterTable) *syncer {
 	}
 	c, err := canal.NewCanal(config)
 	if err != nil {
-		fmt.Print(err)
-		os.Exit(1)
+		s.cancel()
+		return nil, err
 	}
 
 	s.cfg = config
@@ -175,14 +195,15 @@ func New(id string, cfg Master, filter *filterTable) *syncer {
 	s.path = filepath.Join(cfg.StorePath, id)
 	master, err := loadMasterInfo(s.path)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		c.Close()
+		s.cancel()
+		return nil, err
 	}
 
 	s.master = master
 	c.SetEventHandler(&defaultHandler{syncer: s})
 
-	return s
+	return s, nil
 }
 
 // delete source code 90~93
@@ -208,8 +229,9 @@ func NewCanal(ctx context.Context, cancel context.CancelFunc, id, addr, user, pa
 	s := &syncer{canal: c, cfg: cfg}
 	s.syncCh = make(chan interface{}, 4096)
 
-	s.ctx = ctx
-	s.cancel = cancel
+	// cancel of the caller stops Run, Close only cancels the child
+	s.parent = ctx
+	s.ctx, s.cancel = context.WithCancel(ctx)
 
 	mpath := "."
 	if len(masterInfoPath) == 1 {
@@ -294,15 +316,25 @@ func (s *syncer) Run() error {
 
 	s.wg.Add(1)
 	go s.writeMasterInfo()
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			s.canal.Close()
+		case <-s.canal.Ctx().Done():
+		}
+	}()
 	gset, _ := mysql.ParseMysqlGTIDSet(s.master.GtidSet)
-	if err := s.canal.StartFromGTID(gset); err != nil {
-
-		s.Close()
+	err := s.canal.StartFromGTID(gset)
+	s.Close()
+	if err == nil {
+		err = s.err
+	}
+	if err != nil {
 		fmt.Println("Run", err)
 		return err
 	}
-
-	return nil
+	// Close cancels s.ctx, only a cancel of the caller is an error
+	return s.parent.Err()
 
 }
 
@@ -311,10 +343,31 @@ func (s *syncer) Ctx() context.Context {
 }
 func (s *syncer) Close() {
 
-	s.cancel()
+	// canal.Close reports the last position through OnPosSynced, drain it before the final save
 	s.canal.Close()
-	s.master.Close()
+	s.cancel()
 	s.wg.Wait()
+drain:
+	for {
+		select {
+		case v := <-s.syncCh:
+			if v, ok := v.(gsetSaver); ok {
+				if err := s.master.Save(v.gset); err != nil {
+					s.fail(fmt.Errorf("save sync gset %s: %w", v.gset, err))
+				}
+			}
+		default:
+			break drain
+		}
+	}
+	if err := s.master.Close(); err != nil {
+		s.fail(fmt.Errorf("close master info: %w", err))
+	}
+
+}
+
+func (s *syncer) fail(err error) {
+	s.errOnce.Do(func() { s.err = err })
 
 }
 
@@ -348,6 +401,7 @@ func (s *syncer) writeMasterInfo() {
 		if needSavePos {
 			if err := s.master.Save(gset); err != nil {
 				fmt.Printf("save sync gset %s err %v, close sync\n", gset, err)
+				s.fail(fmt.Errorf("save sync gset %s: %w", gset, err))
 				s.cancel()
 				return
 			}
@@ -49,6 +49,10 @@ func loadMasterInfo(dataDir string) (*masterInfo, error) {
 }
 
 func (m *masterInfo) Save(gset string) error {
+	return m.save(gset, false)
+}
+
+func (m *masterInfo) save(gset string, force bool) error {
 	m.Lock()
 	defer m.Unlock()
 	m.GtidSet = gset
@@ -57,7 +61,7 @@ func (m *masterInfo) Save(gset string) error {
 	}
 
 	n := time.Now()
-	if n.Sub(m.lastSaveTime) < time.Second {
+	if !force && n.Sub(m.lastSaveTime) < time.Second {
 		return nil
 	}
 
@@ -84,5 +88,5 @@ func (m *masterInfo) Gtidset() string {
 
 func (m *masterInfo) Close() error {
 	gset := m.Gtidset()
-	return m.Save(gset)
+	return m.save(gset, true)
 }
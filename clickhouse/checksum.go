@@ -0,0 +1,189 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/zhujintao/kit-go/mysql"
+)
+
+type checksumTarget struct {
+	conn driver.Conn
+	dml  *DmlClickhouse
+}
+
+// NewChecksumTarget compares a ReplacingMergeTree table written by DmlClickhouse,
+// rows are read with FINAL and deleted rows are skipped, dml default NewDml()
+//
+//	checker := &mysql.Checker{Source: src, Target: target, Repair: target.Repair}
+func NewChecksumTarget(conn driver.Conn, dml ...*DmlClickhouse) *checksumTarget {
+	t := &checksumTarget{conn: conn, dml: NewDml()}
+	if len(dml) > 0 && dml[0] != nil {
+		t.dml = dml[0]
+	}
+	return t
+}
+
+func (t *checksumTarget) Checksum(table *mysql.TableInfo, chunk mysql.Chunk) (mysql.ChunkSum, error) {
+	pk, err := pkColumn(table)
+	if err != nil {
+		return mysql.ChunkSum{}, err
+	}
+	exprs := make([]string, len(table.Columns))
+	for i := range table.Columns {
+		exprs[i] = "ifNull(" + checksumColumn(&table.Columns[i]) + ", '\\\\N')"
+	}
+	cfg := t.dml.Config()
+	where, args := chunk.Where(backQuote(pk.Name))
+	sql := "SELECT count(), groupBitXor(CRC32(concatWithSeparator('#', " + strings.Join(exprs, ", ") + "))) FROM " +
+		t.name(table) + " FINAL WHERE " + backQuote(cfg.SignKey) + " = ? AND " + where
+
+	var sum mysql.ChunkSum
+	var count uint64
+	var crc uint32
+	if err := t.conn.QueryRow(context.Background(), sql, append([]interface{}{cfg.SignInsert}, args...)...).Scan(&count, &crc); err != nil {
+		return sum, err
+	}
+	sum.Count = int64(count)
+	sum.Crc = uint64(crc)
+	return sum, nil
+}
+
+// Repair inserts the source rows and marks the rows only clickhouse has as deleted,
+// one INSERT for each, with the version of the dml source so the rows replicated
+// after the repair still win. VersionBinlogTs and VersionGtidSeq take the version
+// from the last SetEvent, pass the dml of the syncer
+func (t *checksumTarget) Repair(table *mysql.TableInfo, chunk mysql.Chunk, rows [][]interface{}) error {
+	ctx := context.Background()
+	pk, err := pkColumn(table)
+	if err != nil {
+		return err
+	}
+	cfg := t.dml.Config()
+	version, err := t.repairVersion()
+	if err != nil {
+		return err
+	}
+
+	pkIdx := table.PKColumns[0]
+	source := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		source[mysql.ValueToString(pk, row[pkIdx])] = true
+	}
+	if len(rows) > 0 {
+		sql, values := insertRows(cfg, table, rows, version)
+		if err := t.conn.Exec(ctx, sql, values...); err != nil {
+			return err
+		}
+	}
+
+	where, args := chunk.Where(backQuote(pk.Name))
+	r, err := t.conn.Query(ctx, "SELECT toString("+backQuote(pk.Name)+") FROM "+t.name(table)+" FINAL WHERE "+backQuote(cfg.SignKey)+" = ? AND "+where, append([]interface{}{cfg.SignInsert}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var extra []string
+	for r.Next() {
+		var id string
+		if err := r.Scan(&id); err != nil {
+			return err
+		}
+		if !source[id] {
+			extra = append(extra, id)
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	sql, values := deleteRows(cfg, table, pk, extra, version)
+	return t.conn.Exec(ctx, sql, values...)
+}
+
+// repairVersion version of the repaired rows, the event sources refuse to
+// repair before the first SetEvent, version 0 would lose to every row
+func (t *checksumTarget) repairVersion() (uint64, error) {
+	if t.dml.Config().Version != VersionWallClock {
+		t.dml.mu.Lock()
+		set := t.dml.timestamp != 0 || t.dml.gtidSeq != 0
+		t.dml.mu.Unlock()
+		if !set {
+			return 0, fmt.Errorf("repair needs the binlog event of the dml, SetEvent")
+		}
+	}
+	return t.dml.version(nil), nil
+}
+
+// insertRows one INSERT of all the rows, nil is written as NULL so every row has the same columns
+func insertRows(cfg DmlConfig, table *mysql.TableInfo, rows [][]interface{}, version uint64) (string, []interface{}) {
+	fields := make([]string, 0, len(table.Columns)+2)
+	for _, col := range table.Columns {
+		fields = append(fields, backQuote(col.Name))
+	}
+	fields = append(fields, backQuote(cfg.SignKey), backQuote(cfg.VersionKey))
+
+	tuples := make([]string, len(rows))
+	var values []interface{}
+	for i, row := range rows {
+		pos := make([]string, 0, len(fields))
+		for idx := range table.Columns {
+			if row[idx] == nil {
+				pos = append(pos, "NULL")
+				continue
+			}
+			pos = append(pos, "?")
+			values = append(values, mysql.ValueToString(&table.Columns[idx], row[idx]))
+		}
+		pos = append(pos, "?", "?")
+		values = append(values, cfg.SignInsert, version)
+		tuples[i] = "(" + strings.Join(pos, ", ") + ")"
+	}
+	return "INSERT INTO " + backQuote(table.Schema) + "." + backQuote(table.Name) + " (" + strings.Join(fields, ", ") + ") VALUES " + strings.Join(tuples, ", "), values
+}
+
+// deleteRows one INSERT of the delete markers of ids
+func deleteRows(cfg DmlConfig, table *mysql.TableInfo, pk *mysql.TableColumn, ids []string, version uint64) (string, []interface{}) {
+	tuples := make([]string, len(ids))
+	values := make([]interface{}, 0, 3*len(ids))
+	for i, id := range ids {
+		tuples[i] = "(?, ?, ?)"
+		values = append(values, id, cfg.SignDelete, version)
+	}
+	return "INSERT INTO " + backQuote(table.Schema) + "." + backQuote(table.Name) + " (" + backQuote(pk.Name) + ", " + backQuote(cfg.SignKey) + ", " + backQuote(cfg.VersionKey) + ") VALUES " + strings.Join(tuples, ", "), values
+}
+
+// checksumColumn the string of mysql.ChecksumKindOf, the same string the mysql source gives
+func checksumColumn(col *mysql.TableColumn) string {
+	name := backQuote(col.Name)
+	switch mysql.ChecksumKindOf(col) {
+	case mysql.ChecksumDecimal:
+		return "replaceRegexpOne(toString(" + name + "), '(\\\\.[0-9]*[1-9])0+$|\\\\.0+$', '\\\\1')"
+	case mysql.ChecksumFloat:
+		return fmt.Sprintf("toDecimalString(%s, %d)", name, mysql.ChecksumFloatScale)
+	case mysql.ChecksumDouble:
+		return fmt.Sprintf("toDecimalString(%s, %d)", name, mysql.ChecksumDoubleScale)
+	case mysql.ChecksumDateTime:
+		return "formatDateTime(" + name + ", '%F %T')"
+	}
+	return "toString(" + name + ")"
+}
+
+func (t *checksumTarget) name(table *mysql.TableInfo) string {
+	return backQuote(table.Schema) + "." + backQuote(table.Name)
+}
+
+func pkColumn(table *mysql.TableInfo) (*mysql.TableColumn, error) {
+	if len(table.PKColumns) != 1 {
+		return nil, fmt.Errorf("%s.%s needs a single column primary key", table.Schema, table.Name)
+	}
+	return table.GetPKColumn(0), nil
+}
+
+func backQuote(s string) string {
+	return "`" + s + "`"
+}
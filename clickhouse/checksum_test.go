@@ -0,0 +1,151 @@
+package clickhouse
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/zhujintao/kit-go/mysql"
+)
+
+type fakeConn struct {
+	driver.Conn
+	execs [][]interface{}
+	sqls  []string
+	// ids clickhouse has in the chunk
+	ids []string
+	// rows of Select, the values in the order of the struct fields
+	selects func(query string) [][]any
+}
+
+func (c *fakeConn) Select(ctx context.Context, dest any, query string, args ...any) error {
+	slice := reflect.ValueOf(dest).Elem()
+	for _, row := range c.selects(query) {
+		v := reflect.New(slice.Type().Elem()).Elem()
+		for i, value := range row {
+			v.Field(i).Set(reflect.ValueOf(value))
+		}
+		slice.Set(reflect.Append(slice, v))
+	}
+	return nil
+}
+
+func (c *fakeConn) Exec(ctx context.Context, query string, args ...any) error {
+	c.sqls = append(c.sqls, query)
+	c.execs = append(c.execs, args)
+	return nil
+}
+
+func (c *fakeConn) Query(ctx context.Context, query string, args ...any) (driver.Rows, error) {
+	return &fakeRows{ids: c.ids, i: -1}, nil
+}
+
+type fakeRows struct {
+	driver.Rows
+	ids []string
+	i   int
+}
+
+func (r *fakeRows) Next() bool {
+	r.i++
+	return r.i < len(r.ids)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	*dest[0].(*string) = r.ids[r.i]
+	return nil
+}
+
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Err() error   { return nil }
+
+func testTable() *mysql.TableInfo {
+	return &schema.Table{
+		Schema:    "db",
+		Name:      "t",
+		Columns:   []schema.TableColumn{{Name: "id", Type: schema.TYPE_NUMBER}, {Name: "name", Type: schema.TYPE_STRING}},
+		PKColumns: []int{0},
+	}
+}
+
+func TestRepairBatches(t *testing.T) {
+	conn := &fakeConn{ids: []string{"1", "2", "3"}}
+	target := NewChecksumTarget(conn)
+	rows := [][]interface{}{{int64(1), "a"}, {int64(2), nil}}
+	if err := target.Repair(testTable(), mysql.Chunk{Lo: "1", Hi: "3"}, rows); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if len(conn.sqls) != 2 {
+		t.Fatalf("execs: got %d, want one insert and one delete marker insert: %q", len(conn.sqls), conn.sqls)
+	}
+	want := "INSERT INTO `db`.`t` (`id`, `name`, `_del`, `_version`) VALUES (?, ?, ?, ?), (?, NULL, ?, ?)"
+	if conn.sqls[0] != want {
+		t.Errorf("insert:\n got %s\nwant %s", conn.sqls[0], want)
+	}
+	if got := len(conn.execs[0]); got != 7 {
+		t.Errorf("insert values: got %d, want 7", got)
+	}
+	want = "INSERT INTO `db`.`t` (`id`, `_del`, `_version`) VALUES (?, ?, ?)"
+	if conn.sqls[1] != want {
+		t.Errorf("delete:\n got %s\nwant %s", conn.sqls[1], want)
+	}
+	if got := conn.execs[1]; got[0] != "3" || got[1] != 1 {
+		t.Errorf("delete values: got %v, want id 3 with _del 1", got)
+	}
+}
+
+func TestRepairVersion(t *testing.T) {
+	for _, source := range []VersionSource{VersionBinlogTs, VersionGtidSeq} {
+		conn := &fakeConn{}
+		dml := NewDml(DmlConfig{Version: source})
+		target := NewChecksumTarget(conn, dml)
+		rows := [][]interface{}{{int64(1), "a"}}
+
+		if err := target.Repair(testTable(), mysql.Chunk{}, rows); err == nil || !strings.Contains(err.Error(), "SetEvent") {
+			t.Errorf("source %d: repair before SetEvent got %v, want an error", source, err)
+		}
+
+		dml.SetEvent(1700000000, 100)
+		if err := target.Repair(testTable(), mysql.Chunk{}, rows); err != nil {
+			t.Fatalf("source %d: Repair: %v", source, err)
+		}
+		values := conn.execs[len(conn.execs)-1]
+		repaired := values[len(values)-1].(uint64)
+
+		// the next event of the binlog, in the same second for VersionBinlogTs
+		if source == VersionGtidSeq {
+			dml.SetEvent(1700000000, 101)
+		}
+		update := dml.Update(testTable(), []interface{}{int64(1), "a"}, []interface{}{int64(1), "b"})
+		after := update[1].([]interface{})[1].([]interface{})
+		replicated := after[len(after)-1].(uint64)
+		if replicated <= repaired {
+			t.Errorf("source %d: replicated version %d, repaired %d, the update must win", source, replicated, repaired)
+		}
+	}
+}
+
+func TestChecksumColumn(t *testing.T) {
+	tests := []struct {
+		col  mysql.TableColumn
+		want string
+	}{
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_STRING, RawType: "varchar(20)"}, "toString(`c`)"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_DATE, RawType: "date"}, "toString(`c`)"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_SET, RawType: "set('a','b')"}, "toString(`c`)"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_BIT, RawType: "bit(8)"}, "toString(`c`)"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"}, "replaceRegexpOne(toString(`c`), '(\\\\.[0-9]*[1-9])0+$|\\\\.0+$', '\\\\1')"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_FLOAT, RawType: "float"}, "toDecimalString(`c`, 4)"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_FLOAT, RawType: "double"}, "toDecimalString(`c`, 6)"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_DATETIME, RawType: "datetime(3)"}, "formatDateTime(`c`, '%F %T')"},
+		{mysql.TableColumn{Name: "c", Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"}, "formatDateTime(`c`, '%F %T')"},
+	}
+	for _, tt := range tests {
+		if got := checksumColumn(&tt.col); got != tt.want {
+			t.Errorf("checksumColumn(%s): got %s, want %s", tt.col.RawType, got, tt.want)
+		}
+	}
+}
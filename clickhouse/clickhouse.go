@@ -1,6 +1,7 @@
 package clickhouse
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"slices"
@@ -65,6 +66,7 @@ type table struct {
 	columns     []*column
 	storage     string
 	versionName string
+	dml         DmlConfig
 	orders      []string
 	partition   string
 	ddlAction   ast.AlterTableType
@@ -72,8 +74,8 @@ type table struct {
 
 func InErrCode(err error, code ...int32) bool {
 
-	errCode, ok := err.(*clickhouse.Exception)
-	if !ok {
+	var errCode *clickhouse.Exception
+	if !errors.As(err, &errCode) {
 		return false
 	}
 	return slices.Contains(code, errCode.Code)
@@ -82,12 +84,16 @@ func InErrCode(err error, code ...int32) bool {
 
 // parser ddl, dml
 func ParserMysqlSQL(sql string) (string, error) {
+	return parserMysqlSQL(sql, DmlConfig{}.withDefaults())
+}
+
+func parserMysqlSQL(sql string, cfg DmlConfig) (string, error) {
 	pr := parser.New()
 	stmt, err := pr.ParseOneStmt(sql, "", "")
 	if err != nil {
 		return "", err
 	}
-	t := &table{}
+	t := &table{dml: cfg}
 	var sb strings.Builder
 	s := format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)
 
@@ -403,10 +409,16 @@ func buildCreateTable(t *table, st *ast.CreateTableStmt, s *format.RestoreCtx) {
 
 	}
 	s.WritePlain(",\n")
-	s.WritePlainf("  INDEX %s %s TYPE minmax GRANULARITY 1", versionKey, versionKey)
+	s.WritePlainf("  INDEX %s %s TYPE minmax GRANULARITY 1", t.dml.VersionKey, t.dml.VersionKey)
 	s.WritePlainf("\n)\n")
 	s.WriteKeyWord("ENGINE ")
-	s.WritePlainf("%s(%s,%s)", t.storage, versionKey, delKey)
+	if t.dml.replacing() {
+		s.WritePlainf("%s(%s,%s)", t.storage, t.dml.VersionKey, t.dml.SignKey)
+	} else {
+		// the -1/1 sign is not an is_deleted column, the cancel row of an update has
+		// the version of the new row, which is inserted after it and kept
+		s.WritePlainf("%s(%s)", t.storage, t.dml.VersionKey)
+	}
 	s.WritePlain("\n")
 
 	if t.partition != "" {
@@ -432,7 +444,9 @@ func buildCreateTable(t *table, st *ast.CreateTableStmt, s *format.RestoreCtx) {
 		}
 		s.WritePlain(")")
 	}
-	s.WritePlain("SETTINGS allow_experimental_replacing_merge_with_cleanup=1")
+	if t.dml.replacing() {
+		s.WritePlain("\nSETTINGS allow_experimental_replacing_merge_with_cleanup=1")
+	}
 }
 
 func getName(table *table, t *ast.TableName) {
@@ -507,11 +521,15 @@ func getColumns(table *table, cols []*ast.ColumnDef) {
 }
 
 func addVersionColumn(table *table) {
-	//sign_colName := getUniqueColumnName(table.colpos, delKey)
-	//version_colName := getUniqueColumnName(table.colpos, versionKey)
-	table.columns = append(table.columns, &column{name: delKey, dataType: "UInt8 MATERIALIZED 1", scale: types.UnspecifiedLength, precision: types.UnspecifiedLength})
-	table.columns = append(table.columns, &column{name: versionKey, dataType: "UInt64 MATERIALIZED 1", scale: types.UnspecifiedLength, precision: types.UnspecifiedLength})
-	table.versionName = versionKey
+	//sign_colName := getUniqueColumnName(table.colpos, table.dml.SignKey)
+	//version_colName := getUniqueColumnName(table.colpos, table.dml.VersionKey)
+	signType := "UInt8"
+	if table.dml.SignDelete < 0 || table.dml.SignInsert < 0 {
+		signType = "Int8"
+	}
+	table.columns = append(table.columns, &column{name: table.dml.SignKey, dataType: fmt.Sprintf("%s MATERIALIZED %d", signType, table.dml.SignInsert), scale: types.UnspecifiedLength, precision: types.UnspecifiedLength})
+	table.columns = append(table.columns, &column{name: table.dml.VersionKey, dataType: "UInt64 MATERIALIZED 1", scale: types.UnspecifiedLength, precision: types.UnspecifiedLength})
+	table.versionName = table.dml.VersionKey
 }
 
 func getUniqueColumnName(cols map[string]int, prefix string) string {
@@ -24,20 +24,19 @@ type Config struct {
 
 type Batch = driver.Batch
 
+// NewClient nil when the connection can't be opened, New returns the error
 func NewClient(cfg *Config) driver.Conn {
-	c := &clickhouse.Options{}
-	if cfg.Options != nil {
-		c = cfg.Options
-	}
-	c.Addr = cfg.Addr
-	c.Auth.Username = cfg.User
-	c.Auth.Password = cfg.Password
-	c.Compression = &clickhouse.Compression{
-		Method: clickhouse.CompressionLZ4,
+	conn, err := open(cfg, nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
-	c.Settings = clickhouse.Settings{"insert_allow_materialized_columns": true}
+	return conn
+
+}
 
-	conn, err := clickhouse.Open(c)
+func NewClientViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) driver.Conn {
+	conn, err := openViaSSH(sshAddr, sshUser, sshPassword, cfg)
 	if err != nil {
 		fmt.Println(err)
 		return nil
@@ -46,12 +45,11 @@ func NewClient(cfg *Config) driver.Conn {
 
 }
 
-func NewClientViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) driver.Conn {
+func openViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) (driver.Conn, error) {
 
 	sshcon, err := ssh.NewConn(sshAddr, sshUser, sshPassword)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 
 	go func() {
@@ -65,15 +63,21 @@ func NewClientViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) driver.C
 		}
 	}()
 
+	return open(cfg, func(ctx context.Context, addr string) (net.Conn, error) {
+		return sshcon.Dial("tcp", addr)
+	})
+}
+
+// cfg.Settings are added to insert_allow_materialized_columns, cfg.Options is copied
+func open(cfg *Config, dial func(ctx context.Context, addr string) (net.Conn, error)) (driver.Conn, error) {
 	c := &clickhouse.Options{}
 	if cfg.Options != nil {
-		c = cfg.Options
+		opts := *cfg.Options
+		c = &opts
 	}
-
-	c.DialContext = func(ctx context.Context, addr string) (net.Conn, error) {
-		return sshcon.Dial("tcp", addr)
+	if dial != nil {
+		c.DialContext = dial
 	}
-
 	c.Addr = cfg.Addr
 	c.Auth.Username = cfg.User
 	c.Auth.Password = cfg.Password
@@ -81,12 +85,9 @@ func NewClientViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) driver.C
 		Method: clickhouse.CompressionLZ4,
 	}
 	c.Settings = clickhouse.Settings{"insert_allow_materialized_columns": true}
-
-	conn, err := clickhouse.Open(c)
-	if err != nil {
-		fmt.Println(err)
-		return nil
+	for k, v := range cfg.Settings {
+		c.Settings[k] = v
 	}
-	return conn
 
+	return clickhouse.Open(c)
 }
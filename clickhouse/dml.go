@@ -2,57 +2,153 @@ package clickhouse
 
 import (
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zhujintao/kit-go/mysql"
 )
 
+// VersionSource of the version column. The sources have different units, the
+// versions of one source don't order against another: the wall clock is in
+// microseconds, VersionBinlogTs is the event second * 1e6 plus a counter and
+// VersionGtidSeq restarts at 1 for every server UUID. Switching the source, or
+// the source server UUID with VersionGtidSeq, needs a resync of the tables
+type VersionSource int
+
+const (
+	// microseconds of the local clock when the row is built
+	VersionWallClock VersionSource = iota
+	// binlog event timestamp in microseconds plus a counter of the versions already
+	// given in that second by this instance, so later rows of one second still win
+	VersionBinlogTs
+	// transaction sequence number of the GTID
+	VersionGtidSeq
+)
+
+// DmlConfig sign and version columns of the rows written by DmlClickhouse,
+// the DDL generator, checksum and maintenance read the same config
+type DmlConfig struct {
+	// default _del
+	SignKey string
+	// default _version
+	VersionKey string
+	// sign of deleted rows, default 1
+	SignDelete int
+	// sign of inserted rows, default 0
+	SignInsert int
+	Version    VersionSource
+}
+
+// OldVerConfig _sign -1/1 rows, tables are ReplacingMergeTree(_version) and
+// the rows with _sign -1 are filtered out by the readers
+var OldVerConfig = DmlConfig{SignKey: "_sign", VersionKey: "_version", SignDelete: -1, SignInsert: 1}
+
+func (c DmlConfig) withDefaults() DmlConfig {
+	if c.SignKey == "" {
+		c.SignKey = "_del"
+	}
+	if c.VersionKey == "" {
+		c.VersionKey = "_version"
+	}
+	if c.SignDelete == 0 && c.SignInsert == 0 {
+		c.SignDelete = 1
+	}
+	return c
+}
+
+// replacing _del 1/0 is the is_deleted column of ReplacingMergeTree
+func (c DmlConfig) replacing() bool {
+	return c.SignDelete == 1 && c.SignInsert == 0
+}
+
 type DmlClickhouse struct {
 	mysql.DmlInterface
-	delKey     string
-	versionKey string
-	dataDelete int
-	dataInsert int
+	config DmlConfig
+
+	mu sync.Mutex
+	// event of the rows built next, set by SetEvent
+	timestamp uint32
+	gtidSeq   int64
+	// last second given by DataVersion and the versions given in it
+	second uint32
+	n      uint64
 }
 
-var (
-	delKey     = "_del"
-	versionKey = "_version"
-	dataDelete = 1
-	dataInsert = 0
-)
+// NewDml config default _del 1/0 and _version from the wall clock
+func NewDml(config ...DmlConfig) *DmlClickhouse {
+	d := &DmlClickhouse{}
+	if len(config) > 0 {
+		d.config = config[0]
+	}
+	d.config = d.config.withDefaults()
+	return d
+}
 
+// UseOldVerFlag switches this instance to OldVerConfig, keeping the version source
 func (d *DmlClickhouse) UseOldVerFlag() {
+	version := d.config.Version
+	d.config = OldVerConfig
+	d.config.Version = version
+}
 
-	delKey = "_sign"
-	versionKey = "_version"
-	dataDelete = -1
-	dataInsert = 1
+func (d *DmlClickhouse) Config() DmlConfig {
+	return d.config.withDefaults()
+}
 
+// SetEvent binlog event timestamp and GTID sequence of the rows built next by Insert,
+// Update and Delete without a data version, needed by VersionBinlogTs and VersionGtidSeq
+func (d *DmlClickhouse) SetEvent(timestamp uint32, gtidSeq int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timestamp, d.gtidSeq = timestamp, gtidSeq
 }
 
-func (d *DmlClickhouse) Insert(tableInfo *mysql.TableInfo, row []interface{}, dataVersion ...uint64) (string, []interface{}) {
-	time.Now().UnixMicro()
-	dv := uint64(time.Now().UnixMicro())
+// DataVersion of a row from the binlog event timestamp and the GTID sequence, by the version source
+func (d *DmlClickhouse) DataVersion(timestamp uint32, gtidSeq int64) uint64 {
+	switch d.config.Version {
+	case VersionBinlogTs:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if timestamp != d.second {
+			d.second, d.n = timestamp, 0
+		} else if d.n < 1e6-1 {
+			d.n++
+		}
+		return uint64(timestamp)*1e6 + d.n
+	case VersionGtidSeq:
+		return uint64(gtidSeq)
+	}
+	return uint64(time.Now().UnixMicro())
+}
+
+// version given by the caller, or by the version source from the event of SetEvent
+func (d *DmlClickhouse) version(dataVersion []uint64) uint64 {
 	if len(dataVersion) == 1 {
-		dv = dataVersion[0]
+		return dataVersion[0]
 	}
-	return onCkInsert(tableInfo, row, false, 0, dv)
+	d.mu.Lock()
+	timestamp, gtidSeq := d.timestamp, d.gtidSeq
+	d.mu.Unlock()
+	return d.DataVersion(timestamp, gtidSeq)
+}
+
+func (d *DmlClickhouse) Insert(tableInfo *mysql.TableInfo, row []interface{}, dataVersion ...uint64) (string, []interface{}) {
+	cfg := d.Config()
+	dv := d.version(dataVersion)
+	return onCkInsert(cfg, tableInfo, row, false, cfg.SignInsert, dv)
 
 }
 
 func (d *DmlClickhouse) Update(tableInfo *mysql.TableInfo, beforeRows, afterRows []interface{}, dataVersion ...uint64) []interface{} {
 
-	dv := uint64(time.Now().UnixMicro())
-	if len(dataVersion) == 1 {
-		dv = dataVersion[0]
-	}
+	cfg := d.Config()
+	dv := d.version(dataVersion)
 
 	var l []interface{}
-	s, v := onCkInsert(tableInfo, beforeRows, false, dataDelete, dv)
+	s, v := onCkInsert(cfg, tableInfo, beforeRows, false, cfg.SignDelete, dv)
 	del := []interface{}{s, v}
 	l = append(l, del)
-	s, v = onCkInsert(tableInfo, afterRows, true, dataInsert, dv)
+	s, v = onCkInsert(cfg, tableInfo, afterRows, true, cfg.SignInsert, dv)
 	ins := []interface{}{s, v}
 	l = append(l, ins)
 
@@ -61,12 +157,10 @@ func (d *DmlClickhouse) Update(tableInfo *mysql.TableInfo, beforeRows, afterRows
 
 func (d *DmlClickhouse) Delete(tableInfo *mysql.TableInfo, row []interface{}, dataVersion ...uint64) (string, []interface{}) {
 
-	dv := uint64(time.Now().UnixMicro())
-	if len(dataVersion) == 1 {
-		dv = dataVersion[0]
-	}
+	cfg := d.Config()
+	dv := d.version(dataVersion)
 
-	return onCkInsert(tableInfo, row, false, dataDelete, dv)
+	return onCkInsert(cfg, tableInfo, row, false, cfg.SignDelete, dv)
 
 }
 
@@ -84,7 +178,7 @@ func ParseInsertValue(tableInfo *mysql.TableInfo, row []interface{}, addNull boo
 	return value
 
 }
-func onCkInsert(tableInfo *mysql.TableInfo, row []interface{}, addNull bool, isdel int, version uint64) (string, []interface{}) {
+func onCkInsert(cfg DmlConfig, tableInfo *mysql.TableInfo, row []interface{}, addNull bool, isdel int, version uint64) (string, []interface{}) {
 
 	db := tableInfo.Schema
 	table := tableInfo.Name
@@ -109,75 +203,24 @@ func onCkInsert(tableInfo *mysql.TableInfo, row []interface{}, addNull bool, isd
 	field = mysql.DelNilS(field)
 	pos = mysql.DelNilS(pos)
 	pos = append(pos, "?", "?")
-	field = append(field, delKey, versionKey)
+	field = append(field, cfg.SignKey, cfg.VersionKey)
 	value = append(value, isdel, version)
 	sql := "insert into " + db + "." + table + " (" + strings.Join(field, ",") + ") values (" + strings.Join(pos, ",") + ")"
 	return sql, value
 }
 
-func (d *DmlClickhouse) update2(tableInfo *mysql.TableInfo, beforeRows, afterRows []interface{}) (string, []interface{}) {
-	dataVersion := time.Now().Unix()
-	row := beforeRows
-	db := tableInfo.Schema
-	table := tableInfo.Name
-
-	field := make([]string, len(row))
-	apos := make([]string, len(row))
-	bpos := make([]string, len(row))
-
-	avalue := make([]interface{}, len(row))
-	bvalue := make([]interface{}, len(row))
-	var values []interface{}
-
-	for idx, col := range tableInfo.Columns {
-
-		//if reflect.DeepEqual(beforeRows[idx], afterRows[idx]) {
-		//	continue
-		//}
-		field[idx] = col.Name
-		bvstr := mysql.ValueToString(&tableInfo.Columns[idx], beforeRows[idx])
-		bvalue[idx] = bvstr
-		bpos[idx] = "?"
-
-		if beforeRows[idx] == nil {
-			bpos[idx] = "NULL"
-			bvalue[idx] = nil
-		}
-
-		avstr := mysql.ValueToString(&tableInfo.Columns[idx], afterRows[idx])
-		avalue[idx] = avstr
-		apos[idx] = "?"
-		if afterRows[idx] == nil {
-			apos[idx] = "NULL"
-			avalue[idx] = nil
-		}
+// GetOptimizeTable clauses are written between the table and FINAL, like " ON CLUSTER c"
+func (d *DmlClickhouse) GetOptimizeTable(db, table string, clauses ...string) string {
 
+	sql := "OPTIMIZE TABLE " + db + "." + table + strings.Join(clauses, "")
+	if !d.Config().replacing() {
+		return sql + " FINAL"
 	}
-	field = mysql.DelNilS(field)
-
-	bvalue = mysql.DelNilI(bvalue)
-	avalue = mysql.DelNilI(avalue)
-
-	bpos = mysql.DelNilS(bpos)
-	apos = mysql.DelNilS(apos)
-
-	bpos = append(bpos, "?", "?")
-	apos = append(apos, "?", "?")
-
-	field = append(field, delKey, versionKey)
-
-	bvalue = append(bvalue, dataDelete, dataVersion)
-	avalue = append(avalue, dataInsert, dataVersion)
-
-	sql := "insert into " + db + "." + table + " (" + strings.Join(field, ",") + ") values (" + strings.Join(bpos, ",") + "),(" + strings.Join(apos, ",") + ")"
-
-	values = append(values, bvalue...)
-	values = append(values, avalue...)
-	return sql, values
+	return sql + " FINAL CLEANUP"
 
 }
-func (d *DmlClickhouse) GetOptimizeTable(db, table string) string {
-
-	return "OPTIMIZE TABLE " + db + "." + table + " FINAL CLEANUP"
 
+// ParserMysqlSQL ddl with the sign and version columns of this instance
+func (d *DmlClickhouse) ParserMysqlSQL(sql string) (string, error) {
+	return parserMysqlSQL(sql, d.Config())
 }
@@ -0,0 +1,157 @@
+package clickhouse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+const testCreateTable = "CREATE TABLE db.t (id int NOT NULL AUTO_INCREMENT, name varchar(20) DEFAULT NULL, PRIMARY KEY (id)) COMMENT 'users'"
+
+func TestDmlConfigDDL(t *testing.T) {
+	tests := []struct {
+		name     string
+		dml      *DmlClickhouse
+		want     string
+		optimize string
+	}{
+		{
+			name: "default",
+			dml:  NewDml(),
+			want: "CREATE TABLE `db`.`t` (\n`id` Int32,\n`name` Nullable(String),\n`_del` UInt8 MATERIALIZED 0,\n`_version` UInt64 MATERIALIZED 1,\n" +
+				"  INDEX _version _version TYPE minmax GRANULARITY 1\n)\nENGINE ReplacingMergeTree(_version,_del)\nPARTITION BY intDiv(id,4294967)\n" +
+				"ORDER BY tuple(id)\nSETTINGS allow_experimental_replacing_merge_with_cleanup=1",
+			optimize: "OPTIMIZE TABLE db.t ON CLUSTER c FINAL CLEANUP",
+		},
+		{
+			name: "old version flag",
+			dml:  NewDml(OldVerConfig),
+			want: "CREATE TABLE `db`.`t` (\n`id` Int32,\n`name` Nullable(String),\n`_sign` Int8 MATERIALIZED 1,\n`_version` UInt64 MATERIALIZED 1,\n" +
+				"  INDEX _version _version TYPE minmax GRANULARITY 1\n)\nENGINE ReplacingMergeTree(_version)\nPARTITION BY intDiv(id,4294967)\n" +
+				"ORDER BY tuple(id)",
+			optimize: "OPTIMIZE TABLE db.t ON CLUSTER c FINAL",
+		},
+		{
+			name: "custom columns",
+			dml:  NewDml(DmlConfig{SignKey: "is_deleted", VersionKey: "ver"}),
+			want: "CREATE TABLE `db`.`t` (\n`id` Int32,\n`name` Nullable(String),\n`is_deleted` UInt8 MATERIALIZED 0,\n`ver` UInt64 MATERIALIZED 1,\n" +
+				"  INDEX ver ver TYPE minmax GRANULARITY 1\n)\nENGINE ReplacingMergeTree(ver,is_deleted)\nPARTITION BY intDiv(id,4294967)\n" +
+				"ORDER BY tuple(id)\nSETTINGS allow_experimental_replacing_merge_with_cleanup=1",
+			optimize: "OPTIMIZE TABLE db.t ON CLUSTER c FINAL CLEANUP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.dml.ParserMysqlSQL(testCreateTable)
+			if err != nil {
+				t.Fatalf("ParserMysqlSQL: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ddl:\n got %s\nwant %s", got, tt.want)
+			}
+			if got := tt.dml.GetOptimizeTable("db", "t", " ON CLUSTER c"); got != tt.optimize {
+				t.Errorf("optimize: got %s, want %s", got, tt.optimize)
+			}
+		})
+	}
+}
+
+// two instances with different configs don't change each other
+func TestDmlConfigPerInstance(t *testing.T) {
+	old := NewDml()
+	old.UseOldVerFlag()
+	d := NewDml()
+	if got := d.Config(); got.SignKey != "_del" || got.SignDelete != 1 || got.SignInsert != 0 {
+		t.Errorf("default config changed by UseOldVerFlag of another instance: %+v", got)
+	}
+	if got := old.Config(); got.SignKey != "_sign" || got.SignDelete != -1 || got.SignInsert != 1 {
+		t.Errorf("UseOldVerFlag config: got %+v", got)
+	}
+}
+
+func TestDmlRows(t *testing.T) {
+	table := &schema.Table{Schema: "db", Name: "t", Columns: []schema.TableColumn{
+		{Name: "id", Type: schema.TYPE_NUMBER},
+		{Name: "name", Type: schema.TYPE_STRING},
+	}}
+	before := []interface{}{int32(1), "a"}
+	after := []interface{}{int32(1), "b"}
+
+	tests := []struct {
+		name       string
+		dml        *DmlClickhouse
+		wantSql    string
+		wantUpdate [][]interface{}
+	}{
+		{
+			name:       "default",
+			dml:        NewDml(),
+			wantSql:    "insert into db.t (id,name,_del,_version) values (?,?,?,?)",
+			wantUpdate: [][]interface{}{{"1", "a", 1, uint64(7)}, {"1", "b", 0, uint64(7)}},
+		},
+		{
+			name:       "old version flag",
+			dml:        NewDml(OldVerConfig),
+			wantSql:    "insert into db.t (id,name,_sign,_version) values (?,?,?,?)",
+			wantUpdate: [][]interface{}{{"1", "a", -1, uint64(7)}, {"1", "b", 1, uint64(7)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := tt.dml.Insert(table, after, 7)
+			if sql != tt.wantSql {
+				t.Errorf("insert: got %s, want %s", sql, tt.wantSql)
+			}
+			if !reflect.DeepEqual(args, tt.wantUpdate[1]) {
+				t.Errorf("insert args: got %v, want %v", args, tt.wantUpdate[1])
+			}
+			rows := tt.dml.Update(table, before, after, 7)
+			if len(rows) != 2 {
+				t.Fatalf("update: got %d rows, want 2", len(rows))
+			}
+			for i, row := range rows {
+				got := row.([]interface{})[1]
+				if !reflect.DeepEqual(got, tt.wantUpdate[i]) {
+					t.Errorf("update row %d: got %v, want %v", i, got, tt.wantUpdate[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDataVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version VersionSource
+		events  [][2]int64
+		want    []uint64
+	}{
+		{
+			name:    "binlog timestamp",
+			version: VersionBinlogTs,
+			events:  [][2]int64{{100, 0}, {100, 0}, {100, 0}, {101, 0}},
+			want:    []uint64{100e6, 100e6 + 1, 100e6 + 2, 101e6},
+		},
+		{
+			name:    "gtid sequence",
+			version: VersionGtidSeq,
+			events:  [][2]int64{{100, 7}, {100, 8}},
+			want:    []uint64{7, 8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDml(DmlConfig{Version: tt.version})
+			table := &schema.Table{Schema: "db", Name: "t", Columns: []schema.TableColumn{{Name: "id", Type: schema.TYPE_NUMBER}}}
+			for i, e := range tt.events {
+				d.SetEvent(uint32(e[0]), e[1])
+				_, args := d.Insert(table, []interface{}{int32(1)})
+				if got := args[len(args)-1]; got != tt.want[i] {
+					t.Errorf("version of event %d: got %v, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
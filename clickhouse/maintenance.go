@@ -0,0 +1,341 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Window daily time range in local time, End before Start spans midnight
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow "01:00-05:00"
+func ParseWindow(s string) (Window, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q must be hh:mm-hh:mm", s)
+	}
+	var w Window
+	for i, v := range []string{start, end} {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return Window{}, fmt.Errorf("window %q: %w", s, err)
+		}
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.Start = d
+		} else {
+			w.End = d
+		}
+	}
+	return w, nil
+}
+
+func (w Window) Contains(t time.Time) bool {
+	y, m, d := t.Date()
+	now := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.Start <= w.End {
+		return now >= w.Start && now < w.End
+	}
+	return now >= w.Start || now < w.End
+}
+
+// Stuck a mutation not done or a merge running for longer than Maintenance.StuckAfter
+type Stuck struct {
+	Kind     string // mutation or merge
+	Database string
+	Table    string
+	// mutation_id or the result part of the merge
+	Id      string
+	Command string
+	Elapsed time.Duration
+	// progress 0-1 of a merge
+	Progress float64
+	// parts a mutation has left
+	PartsToDo int64
+	Reason    string
+}
+
+func (s Stuck) String() string {
+	if s.Kind == "mutation" {
+		return fmt.Sprintf("%s %s.%s %s elapsed %s parts to do %d %s %s", s.Kind, s.Database, s.Table, s.Id, s.Elapsed.Truncate(time.Second), s.PartsToDo, s.Command, s.Reason)
+	}
+	return fmt.Sprintf("%s %s.%s %s elapsed %s progress %g %s %s", s.Kind, s.Database, s.Table, s.Id, s.Elapsed.Truncate(time.Second), s.Progress, s.Command, s.Reason)
+}
+
+// Maintenance runs OPTIMIZE ... FINAL CLEANUP on the ReplacingMergeTree tables written by
+// DmlClickhouse and watches for stuck mutations and merges
+//
+//	w, _ := clickhouse.ParseWindow("01:00-05:00")
+//	m := &clickhouse.Maintenance{Client: c, Windows: []clickhouse.Window{w}}
+//	go m.Run(ctx)
+type Maintenance struct {
+	Client *Client
+	// db.table, empty is every ReplacingMergeTree table with the sign column of Dml
+	Tables []string
+	// OPTIMIZE only runs inside a window, empty is always
+	Windows []Window
+	// between rounds, default 1h
+	Interval time.Duration
+	// partitions with fewer active parts are skipped unless they still hold
+	// deleted rows that CLEANUP removes, default 2
+	MinParts int
+	// default 1h
+	StuckAfter time.Duration
+	// default logs a warning
+	OnStuck func(Stuck)
+	// ON CLUSTER of OPTIMIZE and CREATE VIEW
+	Cluster string
+	// sign column of the tables, default NewDml()
+	Dml *DmlClickhouse
+}
+
+func (m *Maintenance) defaults() {
+	if m.Interval <= 0 {
+		m.Interval = time.Hour
+	}
+	if m.MinParts <= 0 {
+		m.MinParts = 2
+	}
+	if m.StuckAfter <= 0 {
+		m.StuckAfter = time.Hour
+	}
+	if m.Dml == nil {
+		m.Dml = NewDml()
+	}
+	if m.OnStuck == nil {
+		m.OnStuck = func(s Stuck) {
+			slog.Warn("clickhouse stuck", "kind", s.Kind, "table", s.Database+"."+s.Table, "id", s.Id, "elapsed", s.Elapsed.Truncate(time.Second), "reason", s.Reason)
+		}
+	}
+}
+
+// Run a round every Interval until ctx is done
+func (m *Maintenance) Run(ctx context.Context) error {
+	m.defaults()
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Optimize(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("clickhouse optimize", "error", err)
+		}
+		list, err := m.Stuck(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("clickhouse stuck check", "error", err)
+		}
+		for _, s := range list {
+			m.OnStuck(s)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Maintenance) inWindow(t time.Time) bool {
+	if len(m.Windows) == 0 {
+		return true
+	}
+	for _, w := range m.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Maintenance) onCluster() string {
+	if m.Cluster == "" {
+		return ""
+	}
+	return " ON CLUSTER " + backQuote(m.Cluster)
+}
+
+// ReplacingTables the tables of Tables, or every ReplacingMergeTree table with the sign column
+func (m *Maintenance) ReplacingTables(ctx context.Context) ([][2]string, error) {
+	m.defaults()
+	if len(m.Tables) > 0 {
+		var list [][2]string
+		for _, t := range m.Tables {
+			db, table, ok := strings.Cut(t, ".")
+			if !ok {
+				return nil, fmt.Errorf("table %s must be db.table", t)
+			}
+			list = append(list, [2]string{db, table})
+		}
+		return list, nil
+	}
+
+	var rows []struct {
+		Database string `ch:"database"`
+		Name     string `ch:"name"`
+	}
+	err := m.Client.Select(ctx, &rows, "SELECT database, name FROM system.tables WHERE engine LIKE '%ReplacingMergeTree' "+
+		"AND (database, name) IN (SELECT database, table FROM system.columns WHERE name = ?) ORDER BY database, name", m.Dml.Config().SignKey)
+	if err != nil {
+		return nil, err
+	}
+	list := make([][2]string, len(rows))
+	for i, r := range rows {
+		list[i] = [2]string{r.Database, r.Name}
+	}
+	return list, nil
+}
+
+// Optimize the partitions with at least MinParts active parts or with deleted rows,
+// stops when the window closes, a failed partition is logged and skipped
+func (m *Maintenance) Optimize(ctx context.Context) error {
+	m.defaults()
+	if !m.inWindow(time.Now()) {
+		return nil
+	}
+	tables, err := m.ReplacingTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		parts, err := m.partitions(ctx, t[0], t[1])
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Error("clickhouse optimize", "table", t[0]+"."+t[1], "error", err)
+			continue
+		}
+		for _, p := range parts {
+			if !m.inWindow(time.Now()) {
+				slog.Info("clickhouse optimize window closed")
+				return nil
+			}
+			start := time.Now()
+			sql := m.Dml.GetOptimizeTable(backQuote(t[0]), backQuote(t[1]), m.onCluster(), " PARTITION ID '"+p.Partition+"'")
+			if err := m.Client.Exec(ctx, sql); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// the other partitions are still optimized
+				slog.Error("clickhouse optimize", "sql", sql, "error", err)
+				continue
+			}
+			slog.Info("clickhouse optimize", "table", t[0]+"."+t[1], "partition", p.Partition, "parts", p.Parts, "elapsed", time.Since(start).Truncate(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+type partition struct {
+	Partition string `ch:"partition_id"`
+	Parts     uint64 `ch:"parts"`
+}
+
+// partitions to optimize, with CLEANUP a single part partition is merged
+// while it has deleted rows
+func (m *Maintenance) partitions(ctx context.Context, db, table string) ([]partition, error) {
+	var all []partition
+	err := m.Client.Select(ctx, &all, "SELECT partition_id, count() AS parts FROM system.parts WHERE database = ? AND table = ? AND active "+
+		"GROUP BY partition_id ORDER BY partition_id", db, table)
+	if err != nil {
+		return nil, err
+	}
+	deleted := map[string]bool{}
+	if cfg := m.Dml.Config(); cfg.replacing() {
+		var rows []struct {
+			Partition string `ch:"partition_id"`
+		}
+		err := m.Client.Select(ctx, &rows, "SELECT DISTINCT _partition_id AS partition_id FROM "+backQuote(db)+"."+backQuote(table)+
+			" WHERE "+backQuote(cfg.SignKey)+" = ?", cfg.SignDelete)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			deleted[r.Partition] = true
+		}
+	}
+	var list []partition
+	for _, p := range all {
+		if p.Parts >= uint64(m.MinParts) || deleted[p.Partition] {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
+
+// Stuck mutations not done and merges running for longer than StuckAfter
+func (m *Maintenance) Stuck(ctx context.Context) ([]Stuck, error) {
+	m.defaults()
+	after := uint64(m.StuckAfter / time.Second)
+
+	var mutations []struct {
+		Database string    `ch:"database"`
+		Table    string    `ch:"table"`
+		Id       string    `ch:"mutation_id"`
+		Command  string    `ch:"command"`
+		Created  time.Time `ch:"create_time"`
+		PartsDo  int64     `ch:"parts_to_do"`
+		Reason   string    `ch:"latest_fail_reason"`
+	}
+	err := m.Client.Select(ctx, &mutations, "SELECT database, table, mutation_id, command, create_time, parts_to_do, latest_fail_reason "+
+		"FROM system.mutations WHERE NOT is_done AND create_time < now() - toIntervalSecond(?)", after)
+	if err != nil {
+		return nil, err
+	}
+	var list []Stuck
+	for _, r := range mutations {
+		list = append(list, Stuck{Kind: "mutation", Database: r.Database, Table: r.Table, Id: r.Id, Command: r.Command,
+			Elapsed: time.Since(r.Created), PartsToDo: r.PartsDo, Reason: r.Reason})
+	}
+
+	var merges []struct {
+		Database string  `ch:"database"`
+		Table    string  `ch:"table"`
+		Part     string  `ch:"result_part_name"`
+		Elapsed  float64 `ch:"elapsed"`
+		Progress float64 `ch:"progress"`
+		Mutation uint8   `ch:"is_mutation"`
+	}
+	err = m.Client.Select(ctx, &merges, "SELECT database, table, result_part_name, elapsed, progress, is_mutation FROM system.merges WHERE elapsed > ?", after)
+	if err != nil {
+		return list, err
+	}
+	for _, r := range merges {
+		cmd := "merge"
+		if r.Mutation == 1 {
+			cmd = "mutation"
+		}
+		list = append(list, Stuck{Kind: "merge", Database: r.Database, Table: r.Table, Id: r.Part, Command: cmd,
+			Elapsed: time.Duration(r.Elapsed * float64(time.Second)), Progress: r.Progress})
+	}
+	return list, nil
+}
+
+// CreateViews db.table<suffix> for every table, SELECT * ... FINAL WHERE _del = 0, suffix default _view
+func (m *Maintenance) CreateViews(ctx context.Context, suffix ...string) error {
+	m.defaults()
+	cfg := m.Dml.Config()
+	sfx := "_view"
+	if len(suffix) > 0 {
+		sfx = suffix[0]
+	}
+	tables, err := m.ReplacingTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if strings.HasSuffix(t[1], sfx) {
+			continue
+		}
+		sql := fmt.Sprintf("CREATE OR REPLACE VIEW %s.%s%s AS SELECT * FROM %s.%s FINAL WHERE %s = %d",
+			backQuote(t[0]), backQuote(t[1]+sfx), m.onCluster(), backQuote(t[0]), backQuote(t[1]), backQuote(cfg.SignKey), cfg.SignInsert)
+		if err := m.Client.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("%s: %w", sql, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package clickhouse
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOptimizePartitions(t *testing.T) {
+	tests := []struct {
+		name string
+		dml  *DmlClickhouse
+		want []string
+	}{
+		// 202401 has two parts, 202402 one part with deleted rows, 202403 one clean part
+		{"cleanup", NewDml(), []string{"202401", "202402"}},
+		// FINAL without CLEANUP keeps the deleted rows, one part is already merged
+		{"old version flag", NewDml(OldVerConfig), []string{"202401"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{selects: func(query string) [][]any {
+				switch {
+				case strings.Contains(query, "FROM system.tables"):
+					return [][]any{{"db", "t"}}
+				case strings.Contains(query, "FROM system.parts"):
+					return [][]any{{"202401", uint64(2)}, {"202402", uint64(1)}, {"202403", uint64(1)}}
+				case strings.Contains(query, "_partition_id"):
+					return [][]any{{"202402"}}
+				}
+				t.Fatalf("unexpected select %s", query)
+				return nil
+			}}
+			m := &Maintenance{Client: &Client{Conn: conn}, Dml: tt.dml}
+			if err := m.Optimize(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if len(conn.sqls) != len(tt.want) {
+				t.Fatalf("optimized: got %q, want partitions %v", conn.sqls, tt.want)
+			}
+			for i, p := range tt.want {
+				if !strings.Contains(conn.sqls[i], "PARTITION ID '"+p+"'") {
+					t.Errorf("optimize %d: got %s, want partition %s", i, conn.sqls[i], p)
+				}
+			}
+		})
+	}
+}
+
+func TestStuckString(t *testing.T) {
+	s := Stuck{Kind: "mutation", Database: "db", Table: "t", Id: "0000000001", PartsToDo: 3, Command: "DELETE WHERE 1"}
+	if got := s.String(); !strings.Contains(got, "parts to do 3") {
+		t.Errorf("mutation: got %s, want the parts to do", got)
+	}
+	s = Stuck{Kind: "merge", Database: "db", Table: "t", Id: "all_1_2_1", Progress: 0.5, Command: "merge"}
+	if got := s.String(); !strings.Contains(got, "progress 0.5") {
+		t.Errorf("merge: got %s, want the progress", got)
+	}
+}
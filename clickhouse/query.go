@@ -0,0 +1,213 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	chdriver "github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type QueryOption = clickhouse.QueryOption
+type Progress = clickhouse.Progress
+type ProfileInfo = clickhouse.ProfileInfo
+type Parameters = clickhouse.Parameters
+type Settings = clickhouse.Settings
+
+// per call options, pass them among the query args
+//
+//	c.Exec(ctx, "ALTER TABLE t DELETE WHERE id = {id:UInt64}",
+//		clickhouse.WithParameters(clickhouse.Parameters{"id": "1"}),
+//		clickhouse.WithSettings(clickhouse.Settings{"mutations_sync": 1}))
+var (
+	WithSettings    = clickhouse.WithSettings
+	WithParameters  = clickhouse.WithParameters
+	WithQueryID     = clickhouse.WithQueryID
+	WithProgress    = clickhouse.WithProgress
+	WithProfileInfo = clickhouse.WithProfileInfo
+)
+
+// exception codes retried by Client
+//
+//	159 TIMEOUT_EXCEEDED, 202 TOO_MANY_SIMULTANEOUS_QUERIES, 209 SOCKET_TIMEOUT, 210 NETWORK_ERROR,
+//	242 TABLE_IS_READ_ONLY, 252 TOO_MANY_PARTS, 285 TOO_FEW_LIVE_REPLICAS,
+//	319 UNKNOWN_STATUS_OF_INSERT, 425 SYSTEM_ERROR, 999 KEEPER_EXCEPTION
+var RetryableCodes = []int32{159, 202, 209, 210, 242, 252, 285, 319, 425, 999}
+
+// exception codes of RetryableCodes after which the statement may have run,
+// retried only when idempotent
+var unknownStatusCodes = []int32{159, 209, 210, 319, 425, 999}
+
+// Client returns errors instead of printing them and retries retryable errors
+type Client struct {
+	Conn chdriver.Conn
+	// retries after the first attempt, default 3, -1 disables
+	Retries int
+	// wait before the first retry, doubled every retry, default 200ms
+	Backoff time.Duration
+	// retried besides RetryableCodes
+	RetryCodes []int32
+}
+
+func New(cfg *Config) (*Client, error) {
+	conn, err := open(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Conn: conn}, nil
+}
+
+func NewViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) (*Client, error) {
+	conn, err := openViaSSH(sshAddr, sshUser, sshPassword, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
+
+// Exec an INSERT, ALTER or any statement that is not a read is retried only
+// when it failed before it was sent
+func (c *Client) Exec(ctx context.Context, query string, args ...any) error {
+	ctx, args = withOptions(ctx, args)
+	return c.retry(ctx, idempotent(query), func() error {
+		return c.Conn.Exec(ctx, query, args...)
+	})
+}
+
+// Query only the start of the query is retried, close the rows
+func (c *Client) Query(ctx context.Context, query string, args ...any) (chdriver.Rows, error) {
+	ctx, args = withOptions(ctx, args)
+	var rows chdriver.Rows
+	err := c.retry(ctx, true, func() error {
+		var err error
+		rows, err = c.Conn.Query(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// Select scans all rows into dest, a pointer to a slice of structs with `ch:"column"` tags
+func (c *Client) Select(ctx context.Context, dest any, query string, args ...any) error {
+	ctx, args = withOptions(ctx, args)
+	return c.retry(ctx, true, func() error {
+		return c.Conn.Select(ctx, dest, query, args...)
+	})
+}
+
+// Get scans the first row into the struct pointer dest, sql.ErrNoRows when empty
+func (c *Client) Get(ctx context.Context, dest any, query string, args ...any) error {
+	ctx, args = withOptions(ctx, args)
+	return c.retry(ctx, true, func() error {
+		return c.Conn.QueryRow(ctx, query, args...).ScanStruct(dest)
+	})
+}
+
+// Each calls fn with dest filled by every row, dest is a struct pointer with `ch:"column"` tags
+func (c *Client) Each(ctx context.Context, dest any, fn func() error, query string, args ...any) error {
+	rows, err := c.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.ScanStruct(dest); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (c *Client) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	retries := c.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = 200 * time.Millisecond
+	}
+	var err error
+	for i := 0; ; i++ {
+		err = fn()
+		if err == nil || i >= retries || !c.retryable(err, idempotent) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Retryable exceptions of RetryableCodes and RetryCodes, and broken connections
+func (c *Client) Retryable(err error) bool {
+	return c.retryable(err, true)
+}
+
+// retryable a statement that is not idempotent only when it was not sent, or
+// rejected by an exception after which it did not run
+func (c *Client) retryable(err error, idempotent bool) bool {
+	if InErrCode(err, c.RetryCodes...) {
+		return true
+	}
+	if InErrCode(err, RetryableCodes...) {
+		return idempotent || !InErrCode(err, unknownStatusCodes...)
+	}
+	if unsent(err) {
+		return true
+	}
+	var ne net.Error
+	return idempotent && (errors.Is(err, io.EOF) || errors.Is(err, driver.ErrBadConn) || errors.As(err, &ne))
+}
+
+// unsent errors of getting a connection, before the statement is written
+func unsent(err error) bool {
+	var oe *net.OpError
+	return errors.Is(err, clickhouse.ErrAcquireConnTimeout) || errors.As(err, &oe) && oe.Op == "dial"
+}
+
+// idempotent reads are retried after the connection broke
+func idempotent(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	i := strings.IndexFunc(query, func(r rune) bool { return !unicode.IsLetter(r) })
+	if i < 0 {
+		i = len(query)
+	}
+	switch strings.ToUpper(query[:i]) {
+	case "SELECT", "WITH", "SHOW", "DESC", "DESCRIBE", "EXISTS", "EXPLAIN":
+		return true
+	}
+	return false
+}
+
+// withOptions moves the QueryOption args to the context
+func withOptions(ctx context.Context, args []any) (context.Context, []any) {
+	var opts []clickhouse.QueryOption
+	var rest []any
+	for _, arg := range args {
+		if opt, ok := arg.(clickhouse.QueryOption); ok {
+			opts = append(opts, opt)
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(opts) == 0 {
+		return ctx, args
+	}
+	return clickhouse.Context(ctx, opts...), rest
+}
@@ -0,0 +1,89 @@
+package clickhouse
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+func TestRetryable(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	read := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+	tests := []struct {
+		name string
+		err  error
+		// want for a read and for an insert
+		read, write bool
+	}{
+		{name: "too many parts", err: &clickhouse.Exception{Code: 252}, read: true, write: true},
+		{name: "unknown status of insert", err: &clickhouse.Exception{Code: 319}, read: true, write: false},
+		{name: "network error", err: &clickhouse.Exception{Code: 210}, read: true, write: false},
+		{name: "syntax error", err: &clickhouse.Exception{Code: 62}, read: false, write: false},
+		{name: "dial", err: fmt.Errorf("acquire: %w", dial), read: true, write: true},
+		{name: "acquire timeout", err: clickhouse.ErrAcquireConnTimeout, read: true, write: true},
+		{name: "read", err: read, read: true, write: false},
+		{name: "eof", err: io.EOF, read: true, write: false},
+		{name: "bad conn", err: driver.ErrBadConn, read: true, write: false},
+	}
+	c := &Client{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.retryable(tt.err, true); got != tt.read {
+				t.Errorf("retryable(read) got %v, want %v", got, tt.read)
+			}
+			if got := c.retryable(tt.err, false); got != tt.write {
+				t.Errorf("retryable(write) got %v, want %v", got, tt.write)
+			}
+		})
+	}
+
+	c.RetryCodes = []int32{319}
+	if !c.retryable(&clickhouse.Exception{Code: 319}, false) {
+		t.Errorf("RetryCodes must be retried for writes too")
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT 1", true},
+		{"  select * from t", true},
+		{"(SELECT 1) UNION ALL (SELECT 2)", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"SHOW TABLES", true},
+		{"DESCRIBE t", true},
+		{"EXISTS TABLE t", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"ALTER TABLE t DELETE WHERE 1", false},
+		{"OPTIMIZE TABLE t FINAL", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := idempotent(tt.query); got != tt.want {
+			t.Errorf("idempotent(%q) got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestOpenCopiesOptions(t *testing.T) {
+	opts := &Options{Settings: Settings{"max_threads": 2}}
+	conn, err := New(&Config{Addr: []string{"127.0.0.1:1"}, User: "u", Options: opts, Settings: Settings{"async_insert": 1}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer conn.Close()
+	if len(opts.Addr) != 0 || opts.Auth.Username != "" || opts.Compression != nil {
+		t.Errorf("caller Options changed: %+v", opts)
+	}
+	if len(opts.Settings) != 1 || opts.Settings["max_threads"] != 2 {
+		t.Errorf("caller Settings changed: %v", opts.Settings)
+	}
+}
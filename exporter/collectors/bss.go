@@ -0,0 +1,55 @@
+package collectors
+
+import (
+	"errors"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/aliyun/bss"
+	"github.com/zhujintao/kit-go/exporter"
+)
+
+func init() {
+	c := exporter.NewCollector("bss")
+	c.AddFlag(&cli.StringFlag{Name: "access-key-id", Usage: "aliyun access key` `"}, true)
+	c.AddFlag(&cli.StringFlag{Name: "access-key-secret", Usage: "aliyun access key secret` `"}, true)
+	// bills are updated hourly at most
+	c.SetMinInterval(time.Hour).SetTimeout(time.Minute)
+	c.Do(func(c *exporter.Collector) error {
+		client := bss.NewClient(str(c, "access-key-id"), str(c, "access-key-secret"))
+		if client == nil {
+			return errors.New("collector [bss] create client failed")
+		}
+		c.CallFunc(func(metric *exporter.Metric) {
+			if amount := client.GetAvailableAmount(); amount != nil {
+				metric.Create("aliyun_bss_available", "amount").SetHelp("available amount of the account").SendGauge(*amount)
+			} else {
+				metric.Error(errors.New("query account balance failed"))
+			}
+
+			now := time.Now()
+			cycle := now.Format("2006-01")
+			for product, amount := range pretaxAmount(client.GetBillMonthiy(cycle)) {
+				metric.Create("aliyun_bss_bill_monthly", "amount").SetLabel("cycle", cycle).SetLabel("product", product).SetHelp("pretax amount of the billing cycle").SendGauge(amount)
+			}
+			day := now.AddDate(0, 0, -1).Format(time.DateOnly)
+			for product, amount := range pretaxAmount(client.GetBillDaily(day)) {
+				metric.Create("aliyun_bss_bill_daily", "amount").SetLabel("day", day).SetLabel("product", product).SetHelp("pretax amount of yesterday").SendGauge(amount)
+			}
+		})
+		return nil
+	})
+	c.Register("aliyun account balance and bills")
+}
+
+// pretaxAmount per product code
+func pretaxAmount(items []*bss.BillItem) map[string]float64 {
+	sum := map[string]float64{}
+	for _, item := range items {
+		if item.ProductCode == nil || item.PretaxAmount == nil {
+			continue
+		}
+		sum[*item.ProductCode] += float64(*item.PretaxAmount)
+	}
+	return sum
+}
@@ -0,0 +1,35 @@
+// Package collectors registers the built-in collectors of the kit, each is enabled
+// with its flag and configured by the flags prefixed with its name
+//
+//	import _ "github.com/zhujintao/kit-go/exporter/collectors"
+//
+//	exporter.NewApp("kit_exporter").Run()
+//
+//	kit_exporter --mysql --mysql-addr 10.0.0.3:3306 --mysql-password xxx
+package collectors
+
+import (
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/exporter"
+)
+
+// sshFlag addr,user,password of an ssh jump host
+func sshFlag(c *exporter.Collector) {
+	c.AddFlag(&cli.StringFlag{Name: "ssh", Usage: "via ssh, addr,user,password` `"})
+}
+
+func str(c *exporter.Collector, flagName string) string {
+	s, _ := c.GetValue(flagName).(string)
+	return s
+}
+
+// viaSsh of sshFlag, empty when not set
+func viaSsh(c *exporter.Collector) []string {
+	s := strings.SplitN(str(c, "ssh"), ",", 3)
+	if len(s) != 3 {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package collectors
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/exporter"
+	"github.com/zhujintao/kit-go/idrac"
+)
+
+func init() {
+	c := exporter.NewCollector("idrac")
+	c.AddFlag(&cli.StringFlag{Name: "host", Usage: "idrac url, https://10.0.0.2` `"}, true)
+	c.AddFlag(&cli.StringFlag{Name: "user", Value: "root", Usage: "` `"})
+	c.AddFlag(&cli.StringFlag{Name: "password", Usage: "` `"})
+	c.Do(func(c *exporter.Collector) error {
+		client := idrac.NewClient(str(c, "host"), str(c, "user"), str(c, "password"))
+		if !client.Login() {
+			return fmt.Errorf("collector [idrac] login %s failed", str(c, "host"))
+		}
+		c.CallFunc(func(metric *exporter.Metric) {
+			fields := []string{"pwState", "sensortype", "eventLogEntries"}
+			data := client.GetData(fields...)
+			// the session expired
+			if data == nil && client.Login() {
+				data = client.GetData(fields...)
+			}
+			if data == nil {
+				metric.Error(fmt.Errorf("idrac get data failed"))
+				return
+			}
+
+			metric.Create("idrac_power", "on").SetHelp("power state, 1 on").SendGauge(float64(data.PowerState))
+			for _, list := range data.Sensortype.ThresholdSensorList {
+				for _, s := range list.Sensor {
+					metric.Create("idrac_sensor", "").SetLabel("name", s.Name).SetLabel("units", s.Units).SetHelp("reading of the threshold sensors").SendGauge(float64(s.Reading))
+					metric.Create("idrac_sensor", "ok").SetLabel("name", s.Name).SetHelp("sensor status, 1 normal").SendGauge(normal(s.SensorStatus))
+				}
+			}
+			for _, s := range data.Sensortype.DiscreteSensorList.Sensor {
+				metric.Create("idrac_sensor", "ok").SetLabel("name", s.Name).SetHelp("sensor status, 1 normal").SendGauge(normal(s.SensorStatus))
+			}
+			sel := map[string]int{}
+			for _, e := range data.EventLogEntries {
+				sel[e.Severity]++
+			}
+			for severity, n := range sel {
+				metric.Create("idrac_sel", "entries").SetLabel("severity", severity).SetHelp("system event log entries").SendGauge(float64(n))
+			}
+		})
+		return nil
+	})
+	c.Register("dell idrac power state, sensors and system event log")
+}
+
+func normal(status string) float64 {
+	if status == "Normal" {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package collectors
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/exporter"
+	"github.com/zhujintao/kit-go/mysql"
+)
+
+func init() {
+	c := exporter.NewCollector("mysql")
+	c.AddFlag(&cli.StringFlag{Name: "addr", Usage: "replica addr, 10.0.0.3:3306` `"}, true)
+	c.AddFlag(&cli.StringFlag{Name: "user", Value: "root", Usage: "` `"})
+	c.AddFlag(&cli.StringFlag{Name: "password", Usage: "` `"})
+	c.Do(func(c *exporter.Collector) error {
+		conn := mysql.NewClient(&mysql.Config{Addr: str(c, "addr"), User: str(c, "user"), Password: str(c, "password")})
+		c.CallFunc(func(metric *exporter.Metric) {
+			ctx, cancel := context.WithTimeout(metric.Context(), 5*time.Second)
+			defer cancel()
+			list, err := conn.ReplicaStatus(ctx)
+			if err != nil {
+				metric.Error(err)
+				return
+			}
+			for _, st := range list {
+				source := net.JoinHostPort(st.SourceHost, strconv.Itoa(st.SourcePort))
+				replica := func(name, unit string) *exporter.Metric {
+					return metric.Create(name, unit).SetLabel("channel", st.Channel).SetLabel("source", source)
+				}
+				running := 0.0
+				if st.Running() {
+					running = 1
+				}
+				replica("mysql_replica", "running").SetHelp("both replication threads are running").SendGauge(running)
+				if st.SecondsBehindSource >= 0 {
+					replica("mysql_replica_lag", "seconds").SetHelp("Seconds_Behind_Source").SendGauge(float64(st.SecondsBehindSource))
+				}
+				replica("mysql_replica_last_errno", "").SetLabel("thread", "io").SetHelp("Last_IO_Errno and Last_SQL_Errno").SendGauge(float64(st.LastIOErrno))
+				replica("mysql_replica_last_errno", "").SetLabel("thread", "sql").SetHelp("Last_IO_Errno and Last_SQL_Errno").SendGauge(float64(st.LastSQLErrno))
+			}
+		})
+		return nil
+	})
+	c.Register("mysql replication lag")
+}
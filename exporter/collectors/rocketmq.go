@@ -0,0 +1,53 @@
+package collectors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/exporter"
+	"github.com/zhujintao/kit-go/rocketmq"
+)
+
+func init() {
+	c := exporter.NewCollector("rocketmq")
+	c.AddFlag(&cli.StringFlag{Name: "namesrv", Usage: "nameserver addr, 10.0.0.4:9876` `"}, true)
+	c.AddFlag(&cli.StringSliceFlag{Name: "topic", Usage: "topics, all when not set` `"})
+	c.Do(func(c *exporter.Collector) error {
+		ns := rocketmq.NewNameServer(str(c, "namesrv"))
+		if ns == nil {
+			return fmt.Errorf("collector [rocketmq] nameserver %s unavailable", str(c, "namesrv"))
+		}
+		c.CallFunc(func(metric *exporter.Metric) {
+			topics, _ := c.GetValue("topic").([]string)
+			if len(topics) == 0 {
+				topics = ns.ListTopic()
+			}
+			for _, topic := range topics {
+				if strings.HasPrefix(topic, "%RETRY%") || strings.HasPrefix(topic, "%DLQ%") || strings.HasPrefix(topic, "RMQ_SYS_") {
+					continue
+				}
+				offset := map[string]bool{}
+				for _, group := range ns.GetTopicConsumeGroupList(topic) {
+					queues, err := ns.ConsumeOffsets(group, topic)
+					if err != nil {
+						metric.Error(err)
+						continue
+					}
+					var lag, brokerOffset int64
+					for _, q := range queues {
+						lag += q.Lag()
+						brokerOffset += q.BrokerOffset
+					}
+					metric.Create("rocketmq_consumer", "lag").SetLabel("topic", topic).SetLabel("group", group).SetHelp("messages not consumed by the group").SendGauge(float64(lag))
+					if !offset[topic] {
+						offset[topic] = true
+						metric.Create("rocketmq_topic", "offset").SetLabel("topic", topic).SetHelp("sum of the max offsets of the queues").SendCounter(float64(brokerOffset))
+					}
+				}
+			}
+		})
+		return nil
+	})
+	c.Register("rocketmq topic offset and consumer lag")
+}
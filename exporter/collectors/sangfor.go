@@ -0,0 +1,43 @@
+package collectors
+
+import (
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/exporter"
+	"github.com/zhujintao/kit-go/sangfor"
+)
+
+func init() {
+	c := exporter.NewCollector("sangfor")
+	c.AddFlag(&cli.StringFlag{Name: "url", Usage: "sangfor url, https://10.0.0.1` `"}, true)
+	c.AddFlag(&cli.StringFlag{Name: "user", Value: "admin", Usage: "` `"})
+	c.AddFlag(&cli.StringFlag{Name: "password", Usage: "` `"})
+	sshFlag(c)
+	c.Do(func(c *exporter.Collector) error {
+		client, err := sangfor.Login(str(c, "url"), str(c, "user"), str(c, "password"), viaSsh(c)...)
+		if err != nil {
+			return err
+		}
+		c.CallFunc(func(metric *exporter.Metric) {
+			list := client.FetchBandwidth()
+			// nothing when the session expired
+			if len(list) == 0 {
+				relogin, err := sangfor.Login(str(c, "url"), str(c, "user"), str(c, "password"), viaSsh(c)...)
+				if err != nil {
+					metric.Error(err)
+					return
+				}
+				client = relogin
+				list = client.FetchBandwidth()
+			}
+			for _, b := range list {
+				line := strconv.Itoa(b.Line)
+				metric.Create("sangfor_bandwidth", "bytes").SetLabel("ip", b.Ip).SetLabel("line", line).SetLabel("direction", "up").SetHelp("realtime bandwidth per second").SendGauge(b.Up)
+				metric.Create("sangfor_bandwidth", "bytes").SetLabel("ip", b.Ip).SetLabel("line", line).SetLabel("direction", "down").SetHelp("realtime bandwidth per second").SendGauge(b.Down)
+			}
+		})
+		return nil
+	})
+	c.Register("sangfor realtime bandwidth per ip")
+}
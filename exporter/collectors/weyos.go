@@ -0,0 +1,35 @@
+package collectors
+
+import (
+	"github.com/urfave/cli/v3"
+	"github.com/zhujintao/kit-go/exporter"
+	"github.com/zhujintao/kit-go/weyos"
+)
+
+func init() {
+	c := exporter.NewCollector("weyos")
+	c.AddFlag(&cli.StringFlag{Name: "url", Usage: "router url, http://192.168.0.1` `"}, true)
+	c.AddFlag(&cli.StringFlag{Name: "password", Usage: "` `"})
+	sshFlag(c)
+	c.Do(func(c *exporter.Collector) error {
+		client, err := weyos.NewClient(str(c, "url"), str(c, "password"), viaSsh(c)...)
+		if err != nil {
+			return err
+		}
+		c.CallFunc(func(metric *exporter.Metric) {
+			for _, t := range client.GetTrafficstats() {
+				host := func(name, unit string) *exporter.Metric {
+					return metric.Create(name, unit).SetLabel("host", t.Name).SetLabel("ip", t.Ip).SetLabel("mac", t.Mac)
+				}
+				host("weyos_host_speed", "").SetLabel("direction", "up").SetHelp("realtime speed as reported by the router").SendGauge(t.SpeedUpload)
+				host("weyos_host_speed", "").SetLabel("direction", "down").SetHelp("realtime speed as reported by the router").SendGauge(t.SpeedDownload)
+				host("weyos_host_traffic", "total").SetLabel("direction", "up").SetHelp("traffic since online as reported by the router").SendCounter(t.DataUploadTotal)
+				host("weyos_host_traffic", "total").SetLabel("direction", "down").SetHelp("traffic since online as reported by the router").SendCounter(t.DataDownloadTotal)
+				host("weyos_host_connections", "").SetHelp("connections of the host").SendGauge(float64(t.ConnectCount))
+				host("weyos_host_online", "seconds").SetHelp("online time of the host").SendGauge(float64(t.OnlineTime))
+			}
+		})
+		return nil
+	})
+	c.Register("weyos router traffic per host")
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +33,11 @@ var (
 
 		Flags: []cli.Flag{
 			&cli.StringFlag{Name: "listen", Value: ":2121", Usage: "expose metrics and web interface` `", Destination: &listen},
+			&cli.StringFlag{Name: "mode", Value: modeScrape, Usage: "scrape, push or both` `", Destination: &mode},
+			&cli.StringFlag{Name: "push-url", Usage: "remote write url, http://prometheus:9090/api/v1/write` `", Destination: &pushURL},
+			&cli.StringFlag{Name: "push-user", Usage: "remote write basic auth` `", Destination: &pushUser},
+			&cli.StringFlag{Name: "push-password", Usage: "remote write basic auth` `", Destination: &pushPassword},
+			&cli.DurationFlag{Name: "push-interval", Value: 15 * time.Second, Usage: "collect and push every` `", Destination: &pushInterval},
 		},
 		CustomRootCommandHelpTemplate: help_v3,
 		Usage:                         "a metrics all in one kit, base prometheus.",
@@ -46,22 +52,29 @@ type exporter struct {
 func (exporter) Describe(ch chan<- *prometheus.Desc) {}
 func (e *exporter) Collect(ch chan<- prometheus.Metric) {
 
-	metric := NewMetric(ch)
-	metric.Create(e.name, "up").SendWithoutNs(prometheus.GaugeValue, 1)
-	metric = nil
 	var wg sync.WaitGroup
-
+	var mu sync.Mutex
+	up := 1.0
 	for name, c := range collectors {
 
 		wg.Add(1)
 		go func(name string, c *Collector) {
 			defer wg.Done()
-			c.exec(ch)
+			if !c.exec(ch) {
+				mu.Lock()
+				up = 0
+				mu.Unlock()
+			}
 		}(name, c)
 
 	}
 	wg.Wait()
 
+	// up is 0 when a collector failed, see <ns>_collector_success
+	metric := NewMetric(ch)
+	metric.Create(e.name, "up").SendWithoutNs(prometheus.GaugeValue, up)
+	metric = nil
+
 }
 
 type Collector struct {
@@ -69,18 +82,49 @@ type Collector struct {
 	fn   []func(*Collector) error
 	//metric   *Metric
 	callFunc []func(metric *Metric)
+
+	timeout     time.Duration
+	minInterval time.Duration
+
+	// mu serializes runs, scrape and push may collect at once
+	mu        sync.Mutex
+	cache     []prometheus.Metric
+	lastRun   time.Time
+	success   bool
+	duration  time.Duration
+	lastErr   error
+	lastErrAt time.Time
+	// closed when the CallFunc of the last run returned, they may outlive its timeout
+	inflight chan struct{}
 }
 
 func NewCollector(name string) *Collector {
 	return &Collector{
-		name: name,
+		name:    name,
+		timeout: 10 * time.Second,
 		//metric: newMetric(),
 	}
 }
+
+// SetTimeout of all CallFunc of a run, default 10s, 0 waits, flag --name-timeout
+func (c *Collector) SetTimeout(d time.Duration) *Collector {
+	c.timeout = d
+	return c
+}
+
+// SetMinInterval scrapes within d of the last run get its cached metrics, default 0 always runs,
+// flag --name-min-interval
+func (c *Collector) SetMinInterval(d time.Duration) *Collector {
+	c.minInterval = d
+	return c
+}
 func (c *Collector) Do(fn func(*Collector) error) *Collector {
 	c.fn = append(c.fn, fn)
 	return c
 }
+
+// CallFunc fn runs every collect, calls that can block take metric.Context(),
+// done at the timeout of the run
 func (c *Collector) CallFunc(fn func(metric *Metric)) *Collector {
 
 	c.callFunc = append(c.callFunc, fn)
@@ -107,45 +151,107 @@ func (c *Collector) GetValue(flagName string) interface{} {
 	return app.Value(c.name + "-" + flagName)
 }
 
-func (c *Collector) exec(ch chan<- prometheus.Metric) {
+// exec reports whether the run, or the cached one, succeeded
+func (c *Collector) exec(ch chan<- prometheus.Metric) bool {
 
-	//c.metric.ch = ch
-	if len(c.callFunc) == 0 {
-		return
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.minInterval <= 0 || c.lastRun.IsZero() || time.Since(c.lastRun) >= c.minInterval {
+		c.run()
+	}
+	for _, m := range c.cache {
+		ch <- m
+	}
+
+	metric := NewMetric(ch)
+	success := 0.0
+	if c.success {
+		success = 1
+	}
+	metric.Create("collector", "success").SetLabel("collector", c.name).SetHelp("whether the collector succeeded").SendGauge(success)
+	metric.Create("collector", "duration_seconds").SetLabel("collector", c.name).SetHelp("duration of the collector").SendGauge(c.duration.Seconds())
+	if c.lastErr != nil {
+		metric.Create("collector", "last_error").SetLabel("collector", c.name).SetLabel("error", strings.ReplaceAll(c.lastErr.Error(), "\n", "; ")).
+			SetHelp("unix time of the last error of the collector").SendGauge(float64(c.lastErrAt.Unix()))
 	}
-	metric := &Metric{desc: make(map[string]*prometheus.Desc), labelName: make([]string, 100), labelValue: make([]string, 100), ch: ch}
+	metric = nil
+	return c.success
+}
+
+// run calls the CallFunc into c.cache, c.mu is held. A run is skipped while
+// the CallFunc of the previous one are still running, so they don't pile up
+func (c *Collector) run() {
+
+	start := time.Now()
+	if c.inflight != nil {
+		select {
+		case <-c.inflight:
+		default:
+			c.cache = nil
+			c.lastRun = start
+			c.duration = 0
+			c.success = false
+			c.lastErr, c.lastErrAt = fmt.Errorf("previous run still in flight"), start
+			fmt.Println("collector", c.name+":", c.lastErr)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	out := &collected{}
 	var wg sync.WaitGroup
 	for _, f := range c.callFunc {
 		wg.Add(1)
-		go func(f func(metric *Metric), m *Metric) {
+		go func(f func(metric *Metric)) {
 			defer wg.Done()
-			ctx, done := context.WithCancel(context.Background())
-			go func(f func(metric *Metric), m *Metric) {
-				f(m)
-				done()
-			}(f, m)
-			select {
-			case <-time.NewTimer(time.Second * 10).C:
-				m.cacel = true
-				return
-			case <-ctx.Done():
-				return
-			}
+			defer func() {
+				if r := recover(); r != nil {
+					out.error(fmt.Errorf("panic: %v", r))
+				}
+			}()
+			f(&Metric{out: out, ctx: ctx})
+		}(f)
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	c.inflight = finished
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		out.error(fmt.Errorf("timeout after %s", c.timeout))
+	}
 
-		}(f, metric)
+	var err error
+	c.cache, err = out.close()
+	c.lastRun = time.Now()
+	c.duration = time.Since(start)
+	c.success = err == nil
+	if err != nil {
+		c.lastErr, c.lastErrAt = err, c.lastRun
+		fmt.Println("collector", c.name+":", err)
 	}
-	wg.Wait()
-	metric = nil
 }
 
 func (c *Collector) Register(help ...string) {
 
-	flagName := fmt.Sprintf(c.name)
+	flagName := c.name
 	flagHelp := ""
 	if len(help) == 1 {
 		flagHelp = help[0]
 	}
 
+	c.AddFlag(&cli.DurationFlag{Name: "timeout", Value: c.timeout, Usage: "collector timeout` `", Destination: &c.timeout})
+	c.AddFlag(&cli.DurationFlag{Name: "min-interval", Value: c.minInterval, Usage: "cache the metrics between scrapes` `", Destination: &c.minInterval})
+
 	flag := &cli.BoolFlag{Name: flagName, Category: "collectors:", Usage: flagHelp, HideDefault: true, Action: func(ctx context.Context, cc *cli.Command, b bool) error {
 
 		if b {
@@ -192,6 +298,25 @@ func (e *exporter) Run() {
 	for c := range maps.Keys(collectors) {
 		fmt.Printf("\t%s\n", c)
 	}
+	switch mode {
+	case modeScrape, modeBoth:
+	case modePush:
+	default:
+		fmt.Println("Error: unknown mode", mode)
+		os.Exit(0)
+	}
+	if mode != modeScrape && pushURL == "" {
+		fmt.Println("Error: mode", mode, "require flag: --push-url")
+		os.Exit(0)
+	}
+	if mode == modePush {
+		e.push(context.Background())
+		return
+	}
+	if mode == modeBoth {
+		go e.push(context.Background())
+	}
+
 	prometheus.MustRegister(e)
 	prometheus.Unregister(promcollectors.NewGoCollector())
 	prometheus.Unregister(promcollectors.NewProcessCollector(promcollectors.ProcessCollectorOpts{PidFn: func() (int, error) {
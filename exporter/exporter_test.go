@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// the first CallFunc stops with metric.Context(), or hangs until the test ends
+		withContext bool
+		// errors of the first and the second run, "" succeeds
+		want      []string
+		wantCalls int32
+	}{
+		{name: "context", withContext: true, want: []string{"timeout", ""}, wantCalls: 2},
+		{name: "hung", withContext: false, want: []string{"timeout", "in flight"}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer close(release)
+			var calls atomic.Int32
+			c := NewCollector(tt.name).SetTimeout(50 * time.Millisecond)
+			c.CallFunc(func(metric *Metric) {
+				if calls.Add(1) > 1 {
+					metric.Create("test", "").SendGauge(1)
+					return
+				}
+				if tt.withContext {
+					<-metric.Context().Done()
+					return
+				}
+				<-release
+			})
+
+			for i, want := range tt.want {
+				if i > 0 && tt.withContext {
+					<-c.inflight
+				}
+				c.mu.Lock()
+				c.run()
+				c.mu.Unlock()
+				if want == "" {
+					if !c.success {
+						t.Errorf("run %d failed: %v", i, c.lastErr)
+					}
+					continue
+				}
+				if c.success || c.lastErr == nil || !strings.Contains(c.lastErr.Error(), want) {
+					t.Errorf("run %d: got error %v, want %s", i, c.lastErr, want)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("got %d CallFunc calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
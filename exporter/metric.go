@@ -1,8 +1,14 @@
 package exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -11,45 +17,58 @@ var namespace = "zjt"
 
 var MetricGlobalLable map[string]string = map[string]string{}
 
+// descs are shared by every scrape, keyed by name, help and label names
+var (
+	descMu sync.RWMutex
+	descs  = map[string]*prometheus.Desc{}
+)
+
 type Metric struct {
-	desc map[string]*prometheus.Desc
 	name string
 	unit string // total, bytes, seconds, info, ratio (percent) https://prometheus.io/docs/practices/naming/
 
 	labelName  []string
 	labelValue []string
-	idx        int
 	help       string
 	ch         chan<- prometheus.Metric
 
-	cacel bool
+	// out of a collector run, ch is not used
+	out *collected
+	ctx context.Context
 }
 type sendch struct {
 	metric prometheus.Metric
 }
 
 func NewMetric(ch chan<- prometheus.Metric) *Metric {
-	return &Metric{ch: ch, desc: make(map[string]*prometheus.Desc), labelName: make([]string, 100), labelValue: make([]string, 100)}
+	return &Metric{ch: ch}
+}
+
+// Context of the collector run, done when the run times out, pass it to the
+// calls of a CallFunc so they stop with the run
+func (a *Metric) Context() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
 }
 
 // unit: total, bytes, seconds, info, ratio (percent)
 func (a *Metric) Create(name, unit string) *Metric {
 
-	a.idx = 0
 	a.name = name
 	a.unit = unit
 	a.help = ""
-	a.labelName = make([]string, 100)
-	a.labelValue = make([]string, 100)
+	a.labelName = a.labelName[:0]
+	a.labelValue = a.labelValue[:0]
 	return a
 
 }
 
 func (a *Metric) SetLabel(name, value string) *Metric {
 
-	a.labelName[a.idx] = name
-	a.labelValue[a.idx] = value
-	a.idx++
+	a.labelName = append(a.labelName, name)
+	a.labelValue = append(a.labelValue, value)
 	return a
 
 }
@@ -58,6 +77,15 @@ func (a *Metric) SetHelp(help string) *Metric {
 	return a
 }
 
+// Error fails the collector run, the error is exposed as <ns>_collector_last_error
+func (a *Metric) Error(err error) {
+	if a.out != nil {
+		a.out.error(err)
+		return
+	}
+	fmt.Println(err)
+}
+
 func (a *Metric) SendGauge(v float64) {
 	a.Send(prometheus.GaugeValue, v)
 }
@@ -73,62 +101,147 @@ func (a *Metric) SendWithoutNs(valueType prometheus.ValueType, value float64) {
 	a.send("", valueType, value)
 }
 
-func (a *Metric) send(namespace string, valueType prometheus.ValueType, value float64) {
+// SendHistogram of pre-aggregated buckets, upper bound to cumulative count, +Inf is count
+func (a *Metric) SendHistogram(count uint64, sum float64, buckets map[float64]uint64) {
+	desc, values, series := a.describe(namespace)
+	m, err := prometheus.NewConstHistogram(desc, count, sum, buckets, values...)
+	a.emit(series, m, err)
+}
 
-	var labelName []string
-	var labelValue []string
-	var constLabels prometheus.Labels = make(prometheus.Labels)
-	for k, v := range MetricGlobalLable {
+// SendSummary of pre-computed quantiles, quantile to value
+func (a *Metric) SendSummary(count uint64, sum float64, quantiles map[float64]float64) {
+	desc, values, series := a.describe(namespace)
+	m, err := prometheus.NewConstSummary(desc, count, sum, quantiles, values...)
+	a.emit(series, m, err)
+}
 
-		if slices.Contains(a.labelName, k) {
-			continue
+// SendInfo name_info 1, the labels are the information
+//
+//	metric.Create("build", "info").SetLabel("version", v).SendInfo()
+func (a *Metric) SendInfo() {
+	a.unit = "info"
+	a.Send(prometheus.GaugeValue, 1)
+}
+
+// SendStateSet one series per state with a label of the metric name, 1 for current 0 otherwise
+//
+//	metric.Create("raid_state", "").SendStateSet("degraded", "ok", "degraded", "failed")
+func (a *Metric) SendStateSet(current string, states ...string) {
+	desc, values, series := a.describe(namespace, a.name)
+	for _, state := range states {
+		value := 0.0
+		if state == current {
+			value = 1
 		}
-		constLabels[k] = v
-		//a.labelName[a.idx] = k
-		//a.labelValue[a.idx] = v
-		//a.idx++
+		m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, append(values, state)...)
+		a.emit(series+a.name+"="+strconv.Quote(state), m, err)
+	}
+}
+
+func (a *Metric) send(namespace string, valueType prometheus.ValueType, value float64) {
+
+	desc, values, series := a.describe(namespace)
+	m, err := prometheus.NewConstMetric(desc, valueType, value, values...)
+	a.emit(series, m, err)
 
+}
+
+// describe returns the cached Desc, the label values and the series name{labels} of
+// duplicate detection, extra label names have their values added by the caller
+func (a *Metric) describe(namespace string, extra ...string) (*prometheus.Desc, []string, string) {
+
+	// name is the subsystem of BuildFQName, an empty unit would drop the whole name
+	fqName := prometheus.BuildFQName(namespace, a.name, a.unit)
+	if a.unit == "" {
+		fqName = prometheus.BuildFQName(namespace, "", a.name)
 	}
+	labelName := append(slices.Clone(a.labelName), extra...)
+	labelValue := slices.Clone(a.labelValue)
 
-	for i := range a.labelName {
-		if a.labelName[i] == "" {
+	var constNames []string
+	for k := range MetricGlobalLable {
+		if slices.Contains(labelName, k) {
 			continue
 		}
+		constNames = append(constNames, k)
+	}
+	sort.Strings(constNames)
+	constLabels := make(prometheus.Labels, len(constNames))
+
+	var key strings.Builder
+	key.WriteString(fqName + "\xff" + a.help + "\xff" + strings.Join(labelName, "\xfe") + "\xff")
+	for _, k := range constNames {
+		constLabels[k] = MetricGlobalLable[k]
+		key.WriteString(k + "=" + MetricGlobalLable[k] + "\xfe")
+	}
+
+	descMu.RLock()
+	desc, ok := descs[key.String()]
+	descMu.RUnlock()
+	if !ok {
+		desc = prometheus.NewDesc(fqName, a.help, labelName, constLabels)
+		descMu.Lock()
+		descs[key.String()] = desc
+		descMu.Unlock()
+	}
+
+	series := fqName + "{"
+	for i := range labelValue {
+		series += labelName[i] + "=" + strconv.Quote(labelValue[i]) + ","
+	}
+	return desc, labelValue, series
+}
 
-		labelName = append(labelName, a.labelName[i])
-		labelValue = append(labelValue, a.labelValue[i])
+func (a *Metric) emit(series string, m prometheus.Metric, err error) {
+	if err != nil {
+		a.Error(fmt.Errorf("%s}: %w", strings.TrimSuffix(series, ","), err))
+		return
 	}
-	if len(labelName) != len(labelValue) {
-		fmt.Printf("inconsistent label cardinality: expected %d label values but got %d in %v\n", len(labelName), len(labelValue), labelValue)
+	if a.out != nil {
+		a.out.add(series, m)
 		return
 	}
+	a.ch <- m
+}
 
-	desc := prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, a.name, a.unit),
-		a.help,
-		labelName,
-		constLabels)
-
-	/*
-		a.l.RLock()
-		key := desc.String() + strings.Join(labelValue, "")
-		d, ok := a.desc[key]
-		a.l.RUnlock()
-
-		if !ok {
-			a.l.Lock()
-			a.desc[desc.String()+strings.Join(a.labelValue, "")] = desc
-			a.l.Unlock()
-			return
-		}
-	*/
+// collected the metrics and errors of a collector run, dropped once closed
+type collected struct {
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+	series  map[string]bool
+	errs    []error
+	closed  bool
+}
 
-	if a.cacel {
-		a = &Metric{}
+// add drops a series sent twice in the run, the registry would fail the whole scrape
+func (c *collected) add(series string, m prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if c.series[series] {
+		c.errs = append(c.errs, fmt.Errorf("duplicate series %s}", strings.TrimSuffix(series, ",")))
 		return
 	}
+	if c.series == nil {
+		c.series = map[string]bool{}
+	}
+	c.series[series] = true
+	c.metrics = append(c.metrics, m)
+}
 
-	a.ch <- prometheus.MustNewConstMetric(desc, valueType, value, labelValue...)
-	a = &Metric{}
+func (c *collected) error(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.errs = append(c.errs, err)
+	}
+}
 
+func (c *collected) close() ([]prometheus.Metric, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return c.metrics, errors.Join(c.errs...)
 }
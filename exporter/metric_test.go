@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricTypes(t *testing.T) {
+	out := &collected{}
+	metric := &Metric{out: out}
+	metric.Create("latency", "seconds").SetLabel("path", "/").SendHistogram(4, 1.5, map[float64]uint64{0.1: 1, 1: 3})
+	metric.Create("latency", "seconds").SetLabel("path", "/a").SendHistogram(1, 0.1, map[float64]uint64{0.1: 1})
+	metric.Create("rpc", "seconds").SendSummary(2, 3, map[float64]float64{0.5: 1, 0.99: 2})
+	metric.Create("build", "").SetLabel("version", "1.0").SendInfo()
+	metric.Create("raid_state", "").SendStateSet("degraded", "ok", "degraded", "failed")
+	metrics, err := out.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		desc := m.Desc().String()
+		switch {
+		case pb.Histogram != nil:
+			got = append(got, "histogram "+pb.GetLabel()[0].GetValue())
+		case pb.Summary != nil:
+			got = append(got, "summary")
+		case strings.Contains(desc, `"zjt_build_info"`):
+			got = append(got, "info "+pb.GetLabel()[0].GetValue())
+		case strings.Contains(desc, `"zjt_raid_state"`):
+			got = append(got, fmt.Sprintf("%s=%s %v", pb.GetLabel()[0].GetName(), pb.GetLabel()[0].GetValue(), pb.GetGauge().GetValue()))
+		default:
+			got = append(got, desc)
+		}
+	}
+	want := []string{
+		"histogram /",
+		"histogram /a",
+		"summary",
+		"info 1.0",
+		"raid_state=ok 0",
+		"raid_state=degraded 1",
+		"raid_state=failed 0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("metrics: got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// the histograms of both paths share one Desc
+	if metrics[0].Desc() != metrics[1].Desc() {
+		t.Errorf("desc: got two for zjt_latency_seconds, want one cached")
+	}
+}
+
+func TestMetricDuplicate(t *testing.T) {
+	out := &collected{}
+	metric := &Metric{out: out}
+	metric.Create("requests", "total").SetLabel("code", "200").SendCounter(1)
+	metric.Create("requests", "total").SetLabel("code", "500").SendCounter(1)
+	metric.Create("requests", "total").SetLabel("code", "200").SendCounter(2)
+	metrics, err := out.close()
+	if len(metrics) != 2 {
+		t.Errorf("metrics: got %d, want 2 without the duplicate", len(metrics))
+	}
+	if err == nil || !strings.Contains(err.Error(), `duplicate series zjt_requests_total{code="200"}`) {
+		t.Errorf("error: got %v, want the duplicate series", err)
+	}
+}
@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/zhujintao/kit-go/promwrite"
+)
+
+const (
+	modeScrape = "scrape"
+	modePush   = "push"
+	modeBoth   = "both"
+)
+
+var (
+	mode         string
+	pushURL      string
+	pushUser     string
+	pushPassword string
+	pushInterval time.Duration
+)
+
+// push runs the collectors every pushInterval and writes them by remote write until ctx is done
+func (e *exporter) push(ctx context.Context) {
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+	b := promwrite.NewMetric(pushURL, pushUser, pushPassword).NewBatch()
+	defer b.Close()
+
+	fmt.Println("Pushing "+e.name, "to", pushURL, "every", pushInterval)
+	t := time.NewTicker(pushInterval)
+	defer t.Stop()
+	for {
+		mfs, err := reg.Gather()
+		if err != nil {
+			fmt.Println(err)
+		}
+		if err := appendFamilies(b, mfs, time.Now().UnixMilli()); err != nil {
+			fmt.Println(err)
+		}
+		if _, err := b.Flush(); err != nil {
+			fmt.Println("push:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// appendFamilies histograms and summaries are sent as the classic _bucket, _sum, _count series
+func appendFamilies(b *promwrite.Batch, mfs []*dto.MetricFamily, now int64) error {
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		typ := metricType(mf.GetType())
+		for _, m := range mf.GetMetric() {
+			ts := now
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			series := func(name string, value float64, extra ...string) error {
+				a := b.Name(name, "", mf.GetHelp()).Type(typ)
+				for _, l := range m.GetLabel() {
+					a.Label(l.GetName(), l.GetValue())
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					a.Label(extra[i], extra[i+1])
+				}
+				return a.SetValue(value, ts).Append()
+			}
+
+			var err error
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				err = series(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				err = series(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				err = series(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, bk := range h.GetBucket() {
+					inf = inf || math.IsInf(bk.GetUpperBound(), 1)
+					if err = series(name+"_bucket", float64(bk.GetCumulativeCount()), "le", formatFloat(bk.GetUpperBound())); err != nil {
+						break
+					}
+				}
+				if err == nil && !inf {
+					err = series(name+"_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				}
+				if err == nil {
+					err = series(name+"_sum", h.GetSampleSum())
+				}
+				if err == nil {
+					err = series(name+"_count", float64(h.GetSampleCount()))
+				}
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					if err = series(name, q.GetValue(), "quantile", formatFloat(q.GetQuantile())); err != nil {
+						break
+					}
+				}
+				if err == nil {
+					err = series(name+"_sum", s.GetSampleSum())
+				}
+				if err == nil {
+					err = series(name+"_count", float64(s.GetSampleCount()))
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func metricType(t dto.MetricType) promwrite.MetricType {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return promwrite.TypeCounter
+	case dto.MetricType_GAUGE:
+		return promwrite.TypeGauge
+	case dto.MetricType_HISTOGRAM:
+		return promwrite.TypeHistogram
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return promwrite.TypeGaugeHistogram
+	case dto.MetricType_SUMMARY:
+		return promwrite.TypeSummary
+	}
+	return promwrite.TypeUnknown
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+func TestPush(t *testing.T) {
+	var mu sync.Mutex
+	// name{labels} of the pushed series, labels sorted as sent
+	got := map[string]float64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var req writev2.Request
+		if err := req.Unmarshal(data); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ts := range req.Timeseries {
+			var name string
+			var lbs []string
+			for i := 0; i+1 < len(ts.LabelsRefs); i += 2 {
+				k, v := req.Symbols[ts.LabelsRefs[i]], req.Symbols[ts.LabelsRefs[i+1]]
+				if k == "__name__" {
+					name = v
+					continue
+				}
+				if k == "collector" || k == "le" || k == "job" {
+					lbs = append(lbs, k+"="+v)
+				}
+			}
+			got[name+"{"+strings.Join(lbs, ",")+"}"] = ts.Samples[len(ts.Samples)-1].Value
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	saved, savedURL, savedInterval := collectors, pushURL, pushInterval
+	defer func() { collectors, pushURL, pushInterval = saved, savedURL, savedInterval }()
+	pushURL, pushInterval = srv.URL, time.Hour
+	c := NewCollector("push")
+	c.CallFunc(func(metric *Metric) {
+		metric.Create("requests", "total").SetLabel("job", "a").SendCounter(3)
+		metric.Create("latency", "seconds").SendHistogram(4, 1.5, map[float64]uint64{0.1: 1, 1: 3})
+	})
+	collectors = map[string]*Collector{"push": c}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// one collect and push, then ctx is done
+	(&exporter{name: "test"}).push(ctx)
+
+	want := map[string]float64{
+		"zjt_requests_total{job=a}":             3,
+		"zjt_latency_seconds_bucket{le=0.1}":    1,
+		"zjt_latency_seconds_bucket{le=1}":      3,
+		"zjt_latency_seconds_bucket{le=+Inf}":   4,
+		"zjt_latency_seconds_sum{}":             1.5,
+		"zjt_latency_seconds_count{}":           4,
+		"zjt_collector_success{collector=push}": 1,
+		"test_up{}":                             1,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for series, value := range want {
+		if v, ok := got[series]; !ok || v != value {
+			t.Errorf("%s: got %v (pushed %v), want %v", series, v, ok, value)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package idrac
 import (
 	"crypto/tls"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"net/url"
 	"os"
@@ -37,7 +38,9 @@ type cli struct {
 }
 
 type dataResult struct {
-	PowerState int `xml:"pwState"`
+	// the login page of an expired session is html, not the root of /data
+	XMLName    xml.Name `xml:"root"`
+	PowerState int      `xml:"pwState"`
 	//Temperature                        any    `xml:"temperatures"`
 	BiosVersion                        string `xml:"biosVer"`
 	LifecycleControllerFirmwareVersion string `xml:"LCCfwVersion"`
@@ -117,11 +120,20 @@ func (c *cli) Logout() {
 	fmt.Println("logout seccess")
 }
 
+// GetData fields are the xml names of dataResult, pwState, sensortype, eventLogEntries,
+// nil when the session expired or the request failed
 func (c *cli) GetData(fields ...string) *dataResult {
 	var result dataResult
 
-	r, _ := c.http.R().Post(c.http.BaseURL() + "/data/?get=" + strings.Join(fields, ","))
-	fmt.Println(r)
+	r, err := c.http.R().Post(c.http.BaseURL() + "/data/?get=" + strings.Join(fields, ","))
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	if err := xml.Unmarshal(r.Bytes(), &result); err != nil {
+		fmt.Println(err)
+		return nil
+	}
 	return &result
 }
 
@@ -0,0 +1,56 @@
+package idrac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetData(t *testing.T) {
+	expired := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.URL.Path {
+		case "/data/login":
+			w.Write([]byte(`<root><status>ok</status><authResult>0</authResult><forwardUrl>index.html?ST1=a1,ST2=b2</forwardUrl></root>`))
+		case "/data/":
+			if r.Header.Get("ST2") != "b2" || expired {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(`<!DOCTYPE html><html><body>login</body></html>`))
+				return
+			}
+			w.Write([]byte(`<root><pwState>1</pwState>` +
+				`<sensortype><thresholdSensorList><sensor><name>Inlet Temp</name><reading>24</reading><sensorStatus>Normal</sensorStatus><units>C</units></sensor></thresholdSensorList>` +
+				`<discreteSensorList><sensor><name>PS1 Status</name><reading>Present</reading><sensorStatus>Critical</sensorStatus></sensor></discreteSensorList></sensortype>` +
+				`<eventLogEntries><eventLogEntry><severity>Warning</severity></eventLogEntry><eventLogEntry><severity>Ok</severity></eventLogEntry></eventLogEntries></root>`))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "root", "calvin")
+	if !c.Login() {
+		t.Fatal("Login failed")
+	}
+	data := c.GetData("pwState", "sensortype", "eventLogEntries")
+	if data == nil {
+		t.Fatal("GetData: got nil")
+	}
+	if data.PowerState != 1 {
+		t.Errorf("pwState: got %d, want 1", data.PowerState)
+	}
+	if s := data.Sensortype.ThresholdSensorList[0].Sensor[0]; s.Name != "Inlet Temp" || s.Reading != 24 || s.Units != "C" {
+		t.Errorf("threshold sensor: got %+v, want Inlet Temp 24 C", s)
+	}
+	if s := data.Sensortype.DiscreteSensorList.Sensor[0]; s.SensorStatus != "Critical" {
+		t.Errorf("discrete sensor: got %+v, want Critical", s)
+	}
+	if len(data.EventLogEntries) != 2 || data.EventLogEntries[0].Severity != "Warning" {
+		t.Errorf("event log: got %+v, want Warning and Ok", data.EventLogEntries)
+	}
+
+	// the login page of an expired session
+	expired = true
+	if data := c.GetData("pwState"); data != nil {
+		t.Errorf("GetData of an expired session: got %+v, want nil", data)
+	}
+}
@@ -6,9 +6,15 @@ import (
 	"log/slog"
 	"path"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/grafana/loki/v3/pkg/logproto"
 	"github.com/prometheus/common/model"
+	"github.com/zhujintao/kit-go/loki"
 	"github.com/zhujintao/kit-go/utils/buffer"
 )
 
@@ -21,20 +27,44 @@ const (
 	LevelError Level = 8
 )
 
+// LokiHandler ships records to loki through a batching pusher shared by every
+// handler of the same url and tenant
 type LokiHandler struct {
-	url      string
 	opts     slog.HandlerOptions
-	mu       *sync.Mutex
 	jobLabel string
+	pusher   *sharedPusher
+	// set by NewLokiHandler, which holds a reference of pusher, nil in WithAttrs and WithGroup
+	closeOnce *sync.Once
+	labels    map[string]bool
+	metadata  map[string]bool
+
+	// prefix of the keys of WithGroup, "a.b."
+	group string
+	// resolved WithAttrs
+	attrLabels   model.LabelSet
+	attrMetadata []logproto.LabelAdapter
+	attrLine     string
 }
 
 // jobLabel is label job id
 //
 // logLevel default info
+//
+// Labels are attribute keys sent as loki labels, grouped keys are joined with
+// a dot, "req.method", and the label name is req_method. Keep it to bounded values,
+// every combination is a stream
+//
+// Metadata are attribute keys sent as structured metadata, like trace_id,
+// the other attributes are written to the line as key=value
 type Config struct {
 	URL      string
 	JobLabel string
 	Level    Level
+	TenantID string
+	User     string
+	Password string
+	Labels   []string
+	Metadata []string
 }
 
 // jobLabel is label job id
@@ -54,22 +84,93 @@ func NewLokiHandler(config Config) *LokiHandler {
 
 	var level *slog.LevelVar = &slog.LevelVar{}
 	level.Set(slog.Level(config.Level))
-	return &LokiHandler{
-		url:      config.URL,
-		mu:       &sync.Mutex{},
-		jobLabel: config.JobLabel,
-		opts:     slog.HandlerOptions{Level: level},
+	p, err := acquire(config)
+	if err != nil {
+		fmt.Println(err)
+	}
+	l := &LokiHandler{
+		jobLabel:   config.JobLabel,
+		opts:       slog.HandlerOptions{Level: level},
+		pusher:     p,
+		closeOnce:  &sync.Once{},
+		labels:     map[string]bool{},
+		metadata:   map[string]bool{},
+		attrLabels: model.LabelSet{},
+	}
+	for _, k := range config.Labels {
+		l.labels[k] = true
+	}
+	for _, k := range config.Metadata {
+		l.metadata[k] = true
+	}
+	return l
+}
+
+type sharedPusher struct {
+	*loki.Pusher
+	key  string
+	refs int
+}
+
+var (
+	pushersMu sync.Mutex
+	pushers   = map[string]*sharedPusher{}
+)
+
+// acquire the pusher of the url, tenant and credentials, handlers of other credentials
+// get their own pusher
+func acquire(config Config) (*sharedPusher, error) {
+	key := config.URL + "\x00" + config.TenantID + "\x00" + config.User + "\x00" + config.Password
+	pushersMu.Lock()
+	defer pushersMu.Unlock()
+	if p, ok := pushers[key]; ok {
+		p.refs++
+		return p, nil
+	}
+	p, err := loki.NewPusher(&loki.PusherConfig{URL: config.URL, TenantID: config.TenantID, User: config.User, Password: config.Password})
+	if err != nil {
+		return nil, err
 	}
+	s := &sharedPusher{Pusher: p, key: key, refs: 1}
+	pushers[key] = s
+	return s, nil
+}
 
+// Close sends the buffered records, the pusher is closed with the last handler of NewLokiHandler.
+// Close of a handler of WithAttrs or WithGroup does nothing, close the handler it came from
+func (l *LokiHandler) Close() error {
+	if l.closeOnce == nil || l.pusher == nil {
+		return nil
+	}
+	var err error
+	l.closeOnce.Do(func() {
+		pushersMu.Lock()
+		l.pusher.refs--
+		last := l.pusher.refs == 0
+		if last {
+			delete(pushers, l.pusher.key)
+		}
+		pushersMu.Unlock()
+		if !last {
+			err = l.pusher.Flush(context.Background())
+			return
+		}
+		err = l.pusher.Close()
+	})
+	return err
 }
 
 func (l *LokiHandler) Handle(_ context.Context, r slog.Record) error {
 
-	var buf buffer.Buffer = *buffer.New()
-	lbs := make(model.LabelSet, r.NumAttrs())
+	buf := buffer.New()
+	defer buf.Free()
+	lbs := make(model.LabelSet, len(l.attrLabels)+2)
+	for k, v := range l.attrLabels {
+		lbs[k] = v
+	}
+	metadata := slices.Clone(l.attrMetadata)
 
 	if !r.Time.IsZero() {
-		buf.WriteString(" ")
 		buf.WriteString("time")
 		buf.WriteByte('=')
 		buf.WriteString(r.Time.Format("2006-01-02 15:04:05.000"))
@@ -82,31 +183,95 @@ func (l *LokiHandler) Handle(_ context.Context, r slog.Record) error {
 	buf.WriteString(r.Level.String())
 	lbs[model.LabelName("level")] = model.LabelValue(r.Level.String())
 
-	fs := runtime.CallersFrames([]uintptr{r.PC})
-	f, _ := fs.Next()
-	buf.WriteString(" ")
-	buf.WriteString("source")
-	buf.WriteByte('=')
-	buf.WriteString(fmt.Sprintf("%s:%d", path.Base(f.File), f.Line))
+	if r.PC != 0 {
+		fs := runtime.CallersFrames([]uintptr{r.PC})
+		f, _ := fs.Next()
+		buf.WriteString(" ")
+		buf.WriteString("source")
+		buf.WriteByte('=')
+		buf.WriteString(fmt.Sprintf("%s:%d", path.Base(f.File), f.Line))
+	}
 
-	buf.WriteString(" " + "msg=" + r.Message)
+	buf.WriteString(" msg=")
+	buf.WriteString(quote(r.Message))
+	buf.WriteString(l.attrLine)
 
 	r.Attrs(func(a slog.Attr) bool {
-
-		buf.WriteString(" ")
-		buf.WriteString(string(a.Key))
-		buf.WriteByte('=')
-		buf.WriteString(a.Value.String())
-		lbs[model.LabelName(a.Key)] = model.LabelValue(a.Value.String())
+		l.appendAttr(buf, lbs, &metadata, l.group, a)
 		return true
 	})
 	if l.jobLabel != "" {
 		lbs[model.LabelName("job")] = model.LabelValue(l.jobLabel)
 	}
+	if l.pusher == nil {
+		return fmt.Errorf("loki pusher not created")
+	}
 
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	l.pusher.PushEntry(lbs.String(), logproto.Entry{Timestamp: t, Line: buf.String(), StructuredMetadata: metadata})
 	return nil
 }
 
+// appendAttr sorts a into labels, metadata or the line, groups are flattened with a dot
+func (l *LokiHandler) appendAttr(line *buffer.Buffer, lbs model.LabelSet, metadata *[]logproto.LabelAdapter, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range attrs {
+			l.appendAttr(line, lbs, metadata, group, ga)
+		}
+		return
+	}
+
+	key := group + a.Key
+	value := a.Value.String()
+	if a.Value.Kind() == slog.KindTime {
+		value = a.Value.Time().Format(time.RFC3339Nano)
+	}
+	switch {
+	case l.labels[key]:
+		lbs[model.LabelName(labelName(key))] = model.LabelValue(value)
+	case l.metadata[key]:
+		*metadata = append(*metadata, logproto.LabelAdapter{Name: labelName(key), Value: value})
+	default:
+		line.WriteString(" ")
+		line.WriteString(key)
+		line.WriteByte('=')
+		line.WriteString(quote(value))
+	}
+}
+
+// labelName replaces the characters loki does not allow in names with _
+func labelName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		b[i] = '_'
+	}
+	return string(b)
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
 func (l *LokiHandler) Enabled(_ context.Context, level slog.Level) bool {
 
 	minLevel := slog.LevelInfo
@@ -116,12 +281,37 @@ func (l *LokiHandler) Enabled(_ context.Context, level slog.Level) bool {
 	return level >= minLevel
 }
 
+func (l *LokiHandler) clone() *LokiHandler {
+	c := *l
+	c.closeOnce = nil
+	c.attrLabels = make(model.LabelSet, len(l.attrLabels))
+	for k, v := range l.attrLabels {
+		c.attrLabels[k] = v
+	}
+	c.attrMetadata = slices.Clone(l.attrMetadata)
+	return &c
+}
+
 func (l *LokiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	fmt.Println("WithAttrs", attrs)
-	return l
+	if len(attrs) == 0 {
+		return l
+	}
+	c := l.clone()
+	buf := buffer.New()
+	defer buf.Free()
+	buf.WriteString(c.attrLine)
+	for _, a := range attrs {
+		c.appendAttr(buf, c.attrLabels, &c.attrMetadata, c.group, a)
+	}
+	c.attrLine = buf.String()
+	return c
 }
 
 func (l *LokiHandler) WithGroup(name string) slog.Handler {
-	fmt.Println("WithGroup", name)
-	return l
+	if name == "" {
+		return l
+	}
+	c := l.clone()
+	c.group += name + "."
+	return c
 }
@@ -0,0 +1,96 @@
+package loki
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func refs(t *testing.T, l *LokiHandler) (int, bool) {
+	t.Helper()
+	pushersMu.Lock()
+	defer pushersMu.Unlock()
+	_, shared := pushers[l.pusher.key]
+	return l.pusher.refs, shared
+}
+
+func TestClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	config := Config{URL: srv.URL, JobLabel: "test", TenantID: t.Name()}
+
+	tests := []struct {
+		name string
+		// handlers to close, of the two made by NewLokiHandler
+		close      func(a, b *LokiHandler)
+		wantRefs   int
+		wantShared bool
+	}{
+		{
+			name: "derived",
+			close: func(a, b *LokiHandler) {
+				a.WithAttrs([]slog.Attr{slog.String("k", "v")}).(*LokiHandler).Close()
+				a.WithGroup("g").(*LokiHandler).Close()
+			},
+			wantRefs: 2, wantShared: true,
+		},
+		{
+			name:     "root twice",
+			close:    func(a, b *LokiHandler) { a.Close(); a.Close() },
+			wantRefs: 1, wantShared: true,
+		},
+		{
+			name:     "both roots",
+			close:    func(a, b *LokiHandler) { a.Close(); b.Close() },
+			wantRefs: 0, wantShared: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewLokiHandler(config)
+			b := NewLokiHandler(config)
+			if a.pusher != b.pusher {
+				t.Fatalf("handlers of one url and tenant must share the pusher")
+			}
+			tt.close(a, b)
+			gotRefs, gotShared := refs(t, a)
+			if gotRefs != tt.wantRefs || gotShared != tt.wantShared {
+				t.Errorf("pusher refs=%d shared=%v, expected refs=%d shared=%v", gotRefs, gotShared, tt.wantRefs, tt.wantShared)
+			}
+			a.Close()
+			b.Close()
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	var mu sync.Mutex
+	passwords := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		mu.Lock()
+		passwords[password] = true
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	config := Config{URL: srv.URL, JobLabel: "test", TenantID: t.Name(), User: "u", Password: "a"}
+
+	a := NewLokiHandler(config)
+	config.Password = "b"
+	b := NewLokiHandler(config)
+	if a.pusher == b.pusher {
+		t.Fatalf("handlers of another password must not share the pusher")
+	}
+	slog.New(a).Info("a")
+	slog.New(b).Info("b")
+	a.Close()
+	b.Close()
+	if !passwords["a"] || !passwords["b"] {
+		t.Errorf("passwords=%v, expected a and b", passwords)
+	}
+}
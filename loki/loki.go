@@ -1,26 +1,23 @@
 package loki
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gogo/protobuf/proto"
 	"github.com/grafana/dskit/flagext"
-	"github.com/grafana/loki/v3/pkg/logproto"
 	"github.com/grafana/loki/v3/pkg/util/build"
 
-	"github.com/golang/snappy"
 	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/zhujintao/kit-go/utils/buffer"
+	"github.com/zhujintao/kit-go/utils/spool"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -38,7 +35,32 @@ type loki struct {
 	labels   model.LabelSet
 	args     []any
 	tenantID string
-	timeout  time.Duration
+	protocol Protocol
+	metadata map[string]bool
+	spool    *spool.Spool
+	once     sync.Once
+	pusher   *Pusher
+}
+
+// Set the push protocol, ProtocolOTLP sends to an OpenTelemetry collector
+func (l *loki) SetProtocol(protocol Protocol) *loki {
+	l.protocol = protocol
+	return l
+}
+
+// Set a spool keeping the lines while loki is down
+func (l *loki) SetSpool(s *spool.Spool) *loki {
+	l.spool = s
+	return l
+}
+
+// Set attribute keys sent as structured metadata instead of labels,
+// default trace_id, span_id and request_id
+func (l *loki) SetMetadata(keys ...string) *loki {
+	for _, k := range keys {
+		l.metadata[k] = true
+	}
+	return l
 }
 
 // Set Loki Tenant ID
@@ -95,9 +117,9 @@ func NewLoki(URL ...string) *loki {
 
 	}
 	l := &loki{
-		lokiURL: url,
-		labels:  make(model.LabelSet),
-		timeout: time.Second * 1,
+		lokiURL:  url,
+		labels:   make(model.LabelSet),
+		metadata: map[string]bool{TraceIDKey: true, SpanIDKey: true, "request_id": true},
 	}
 	l.labels[model.LabelName("hostname")] = model.LabelValue(hostname)
 	var clientURL flagext.URLValue
@@ -113,7 +135,6 @@ func NewLoki(URL ...string) *loki {
 	if err != nil {
 		fmt.Println(err)
 	}
-	l.client.Timeout = l.timeout
 
 	exLbs := os.ExpandEnv("${LOKI_EXTERNAL_LABELS}")
 	if exLbs != "" {
@@ -139,11 +160,17 @@ func appendAttr(line *buffer.Buffer, k, v string) {
 }
 
 func (l *loki) Log(t time.Time, level string, message string, args ...any) {
+	l.LogContext(context.Background(), t, level, message, args...)
+}
+
+// LogContext adds the trace_id and span_id of the span in ctx as structured metadata
+func (l *loki) LogContext(ctx context.Context, t time.Time, level string, message string, args ...any) {
 	if l.lokiURL == postPath {
 		fmt.Println("LOKI_PUSH_URL must be defined, LOKI_EXTERNAL_LABELS add external lable.")
 		return
 	}
 	var line buffer.Buffer = *buffer.New()
+	metadata := traceMetadata(ctx)
 
 	r := slog.NewRecord(time.Now(), 0, message, 0)
 	line.WriteString(r.Time.Format(dateFormat))
@@ -157,6 +184,10 @@ func (l *loki) Log(t time.Time, level string, message string, args ...any) {
 	r.Add(l.args...)
 
 	r.Attrs(func(a slog.Attr) bool {
+		if l.metadata[a.Key] {
+			metadata[a.Key] = a.Value.String()
+			return true
+		}
 		l.labels[model.LabelName(a.Key)] = model.LabelValue(a.Value.String())
 		if strings.ToLower(a.Key) == "job" {
 			return true
@@ -165,7 +196,7 @@ func (l *loki) Log(t time.Time, level string, message string, args ...any) {
 		return true
 	})
 
-	l.send(r.Time, line.String())
+	l.send(r.Time, line.String(), metadata)
 }
 
 func (l *loki) LogFile(level string, message string, args ...any) {
@@ -195,17 +226,27 @@ func (l *loki) LogFile(level string, message string, args ...any) {
 }
 
 func (l *loki) Send(message string, args ...any) {
+	l.SendContext(context.Background(), message, args...)
+}
+
+// SendContext adds the trace_id and span_id of the span in ctx as structured metadata
+func (l *loki) SendContext(ctx context.Context, message string, args ...any) {
 	if l.lokiURL == postPath {
 		fmt.Println("LOKI_PUSH_URL must be defined")
 		return
 	}
 	var line buffer.Buffer = *buffer.New()
+	metadata := traceMetadata(ctx)
 
 	r := slog.NewRecord(time.Now().Local(), 0, message, 0)
 	line.WriteString(message)
 	r.Add(args...)
 	r.Add(l.args...)
 	r.Attrs(func(a slog.Attr) bool {
+		if l.metadata[a.Key] {
+			metadata[a.Key] = a.Value.String()
+			return true
+		}
 		l.labels[model.LabelName(a.Key)] = model.LabelValue(a.Value.String())
 		if strings.ToLower(a.Key) == "job" {
 			return true
@@ -214,45 +255,47 @@ func (l *loki) Send(message string, args ...any) {
 		return true
 	})
 
-	l.send(r.Time, line.String())
+	l.send(r.Time, line.String(), metadata)
 
 }
-func (l *loki) send(t time.Time, msg string) {
-	stream := &logproto.Stream{Labels: l.labels.String(), Entries: []logproto.Entry{{Timestamp: t, Line: msg}}}
-	preq := logproto.PushRequest{
-		Streams: make([]logproto.Stream, 0),
-	}
-	preq.Streams = append(preq.Streams, *stream)
-	buf, err := proto.Marshal(&preq)
-	if err != nil {
-		fmt.Println(err)
-		return
+
+func traceMetadata(ctx context.Context) map[string]string {
+	metadata := map[string]string{}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		metadata[TraceIDKey] = sc.TraceID().String()
+		metadata[SpanIDKey] = sc.SpanID().String()
 	}
-	buf = snappy.Encode(nil, buf)
-	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
-	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, "POST", l.lokiURL, bytes.NewReader(buf))
-	if err != nil {
-		fmt.Println(err)
+	return metadata
+}
+
+// send queues the line on the background pusher, created on first use so SetTenantID applies
+func (l *loki) send(t time.Time, msg string, metadata map[string]string) {
+	l.once.Do(func() {
+		var err error
+		l.pusher, err = NewPusher(&PusherConfig{URL: l.lokiURL, Protocol: l.protocol, TenantID: l.tenantID, Client: l.client, Spool: l.spool})
+		if err != nil {
+			fmt.Println(err)
+		}
+	})
+	if l.pusher == nil {
 		return
 	}
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("User-Agent", userAgent)
-	if l.tenantID != "" {
-		req.Header.Set("X-Scope-OrgID", l.tenantID)
-	}
-	resp, err := l.client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return
+	l.pusher.PushMetadata(l.labels, t, msg, metadata)
+}
+
+// Flush waits until the queued lines are sent
+func (l *loki) Flush(ctx context.Context) error {
+	if l.pusher == nil {
+		return nil
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode/100 != 2 {
-		scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
-		line := ""
-		if scanner.Scan() {
-			line = scanner.Text()
-		}
-		fmt.Printf("server returned HTTP status %s (%d): %s", resp.Status, resp.StatusCode, line)
+	return l.pusher.Flush(ctx)
+}
+
+// Close sends the queued lines
+func (l *loki) Close() error {
+	l.once.Do(func() {})
+	if l.pusher == nil {
+		return nil
 	}
+	return l.pusher.Close()
 }
@@ -0,0 +1,178 @@
+package loki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/loki/v3/pkg/logproto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+type Protocol int
+
+const (
+	// snappy protobuf PushRequest to /loki/api/v1/push
+	ProtocolLoki Protocol = iota
+	// OTLP/HTTP json logs to /v1/logs, an OpenTelemetry collector or loki /otlp/v1/logs
+	ProtocolOTLP
+)
+
+const otlpPath = "/v1/logs"
+
+// NewOTLPExporter a Pusher of ProtocolOTLP, URL http://collector:4318 or http://loki:3100/otlp
+func NewOTLPExporter(cfg *PusherConfig) (*Pusher, error) {
+	c := *cfg
+	c.Protocol = ProtocolOTLP
+	return NewPusher(&c)
+}
+
+// metadata of these names are the trace context of an OTLP record, not attributes
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+// OTLP json encoding, https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber,omitempty"`
+	SeverityText   string         `json:"severityText,omitempty"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes,omitempty"`
+	TraceID        string         `json:"traceId,omitempty"`
+	SpanID         string         `json:"spanId,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// severityNumber of the level label, info when unknown
+func severityNumber(level string) int {
+	switch strings.ToLower(level) {
+	case "trace":
+		return 1
+	case "debug":
+		return 5
+	case "info":
+		return 9
+	case "warn", "warning":
+		return 13
+	case "error":
+		return 17
+	case "fatal", "critical":
+		return 21
+	}
+	return 0
+}
+
+// encodeOTLP one resource per stream, the stream labels are resource attributes and
+// the structured metadata are record attributes, the level label is the severity
+func encodeOTLP(b *batch) ([]byte, error) {
+	req := otlpRequest{ResourceLogs: make([]otlpResourceLogs, 0, len(b.streams))}
+	keys := make([]string, 0, len(b.streams))
+	for k := range b.streams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := b.streams[k]
+		lbs, err := parser.ParseMetric(s.Labels)
+		if err != nil {
+			return nil, err
+		}
+		var level string
+		var resource []otlpKeyValue
+		lbs.Range(func(l labels.Label) {
+			if l.Name == "level" {
+				level = l.Value
+				return
+			}
+			resource = append(resource, otlpKeyValue{Key: l.Name, Value: otlpAnyValue{l.Value}})
+		})
+
+		records := make([]otlpLogRecord, len(s.Entries))
+		for i, e := range s.Entries {
+			records[i] = otlpRecord(e, level)
+		}
+		req.ResourceLogs = append(req.ResourceLogs, otlpResourceLogs{
+			Resource:  otlpResource{Attributes: resource},
+			ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: "github.com/zhujintao/kit-go/loki"}, LogRecords: records}},
+		})
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(&req); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func otlpRecord(e logproto.Entry, level string) otlpLogRecord {
+	r := otlpLogRecord{
+		TimeUnixNano:   strconv.FormatInt(e.Timestamp.UnixNano(), 10),
+		SeverityText:   level,
+		SeverityNumber: severityNumber(level),
+		Body:           otlpAnyValue{e.Line},
+	}
+	for _, m := range e.StructuredMetadata {
+		switch {
+		case m.Name == TraceIDKey && isHex(m.Value, 32):
+			r.TraceID = m.Value
+		case m.Name == SpanIDKey && isHex(m.Value, 16):
+			r.SpanID = m.Value
+		default:
+			r.Attributes = append(r.Attributes, otlpKeyValue{Key: m.Name, Value: otlpAnyValue{m.Value}})
+		}
+	}
+	return r
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,571 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/loki/pkg/push"
+	"github.com/grafana/loki/v3/pkg/logproto"
+	"github.com/prometheus/common/model"
+	"github.com/zhujintao/kit-go/ssh"
+	"github.com/zhujintao/kit-go/utils/spool"
+)
+
+type DropPolicy int
+
+const (
+	// new entries are dropped while the buffer is full
+	DropNewest DropPolicy = iota
+	// the oldest batch waiting to be sent is dropped
+	DropOldest
+	// Push waits for space, only for callers that may block
+	Block
+)
+
+// URL is the loki address or push url, the collector address with ProtocolOTLP
+//
+// env LOKI_PUSH_URL
+type PusherConfig struct {
+	URL      string
+	Protocol Protocol
+	TenantID string
+	User     string
+	Password string
+	// bytes of a batch, default 1MiB
+	BatchSize int
+	// a batch is sent at latest after BatchWait, default 1s
+	BatchWait time.Duration
+	// bytes buffered while loki is slow or down, default 16MiB
+	MaxBuffer int
+	Drop      DropPolicy
+	// retries of 429, 5xx and network errors, default 10, -1 disables
+	MaxRetries int
+	// default 500ms, doubled up to MaxBackoff 30s
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// per request, default 10s
+	Timeout time.Duration
+	// Close waits for the buffer to be sent, default 10s
+	CloseTimeout time.Duration
+	// default fmt.Println
+	OnError func(error)
+	// default http.Client with the ssh dial when via ssh
+	Client *http.Client
+	// batches that could not be sent are written to the spool and replayed
+	// in order, nil drops them
+	Spool *spool.Spool
+}
+
+type PusherStats struct {
+	// entries
+	Sent    uint64
+	Dropped uint64
+	Failed  uint64
+	Spooled uint64
+	// requests
+	Retries uint64
+	// bytes waiting
+	Buffered int
+	Spool    spool.Stats
+}
+
+// Pusher groups entries per label set into one PushRequest and sends them
+// in the background, Push never waits for loki
+//
+//	p, _ := loki.NewPusher(&loki.PusherConfig{URL: "http://loki:3100", TenantID: "ops"})
+//	defer p.Close()
+//	p.Push(model.LabelSet{"job": "canal"}, time.Now(), "started")
+type Pusher struct {
+	cfg    PusherConfig
+	url    string
+	client *http.Client
+
+	mu       sync.Mutex
+	space    *sync.Cond
+	cur      *batch
+	queue    []*batch
+	buffered int
+	closed   bool
+
+	wake   chan struct{}
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	sshcon io.Closer
+
+	sent, dropped, failed, spooled, retries atomic.Uint64
+	// run goroutine only
+	nextReplay    time.Time
+	replayBackoff time.Duration
+}
+
+type batch struct {
+	streams map[string]*logproto.Stream
+	size    int
+	entries int
+	created time.Time
+}
+
+func newBatch() *batch {
+	return &batch{streams: map[string]*logproto.Stream{}, created: time.Now()}
+}
+
+func (b *batch) add(labels string, e logproto.Entry) {
+	s, ok := b.streams[labels]
+	if !ok {
+		s = &logproto.Stream{Labels: labels}
+		b.streams[labels] = s
+	}
+	s.Entries = append(s.Entries, e)
+	b.size += entrySize(labels, e, !ok)
+	b.entries++
+}
+
+func (b *batch) encode() ([]byte, error) {
+	req := logproto.PushRequest{Streams: make([]logproto.Stream, 0, len(b.streams))}
+	for _, s := range b.streams {
+		req.Streams = append(req.Streams, *s)
+	}
+	buf, err := proto.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, buf), nil
+}
+
+func entrySize(labels string, e logproto.Entry, newStream bool) int {
+	n := len(e.Line)
+	for _, m := range e.StructuredMetadata {
+		n += len(m.Name) + len(m.Value)
+	}
+	if newStream {
+		n += len(labels)
+	}
+	return n
+}
+
+func NewPusher(cfg *PusherConfig) (*Pusher, error) {
+	return newPusher(cfg, nil)
+}
+
+func NewPusherViaSSH(sshAddr, sshUser, sshPassword string, cfg *PusherConfig) (*Pusher, error) {
+	sshcon, err := ssh.NewConn(sshAddr, sshUser, sshPassword)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newPusher(cfg, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return sshcon.Dial(network, addr)
+	})
+	if err != nil {
+		sshcon.Close()
+		return nil, err
+	}
+	sshcon.SendHello(p.ctx)
+	p.sshcon = sshcon
+	return p, nil
+}
+
+func newPusher(cfg *PusherConfig, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*Pusher, error) {
+	p := &Pusher{cfg: *cfg, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	c := &p.cfg
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1 << 20
+	}
+	if c.BatchWait <= 0 {
+		c.BatchWait = time.Second
+	}
+	if c.MaxBuffer <= 0 {
+		c.MaxBuffer = 16 << 20
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 10
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 500 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.CloseTimeout <= 0 {
+		c.CloseTimeout = 10 * time.Second
+	}
+	if c.OnError == nil {
+		c.OnError = func(err error) { fmt.Println(err) }
+	}
+
+	var err error
+	if p.url, err = pushURL(c.URL, c.Protocol); err != nil {
+		return nil, err
+	}
+	p.client = c.Client
+	if p.client == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if dial != nil {
+			t.DialContext = dial
+		}
+		p.client = &http.Client{Transport: t}
+	}
+
+	p.space = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go p.run()
+	return p, nil
+}
+
+func pushURL(raw string, protocol Protocol) (string, error) {
+	if raw == "" {
+		raw = os.ExpandEnv("${LOKI_PUSH_URL}")
+	}
+	if raw == "" {
+		return "", fmt.Errorf("LOKI_PUSH_URL must be defined")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	path := postPath
+	if protocol == ProtocolOTLP {
+		path = otlpPath
+		u.Path = strings.TrimSuffix(u.Path, postPath)
+	}
+	if !strings.Contains(u.Path, path) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + path
+	}
+	return u.String(), nil
+}
+
+// Push an entry, false when it was dropped
+func (p *Pusher) Push(labels model.LabelSet, t time.Time, line string) bool {
+	return p.PushEntry(labels.String(), logproto.Entry{Timestamp: t, Line: line})
+}
+
+// PushMetadata an entry with structured metadata, trace_id, span_id, request_id
+func (p *Pusher) PushMetadata(labels model.LabelSet, t time.Time, line string, metadata map[string]string) bool {
+	return p.PushEntry(labels.String(), logproto.Entry{Timestamp: t, Line: line, StructuredMetadata: metadataAdapter(metadata)})
+}
+
+func metadataAdapter(metadata map[string]string) push.LabelsAdapter {
+	if len(metadata) == 0 {
+		return nil
+	}
+	m := make(push.LabelsAdapter, 0, len(metadata))
+	for k, v := range metadata {
+		m = append(m, push.LabelAdapter{Name: k, Value: v})
+	}
+	sort.Slice(m, func(i, j int) bool { return m[i].Name < m[j].Name })
+	return m
+}
+
+// PushEntry labels is the LabelSet.String() of the stream, an entry bigger than
+// MaxBuffer is dropped with every Drop policy
+func (p *Pusher) PushEntry(labels string, e logproto.Entry) bool {
+	size := entrySize(labels, e, true)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// never fits, even Block would wait forever
+	if size > p.cfg.MaxBuffer {
+		p.dropped.Add(1)
+		return false
+	}
+	for !p.closed && p.buffered+size > p.cfg.MaxBuffer {
+		if p.cfg.Drop == Block {
+			p.space.Wait()
+			continue
+		}
+		if p.cfg.Drop == DropOldest && len(p.queue) > 0 {
+			old := p.queue[0]
+			p.queue = p.queue[1:]
+			p.buffered -= old.size
+			p.dropped.Add(uint64(old.entries))
+			continue
+		}
+		p.dropped.Add(1)
+		return false
+	}
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+
+	if p.cur == nil {
+		p.cur = newBatch()
+	}
+	before := p.cur.size
+	p.cur.add(labels, e)
+	p.buffered += p.cur.size - before
+	if p.cur.size >= p.cfg.BatchSize {
+		p.cut()
+	}
+	return true
+}
+
+// cut moves the current batch to the send queue, p.mu is held
+func (p *Pusher) cut() {
+	if p.cur == nil {
+		return
+	}
+	p.queue = append(p.queue, p.cur)
+	p.cur = nil
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pusher) Stats() PusherStats {
+	p.mu.Lock()
+	buffered := p.buffered
+	p.mu.Unlock()
+	st := PusherStats{Sent: p.sent.Load(), Dropped: p.dropped.Load(), Failed: p.failed.Load(), Spooled: p.spooled.Load(), Retries: p.retries.Load(), Buffered: buffered}
+	if p.cfg.Spool != nil {
+		st.Spool = p.cfg.Spool.Stats()
+	}
+	return st
+}
+
+// Flush cuts the current batch and waits until the queue is sent or ctx is done
+func (p *Pusher) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	p.cut()
+	p.mu.Unlock()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		buffered := p.buffered
+		p.mu.Unlock()
+		if buffered == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close sends the buffered entries within CloseTimeout, later entries are dropped
+func (p *Pusher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.done
+		return nil
+	}
+	p.closed = true
+	p.cut()
+	p.space.Broadcast()
+	p.mu.Unlock()
+
+	var err error
+	select {
+	case <-p.done:
+	case <-time.After(p.cfg.CloseTimeout):
+		p.cancel()
+		<-p.done
+		err = fmt.Errorf("loki close timeout, %d bytes dropped", p.Stats().Buffered)
+	}
+	p.cancel()
+	if p.sshcon != nil {
+		p.sshcon.Close()
+	}
+	return err
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(max(p.cfg.BatchWait/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.wake:
+		case <-ticker.C:
+		case <-p.ctx.Done():
+		}
+
+		p.mu.Lock()
+		if p.cur != nil && time.Since(p.cur.created) >= p.cfg.BatchWait {
+			p.cut()
+		}
+		p.mu.Unlock()
+
+		if p.cfg.Spool != nil && p.ctx.Err() == nil && p.cfg.Spool.Len() > 0 {
+			p.replay()
+		}
+
+		for {
+			p.mu.Lock()
+			if len(p.queue) == 0 {
+				closed := p.closed
+				p.mu.Unlock()
+				if closed {
+					return
+				}
+				break
+			}
+			b := p.queue[0]
+			p.queue = p.queue[1:]
+			p.mu.Unlock()
+
+			p.deliver(b)
+
+			p.mu.Lock()
+			p.buffered -= b.size
+			p.space.Broadcast()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// deliver sends b after the spooled batches, b is spooled when loki is down
+// or the spool could not be replayed yet
+func (p *Pusher) deliver(b *batch) {
+	var buf []byte
+	var err error
+	if p.cfg.Protocol == ProtocolOTLP {
+		buf, err = encodeOTLP(b)
+	} else {
+		buf, err = b.encode()
+	}
+	if err != nil {
+		p.failed.Add(uint64(b.entries))
+		p.cfg.OnError(err)
+		return
+	}
+
+	switch {
+	case p.ctx.Err() != nil:
+		err = p.ctx.Err()
+	case p.cfg.Spool != nil && p.cfg.Spool.Len() > 0:
+		err = p.replay()
+		if err == nil {
+			err = p.send(buf)
+		}
+	default:
+		err = p.send(buf)
+	}
+	if err == nil {
+		p.sent.Add(uint64(b.entries))
+		return
+	}
+
+	if p.cfg.Spool != nil && (retryable(err) || errors.Is(err, context.Canceled) || errors.Is(err, errReplayWait)) {
+		serr := p.cfg.Spool.Write(buf)
+		if serr == nil {
+			p.spooled.Add(uint64(b.entries))
+			if !errors.Is(err, errReplayWait) && !errors.Is(err, context.Canceled) {
+				p.cfg.OnError(fmt.Errorf("loki spooled %d entries: %w", b.entries, err))
+			}
+			return
+		}
+		err = serr
+	}
+	if errors.Is(err, context.Canceled) {
+		p.dropped.Add(uint64(b.entries))
+		return
+	}
+	p.failed.Add(uint64(b.entries))
+	p.cfg.OnError(err)
+}
+
+var errReplayWait = errors.New("loki spool replay backoff")
+
+// replay posts the spooled batches in order, one attempt each, a failure waits a
+// backoff before the next replay. A batch loki rejects is skipped
+func (p *Pusher) replay() error {
+	if time.Now().Before(p.nextReplay) {
+		return errReplayWait
+	}
+	err := p.cfg.Spool.Replay(func(record []byte) error {
+		err := p.post(record)
+		if err != nil && !retryable(err) {
+			p.cfg.OnError(fmt.Errorf("loki spooled batch rejected: %w", err))
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		p.replayBackoff = min(max(p.replayBackoff*2, p.cfg.MinBackoff), p.cfg.MaxBackoff)
+		p.nextReplay = time.Now().Add(p.replayBackoff)
+		return err
+	}
+	p.replayBackoff = 0
+	return nil
+}
+
+func (p *Pusher) send(buf []byte) error {
+	backoff := p.cfg.MinBackoff
+	for i := 0; ; i++ {
+		err := p.post(buf)
+		if err == nil || !retryable(err) || p.cfg.MaxRetries < 0 || i >= p.cfg.MaxRetries {
+			return err
+		}
+		p.retries.Add(1)
+		select {
+		case <-time.After(backoff):
+		case <-p.ctx.Done():
+			return err
+		}
+		backoff = min(backoff*2, p.cfg.MaxBackoff)
+	}
+}
+
+func (p *Pusher) post(buf []byte) error {
+	ctx, cancel := context.WithTimeout(p.ctx, p.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	setHeader(req.Header, p.cfg.TenantID, p.cfg.User, p.cfg.Password)
+	if p.cfg.Protocol == ProtocolOTLP {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+	} else {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// retryable 429, 5xx, network errors and timeouts
+func retryable(err error) bool {
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode/100 == 5
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,75 @@
+package loki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "429", err: &HTTPError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "503", err: &HTTPError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "400", err: &HTTPError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "timeout", err: fmt.Errorf("post: %w", context.DeadlineExceeded), want: true},
+		{name: "eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "encode", err: errors.New("proto: bad wire type"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushOversize(t *testing.T) {
+	tests := []struct {
+		name string
+		drop DropPolicy
+	}{
+		{name: "drop newest", drop: DropNewest},
+		{name: "drop oldest", drop: DropOldest},
+		{name: "block", drop: Block},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPusher(&PusherConfig{URL: "http://127.0.0.1:1", MaxBuffer: 16, Drop: tt.drop, OnError: func(error) {}})
+			if err != nil {
+				t.Fatalf("NewPusher: %v", err)
+			}
+			defer p.Close()
+
+			done := make(chan bool, 1)
+			go func() {
+				done <- p.Push(model.LabelSet{"job": "test"}, time.Now(), strings.Repeat("x", 64))
+			}()
+			select {
+			case ok := <-done:
+				if ok {
+					t.Errorf("entry bigger than MaxBuffer was accepted")
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Push blocked on an entry bigger than MaxBuffer")
+			}
+			if got := p.Stats().Dropped; got != 1 {
+				t.Errorf("dropped = %d, want 1", got)
+			}
+		})
+	}
+}
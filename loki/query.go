@@ -0,0 +1,566 @@
+package loki
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zhujintao/kit-go/ssh"
+)
+
+const (
+	queryPath       = "/loki/api/v1/query"
+	queryRangePath  = "/loki/api/v1/query_range"
+	labelsPath      = "/loki/api/v1/labels"
+	labelValuesPath = "/loki/api/v1/label/%s/values"
+	seriesPath      = "/loki/api/v1/series"
+	tailPath        = "/loki/api/v1/tail"
+)
+
+type ResultType string
+
+const (
+	ResultStreams ResultType = "streams"
+	ResultMatrix  ResultType = "matrix"
+	ResultVector  ResultType = "vector"
+	ResultScalar  ResultType = "scalar"
+)
+
+type Entry struct {
+	Timestamp time.Time
+	Line      string
+	// structured metadata, with the parsed labels when the query has a parser
+	Metadata map[string]string
+}
+
+type Stream struct {
+	Labels  map[string]string
+	Entries []Entry
+}
+
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// Series of a matrix, a vector series has one sample
+type Series struct {
+	Metric map[string]string
+	Values []Sample
+}
+
+type QueryResult struct {
+	Type    ResultType
+	Streams []Stream
+	// matrix and vector
+	Series []Series
+	Scalar Sample
+	Stats  json.RawMessage
+}
+
+type DroppedEntry struct {
+	Labels    map[string]string
+	Timestamp time.Time
+}
+
+type TailResponse struct {
+	Streams []Stream
+	Dropped []DroppedEntry
+	Err     error
+}
+
+// HTTPError non 2xx response of loki
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %s (%d): %s", e.Status, e.StatusCode, e.Body)
+}
+
+// URL is the loki address http://loki:3100, a push url works too
+//
+// env LOKI_PUSH_URL
+type QueryConfig struct {
+	URL      string
+	TenantID string
+	User     string
+	Password string
+	// default 30s, tail is not limited
+	Timeout time.Duration
+	// default http.Client with the ssh dial when via ssh, set it for httptest.Server.Client()
+	Client *http.Client
+}
+
+type QueryClient struct {
+	base     *url.URL
+	client   *http.Client
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	tenantID string
+	user     string
+	password string
+	cancel   context.CancelFunc
+}
+
+type QueryOption func(v url.Values)
+
+// WithLimit max entries of a streams result
+func WithLimit(n int) QueryOption {
+	return func(v url.Values) { v.Set("limit", strconv.Itoa(n)) }
+}
+
+// WithDirection forward or backward
+func WithDirection(d string) QueryOption {
+	return func(v url.Values) { v.Set("direction", d) }
+}
+
+// WithStep of query_range
+func WithStep(d time.Duration) QueryOption {
+	return func(v url.Values) { v.Set("step", strconv.FormatFloat(d.Seconds(), 'f', -1, 64)) }
+}
+
+// WithTime evaluation time of query, default now
+func WithTime(t time.Time) QueryOption {
+	return func(v url.Values) { v.Set("time", strconv.FormatInt(t.UnixNano(), 10)) }
+}
+
+// WithDelayFor seconds tail delays to catch late entries, at most 5
+func WithDelayFor(sec int) QueryOption {
+	return func(v url.Values) { v.Set("delay_for", strconv.Itoa(sec)) }
+}
+
+// WithParam any other parameter
+func WithParam(key, value string) QueryOption {
+	return func(v url.Values) { v.Set(key, value) }
+}
+
+func NewQueryClient(cfg *QueryConfig) (*QueryClient, error) {
+	return newQueryClient(cfg, nil)
+}
+
+func NewQueryClientViaSSH(sshAddr, sshUser, sshPassword string, cfg *QueryConfig) (*QueryClient, error) {
+	sshcon, err := ssh.NewConn(sshAddr, sshUser, sshPassword)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newQueryClient(cfg, func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return sshcon.Dial(network, addr)
+	})
+	if err != nil {
+		sshcon.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sshcon.SendHello(ctx)
+	c.cancel = func() {
+		cancel()
+		sshcon.Close()
+	}
+	return c, nil
+}
+
+func newQueryClient(cfg *QueryConfig, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*QueryClient, error) {
+	raw := cfg.URL
+	if raw == "" {
+		raw = os.ExpandEnv("${LOKI_PUSH_URL}")
+	}
+	if raw == "" {
+		return nil, fmt.Errorf("loki url must be defined")
+	}
+	base, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.Index(base.Path, "/loki/api/"); i >= 0 {
+		base.Path = base.Path[:i]
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawQuery = ""
+
+	c := &QueryClient{base: base, client: cfg.Client, dial: dial, tenantID: cfg.TenantID, user: cfg.User, password: cfg.Password}
+	if c.client == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if dial != nil {
+			t.DialContext = dial
+		}
+		c.client = &http.Client{Transport: t, Timeout: cfg.Timeout}
+		if c.client.Timeout <= 0 {
+			c.client.Timeout = 30 * time.Second
+		}
+	}
+	return c, nil
+}
+
+// Close the ssh tunnel
+func (c *QueryClient) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return nil
+}
+
+// Query LogQL at one point in time, streams, vector or scalar
+func (c *QueryClient) Query(ctx context.Context, query string, opts ...QueryOption) (*QueryResult, error) {
+	v := url.Values{"query": {query}}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return c.query(ctx, queryPath, v)
+}
+
+// QueryRange LogQL between start and end, streams or matrix
+func (c *QueryClient) QueryRange(ctx context.Context, query string, start, end time.Time, opts ...QueryOption) (*QueryResult, error) {
+	v := url.Values{"query": {query}}
+	setRange(v, start, end)
+	for _, opt := range opts {
+		opt(v)
+	}
+	return c.query(ctx, queryRangePath, v)
+}
+
+// Labels names between start and end, zero times are the loki default of the last 6h
+func (c *QueryClient) Labels(ctx context.Context, start, end time.Time, opts ...QueryOption) ([]string, error) {
+	v := url.Values{}
+	setRange(v, start, end)
+	for _, opt := range opts {
+		opt(v)
+	}
+	var data []string
+	err := c.get(ctx, labelsPath, v, &data)
+	return data, err
+}
+
+// LabelValues of name, WithParam("query", selector) limits the streams
+func (c *QueryClient) LabelValues(ctx context.Context, name string, start, end time.Time, opts ...QueryOption) ([]string, error) {
+	v := url.Values{}
+	setRange(v, start, end)
+	for _, opt := range opts {
+		opt(v)
+	}
+	var data []string
+	err := c.get(ctx, fmt.Sprintf(labelValuesPath, url.PathEscape(name)), v, &data)
+	return data, err
+}
+
+// Series label sets of the streams matching any selector
+func (c *QueryClient) Series(ctx context.Context, start, end time.Time, match ...string) ([]map[string]string, error) {
+	v := url.Values{"match[]": match}
+	setRange(v, start, end)
+	var data []map[string]string
+	err := c.get(ctx, seriesPath, v, &data)
+	return data, err
+}
+
+// Tail streams the new entries of query until ctx is done, the channel is closed after
+// an error response
+func (c *QueryClient) Tail(ctx context.Context, query string, start time.Time, opts ...QueryOption) (<-chan TailResponse, error) {
+	v := url.Values{"query": {query}}
+	if !start.IsZero() {
+		v.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	u := c.url(tailPath, v)
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 10 * time.Second, NetDialContext: c.dial}
+	if t, ok := c.client.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = t.TLSClientConfig
+		if dialer.NetDialContext == nil {
+			dialer.NetDialContext = t.DialContext
+		}
+	}
+	conn, resp, err := dialer.DialContext(ctx, u.String(), c.header())
+	if err != nil {
+		if resp != nil {
+			return nil, responseError(resp)
+		}
+		return nil, err
+	}
+
+	ch := make(chan TailResponse)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go func() {
+		defer close(ch)
+		defer stop()
+		defer conn.Close()
+		for {
+			var msg struct {
+				Streams []rawStream `json:"streams"`
+				Dropped []struct {
+					Labels    map[string]string `json:"labels"`
+					Timestamp string            `json:"timestamp"`
+				} `json:"dropped_entries"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				if ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					select {
+					case ch <- TailResponse{Err: err}:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+			var r TailResponse
+			r.Streams, err = decodeStreams(msg.Streams)
+			for _, d := range msg.Dropped {
+				ts, _ := parseNano(d.Timestamp)
+				r.Dropped = append(r.Dropped, DroppedEntry{Labels: d.Labels, Timestamp: ts})
+			}
+			r.Err = err
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func setRange(v url.Values, start, end time.Time) {
+	if !start.IsZero() {
+		v.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	}
+	if !end.IsZero() {
+		v.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	}
+}
+
+func (c *QueryClient) url(path string, v url.Values) *url.URL {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = v.Encode()
+	return &u
+}
+
+func (c *QueryClient) header() http.Header {
+	h := http.Header{}
+	setHeader(h, c.tenantID, c.user, c.password)
+	return h
+}
+
+func setHeader(h http.Header, tenantID, user, password string) {
+	h.Set("User-Agent", userAgent)
+	if tenantID != "" {
+		h.Set("X-Scope-OrgID", tenantID)
+	}
+	if user != "" {
+		req := http.Request{Header: h}
+		req.SetBasicAuth(user, password)
+	}
+}
+
+func responseError(resp *http.Response) error {
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+	line := ""
+	if scanner.Scan() {
+		line = scanner.Text()
+	}
+	return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: line}
+}
+
+func (c *QueryClient) do(ctx context.Context, path string, v url.Values) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path, v).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp)
+	}
+	var body struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  string          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("loki status %s: %s", body.Status, body.Error)
+	}
+	return body.Data, nil
+}
+
+func (c *QueryClient) get(ctx context.Context, path string, v url.Values, data any) error {
+	raw, err := c.do(ctx, path, v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, data)
+}
+
+func (c *QueryClient) query(ctx context.Context, path string, v url.Values) (*QueryResult, error) {
+	raw, err := c.do(ctx, path, v)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		ResultType ResultType      `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+		Stats      json.RawMessage `json:"stats"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	r := &QueryResult{Type: data.ResultType, Stats: data.Stats}
+
+	switch data.ResultType {
+	case ResultStreams:
+		var streams []rawStream
+		if err := json.Unmarshal(data.Result, &streams); err != nil {
+			return nil, err
+		}
+		r.Streams, err = decodeStreams(streams)
+	case ResultMatrix:
+		var matrix []struct {
+			Metric map[string]string `json:"metric"`
+			Values []rawSample       `json:"values"`
+		}
+		if err := json.Unmarshal(data.Result, &matrix); err != nil {
+			return nil, err
+		}
+		for _, m := range matrix {
+			s := Series{Metric: m.Metric, Values: make([]Sample, len(m.Values))}
+			for i, v := range m.Values {
+				if s.Values[i], err = v.sample(); err != nil {
+					return nil, err
+				}
+			}
+			r.Series = append(r.Series, s)
+		}
+	case ResultVector:
+		var vector []struct {
+			Metric map[string]string `json:"metric"`
+			Value  rawSample         `json:"value"`
+		}
+		if err := json.Unmarshal(data.Result, &vector); err != nil {
+			return nil, err
+		}
+		for _, m := range vector {
+			sample, err := m.Value.sample()
+			if err != nil {
+				return nil, err
+			}
+			r.Series = append(r.Series, Series{Metric: m.Metric, Values: []Sample{sample}})
+		}
+	case ResultScalar:
+		var v rawSample
+		if err := json.Unmarshal(data.Result, &v); err != nil {
+			return nil, err
+		}
+		r.Scalar, err = v.sample()
+	default:
+		return nil, fmt.Errorf("loki result type %q unknown", data.ResultType)
+	}
+	return r, err
+}
+
+type rawStream struct {
+	Stream map[string]string   `json:"stream"`
+	Values [][]json.RawMessage `json:"values"`
+}
+
+func decodeStreams(raw []rawStream) ([]Stream, error) {
+	streams := make([]Stream, len(raw))
+	for i, s := range raw {
+		streams[i] = Stream{Labels: s.Stream, Entries: make([]Entry, len(s.Values))}
+		for j, v := range s.Values {
+			if len(v) < 2 {
+				return nil, fmt.Errorf("loki stream entry has %d values", len(v))
+			}
+			var ts, line string
+			if err := json.Unmarshal(v[0], &ts); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(v[1], &line); err != nil {
+				return nil, err
+			}
+			t, err := parseNano(ts)
+			if err != nil {
+				return nil, err
+			}
+			e := Entry{Timestamp: t, Line: line}
+			if len(v) > 2 {
+				e.Metadata, err = decodeMetadata(v[2])
+				if err != nil {
+					return nil, err
+				}
+			}
+			streams[i].Entries[j] = e
+		}
+	}
+	return streams, nil
+}
+
+// metadata is flat, or structuredMetadata and parsed with the X-Loki-Response-Encoding-Flags categorize-labels
+func decodeMetadata(raw json.RawMessage) (map[string]string, error) {
+	var categorized struct {
+		StructuredMetadata map[string]string `json:"structuredMetadata"`
+		Parsed             map[string]string `json:"parsed"`
+	}
+	if err := json.Unmarshal(raw, &categorized); err == nil && (categorized.StructuredMetadata != nil || categorized.Parsed != nil) {
+		m := make(map[string]string, len(categorized.StructuredMetadata)+len(categorized.Parsed))
+		for k, v := range categorized.Parsed {
+			m[k] = v
+		}
+		for k, v := range categorized.StructuredMetadata {
+			m[k] = v
+		}
+		return m, nil
+	}
+	var m map[string]string
+	return m, json.Unmarshal(raw, &m)
+}
+
+func parseNano(s string) (time.Time, error) {
+	ns, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns), nil
+}
+
+// [unix seconds, "value"]
+type rawSample [2]json.RawMessage
+
+func (r rawSample) sample() (Sample, error) {
+	var ts float64
+	var value string
+	if err := json.Unmarshal(r[0], &ts); err != nil {
+		return Sample{}, err
+	}
+	if err := json.Unmarshal(r[1], &value); err != nil {
+		return Sample{}, err
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return Sample{}, err
+	}
+	sec := int64(ts)
+	return Sample{Timestamp: time.Unix(sec, int64((ts-float64(sec))*1e9)).Round(time.Millisecond), Value: v}, nil
+}
@@ -0,0 +1,237 @@
+package loki
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestQueryClient(t *testing.T, handler http.HandlerFunc) *QueryClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := NewQueryClient(&QueryConfig{URL: srv.URL + "/loki/api/v1/push", TenantID: "ops", Client: srv.Client()})
+	if err != nil {
+		t.Fatalf("NewQueryClient: %v", err)
+	}
+	return c
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *QueryResult
+	}{
+		{
+			name: "streams",
+			body: `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"canal"},"values":[["1700000000000000001","started",{"trace_id":"a1"}]]}]}}`,
+			want: &QueryResult{Type: ResultStreams, Streams: []Stream{{
+				Labels:  map[string]string{"job": "canal"},
+				Entries: []Entry{{Timestamp: time.Unix(0, 1700000000000000001), Line: "started", Metadata: map[string]string{"trace_id": "a1"}}},
+			}}},
+		},
+		{
+			name: "categorized metadata",
+			body: `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"job":"canal"},"values":[["1","l",{"structuredMetadata":{"trace_id":"a1"},"parsed":{"level":"info"}}]]}]}}`,
+			want: &QueryResult{Type: ResultStreams, Streams: []Stream{{
+				Labels:  map[string]string{"job": "canal"},
+				Entries: []Entry{{Timestamp: time.Unix(0, 1), Line: "l", Metadata: map[string]string{"trace_id": "a1", "level": "info"}}},
+			}}},
+		},
+		{
+			name: "vector",
+			body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"canal"},"value":[1700000000.5,"3"]}]}}`,
+			want: &QueryResult{Type: ResultVector, Series: []Series{{
+				Metric: map[string]string{"job": "canal"},
+				Values: []Sample{{Timestamp: time.Unix(1700000000, 5e8), Value: 3}},
+			}}},
+		},
+		{
+			name: "matrix",
+			body: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"canal"},"values":[[1700000000,"1"],[1700000060,"2"]]}]}}`,
+			want: &QueryResult{Type: ResultMatrix, Series: []Series{{
+				Metric: map[string]string{"job": "canal"},
+				Values: []Sample{{Timestamp: time.Unix(1700000000, 0), Value: 1}, {Timestamp: time.Unix(1700000060, 0), Value: 2}},
+			}}},
+		},
+		{
+			name: "scalar",
+			body: `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"42"]}}`,
+			want: &QueryResult{Type: ResultScalar, Scalar: Sample{Timestamp: time.Unix(1700000000, 0), Value: 42}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != queryPath {
+					t.Errorf("path = %s, want %s", r.URL.Path, queryPath)
+				}
+				if got := r.Header.Get("X-Scope-OrgID"); got != "ops" {
+					t.Errorf("tenant = %s, want ops", got)
+				}
+				if got := r.URL.Query().Get("limit"); got != "10" {
+					t.Errorf("limit = %s, want 10", got)
+				}
+				w.Write([]byte(tt.body))
+			})
+			got, err := c.Query(context.Background(), `{job="canal"}`, WithLimit(10))
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			got.Stats = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("result:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   int
+		body   string
+		status int
+	}{
+		{name: "http status", code: http.StatusBadRequest, body: "parse error : syntax error\n", status: http.StatusBadRequest},
+		{name: "loki status", code: http.StatusOK, body: `{"status":"error","error":"bad query"}`},
+		{name: "result type", code: http.StatusOK, body: `{"status":"success","data":{"resultType":"other","result":[]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+				w.Write([]byte(tt.body))
+			})
+			_, err := c.QueryRange(context.Background(), `{job="canal"}`, time.Unix(1, 0), time.Unix(2, 0), WithStep(time.Minute))
+			if err == nil {
+				t.Fatalf("QueryRange: want an error")
+			}
+			var httpErr *HTTPError
+			if got := errors.As(err, &httpErr); got != (tt.status != 0) {
+				t.Fatalf("error %v, want an HTTPError: %v", err, tt.status != 0)
+			}
+			if httpErr != nil && httpErr.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", httpErr.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		call func(c *QueryClient) (any, error)
+		body string
+		want any
+	}{
+		{
+			name: "labels",
+			path: labelsPath,
+			call: func(c *QueryClient) (any, error) { return c.Labels(context.Background(), time.Time{}, time.Time{}) },
+			body: `{"status":"success","data":["job","level"]}`,
+			want: []string{"job", "level"},
+		},
+		{
+			name: "label values",
+			path: "/loki/api/v1/label/job/values",
+			call: func(c *QueryClient) (any, error) {
+				return c.LabelValues(context.Background(), "job", time.Time{}, time.Time{})
+			},
+			body: `{"status":"success","data":["canal"]}`,
+			want: []string{"canal"},
+		},
+		{
+			name: "series",
+			path: seriesPath,
+			call: func(c *QueryClient) (any, error) {
+				return c.Series(context.Background(), time.Time{}, time.Time{}, `{job="canal"}`)
+			},
+			body: `{"status":"success","data":[{"job":"canal","level":"info"}]}`,
+			want: []map[string]string{{"job": "canal", "level": "info"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.path {
+					t.Errorf("path = %s, want %s", r.URL.Path, tt.path)
+				}
+				w.Write([]byte(tt.body))
+			})
+			got, err := tt.call(c)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("result = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTail(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	c := newTestQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tailPath {
+			t.Errorf("path = %s, want %s", r.URL.Path, tailPath)
+		}
+		if got := r.Header.Get("X-Scope-OrgID"); got != "ops" {
+			t.Errorf("tenant = %s, want ops", got)
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"streams":[{"stream":{"job":"canal"},"values":[["1","first"]]}],"dropped_entries":[{"labels":{"job":"canal"},"timestamp":"2"}]}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"streams":[{"stream":{"job":"canal"},"values":[["3","second"]]}]}`))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch, err := c.Tail(ctx, `{job="canal"}`, time.Time{}, WithDelayFor(1))
+	if err != nil {
+		t.Fatalf("Tail: %v", err)
+	}
+	var lines []string
+	var dropped int
+	for r := range ch {
+		if r.Err != nil {
+			t.Fatalf("tail response: %v", r.Err)
+		}
+		dropped += len(r.Dropped)
+		for _, s := range r.Streams {
+			for _, e := range s.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %v, want %v", lines, want)
+	}
+	if dropped != 1 {
+		t.Errorf("dropped entries = %d, want 1", dropped)
+	}
+}
+
+func TestTailHTTPError(t *testing.T) {
+	c := newTestQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "tail disabled", http.StatusForbidden)
+	})
+	_, err := c.Tail(context.Background(), `{job="canal"}`, time.Time{})
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden {
+		t.Errorf("error = %v, want HTTPError 403", err)
+	}
+}
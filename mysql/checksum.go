@@ -0,0 +1,302 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// Chunk primary key range (Lo, Hi], an empty bound is unbounded
+type Chunk struct {
+	Lo string
+	Hi string
+}
+
+func (ch Chunk) String() string {
+	lo, hi := ch.Lo, ch.Hi
+	if lo == "" {
+		lo = "-inf"
+	}
+	if hi == "" {
+		hi = "+inf"
+	}
+	return "(" + lo + ", " + hi + "]"
+}
+
+// Where condition of the chunk on key, "1=1" when unbounded
+func (ch Chunk) Where(key string) (string, []interface{}) {
+	var cond []string
+	var args []interface{}
+	if ch.Lo != "" {
+		cond = append(cond, key+" > ?")
+		args = append(args, ch.Lo)
+	}
+	if ch.Hi != "" {
+		cond = append(cond, key+" <= ?")
+		args = append(args, ch.Hi)
+	}
+	if len(cond) == 0 {
+		return "1=1", nil
+	}
+	return strings.Join(cond, " AND "), args
+}
+
+// ChunkSum row count and bit xor of the crc32 of every row
+type ChunkSum struct {
+	Count int64
+	Crc   uint64
+}
+
+// ChecksumTarget a replicated copy of the source table, *Conn is a mysql replica
+type ChecksumTarget interface {
+	Checksum(table *TableInfo, chunk Chunk) (ChunkSum, error)
+}
+
+type Mismatch struct {
+	Schema string
+	Table  string
+	Chunk  Chunk
+	Source ChunkSum
+	Target ChunkSum
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s.%s %s source count=%d crc=%d target count=%d crc=%d", m.Schema, m.Table, m.Chunk, m.Source.Count, m.Source.Crc, m.Target.Count, m.Target.Crc)
+}
+
+// Checker compares the source and target chunk by chunk, tables need a single column primary key
+//
+// values are compared as strings of ChecksumKindOf, json columns may report false mismatches
+type Checker struct {
+	Source *Conn
+	Target ChecksumTarget
+	// rows per chunk, default 1000
+	ChunkSize int
+	// optional repair pass, called with the source rows of every mismatching chunk
+	Repair func(table *TableInfo, chunk Chunk, rows [][]interface{}) error
+}
+
+// Check tables db.table, returns the mismatching primary key ranges
+func (c *Checker) Check(tables ...string) ([]Mismatch, error) {
+	var list []Mismatch
+	for _, t := range tables {
+		db, table, ok := strings.Cut(t, ".")
+		if !ok {
+			return list, fmt.Errorf("table %s must be db.table", t)
+		}
+		m, err := c.CheckTable(db, table)
+		list = append(list, m...)
+		if err != nil {
+			return list, err
+		}
+	}
+	return list, nil
+}
+
+func (c *Checker) CheckTable(db, table string) ([]Mismatch, error) {
+	info, err := c.Source.GetTableInfo(db, table)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := pkColumn(info)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := c.chunks(info, pk)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Mismatch
+	for _, chunk := range chunks {
+		src, err := c.Source.Checksum(info, chunk)
+		if err != nil {
+			return list, fmt.Errorf("source %s.%s %s: %w", db, table, chunk, err)
+		}
+		dst, err := c.Target.Checksum(info, chunk)
+		if err != nil {
+			return list, fmt.Errorf("target %s.%s %s: %w", db, table, chunk, err)
+		}
+		if src == dst {
+			continue
+		}
+		list = append(list, Mismatch{Schema: db, Table: table, Chunk: chunk, Source: src, Target: dst})
+		if c.Repair == nil {
+			continue
+		}
+		rows, err := c.Source.ChunkRows(info, chunk)
+		if err != nil {
+			return list, err
+		}
+		if err := c.Repair(info, chunk, rows); err != nil {
+			return list, fmt.Errorf("repair %s.%s %s: %w", db, table, chunk, err)
+		}
+	}
+	return list, nil
+}
+
+// chunks (-inf, min], (min, b1] ... (bn, +inf), the open ends catch rows only the target has
+func (c *Checker) chunks(info *TableInfo, pk *TableColumn) ([]Chunk, error) {
+	size := c.ChunkSize
+	if size <= 0 {
+		size = 1000
+	}
+	name := backQuote(info.Schema) + "." + backQuote(info.Name)
+	key := backQuote(pk.Name)
+
+	r, err := c.Source.Execute("SELECT MIN(" + key + ") FROM " + name)
+	if err != nil {
+		return nil, err
+	}
+	start, _ := r.GetString(0, 0)
+	if start == "" {
+		return []Chunk{{}}, nil
+	}
+	bounds := c.Source.GetNextPage(name, key, start, size)
+	if bounds == nil {
+		return nil, fmt.Errorf("%s paging failed", name)
+	}
+
+	chunks := []Chunk{{Hi: bounds[0]}}
+	for i := 1; i < len(bounds); i++ {
+		chunks = append(chunks, Chunk{Lo: bounds[i-1], Hi: bounds[i]})
+	}
+	chunks = append(chunks, Chunk{Lo: bounds[len(bounds)-1]})
+	return chunks, nil
+}
+
+// Checksum of the chunk computed by the server
+func (c *Conn) Checksum(table *TableInfo, chunk Chunk) (ChunkSum, error) {
+	pk, err := pkColumn(table)
+	if err != nil {
+		return ChunkSum{}, err
+	}
+	exprs := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		exprs[i] = "IFNULL(" + checksumColumn(&col) + ", '\\\\N')"
+	}
+	where, args := chunk.Where(backQuote(pk.Name))
+	sql := "SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', " + strings.Join(exprs, ", ") + "))), 0) FROM " +
+		backQuote(table.Schema) + "." + backQuote(table.Name) + " WHERE " + where
+
+	r, err := c.Execute(sql, args...)
+	if err != nil {
+		return ChunkSum{}, err
+	}
+	count, _ := r.GetInt(0, 0)
+	crc, _ := r.GetUint(0, 1)
+	return ChunkSum{Count: count, Crc: crc}, nil
+}
+
+// ChunkRows source rows of the chunk in column order
+func (c *Conn) ChunkRows(table *TableInfo, chunk Chunk) ([][]interface{}, error) {
+	pk, err := pkColumn(table)
+	if err != nil {
+		return nil, err
+	}
+	where, args := chunk.Where(backQuote(pk.Name))
+	r, err := c.Execute("SELECT * FROM "+backQuote(table.Schema)+"."+backQuote(table.Name)+" WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]interface{}, len(r.Values))
+	for i, values := range r.Values {
+		row := make([]interface{}, len(values))
+		for j := range values {
+			row[j] = values[j].Value()
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// ReplaceRepair deletes the chunk on a mysql replica and inserts the source rows again,
+// in one transaction on one connection
+func ReplaceRepair(target *Conn) func(table *TableInfo, chunk Chunk, rows [][]interface{}) error {
+	dml := &DmlDefault{}
+	return func(table *TableInfo, chunk Chunk, rows [][]interface{}) error {
+		pk, err := pkColumn(table)
+		if err != nil {
+			return err
+		}
+		where, args := chunk.Where(backQuote(pk.Name))
+		tx := &DmlTx{Statements: make([]Statement, 0, len(rows)+3)}
+		tx.Statements = append(tx.Statements, Statement{Sql: "BEGIN"})
+		tx.Statements = append(tx.Statements, Statement{"DELETE FROM " + backQuote(table.Schema) + "." + backQuote(table.Name) + " WHERE " + where, args})
+		for _, row := range rows {
+			sql, values := dml.Insert(table, row)
+			tx.Statements = append(tx.Statements, Statement{sql, values})
+		}
+		tx.Statements = append(tx.Statements, Statement{Sql: "COMMIT"})
+		return target.ExecTx(context.Background(), tx)
+	}
+}
+
+func pkColumn(table *TableInfo) (*TableColumn, error) {
+	if len(table.PKColumns) != 1 {
+		return nil, fmt.Errorf("%s.%s needs a single column primary key", table.Schema, table.Name)
+	}
+	return table.GetPKColumn(0), nil
+}
+
+// ChecksumKind string form of a column in a checksum, the source and the
+// clickhouse target build their expressions from the same kind so equal rows
+// give equal strings on both sides
+type ChecksumKind int
+
+const (
+	// the value as a string
+	ChecksumText ChecksumKind = iota
+	// set and bit as the integer value, clickhouse keeps them as UInt64
+	ChecksumNumber
+	// exact digits without trailing zeros, 1.50 and 1.5 agree
+	ChecksumDecimal
+	// ChecksumFloatScale fractional digits, the digits past float precision differ
+	ChecksumFloat
+	// ChecksumDoubleScale fractional digits
+	ChecksumDouble
+	// YYYY-MM-DD hh:mm:ss, the clickhouse DateTime has no fractional seconds
+	ChecksumDateTime
+)
+
+const (
+	ChecksumFloatScale  = 4
+	ChecksumDoubleScale = 6
+)
+
+// ChecksumKindOf kind of a column type
+func ChecksumKindOf(col *TableColumn) ChecksumKind {
+	switch col.Type {
+	case schema.TYPE_SET, schema.TYPE_BIT:
+		return ChecksumNumber
+	case schema.TYPE_DECIMAL:
+		return ChecksumDecimal
+	case schema.TYPE_FLOAT:
+		if strings.HasPrefix(strings.ToLower(col.RawType), "float") {
+			return ChecksumFloat
+		}
+		return ChecksumDouble
+	case schema.TYPE_DATETIME, schema.TYPE_TIMESTAMP:
+		return ChecksumDateTime
+	}
+	return ChecksumText
+}
+
+func checksumColumn(col *TableColumn) string {
+	name := backQuote(col.Name)
+	switch ChecksumKindOf(col) {
+	case ChecksumNumber:
+		return "CAST(" + name + " + 0 AS CHAR)"
+	case ChecksumDecimal:
+		return "IF(" + name + " LIKE '%.%', TRIM(TRAILING '.' FROM TRIM(TRAILING '0' FROM CAST(" + name + " AS CHAR))), CAST(" + name + " AS CHAR))"
+	case ChecksumFloat:
+		return fmt.Sprintf("CAST(CAST(%s AS DECIMAL(65, %d)) AS CHAR)", name, ChecksumFloatScale)
+	case ChecksumDouble:
+		return fmt.Sprintf("CAST(CAST(%s AS DECIMAL(65, %d)) AS CHAR)", name, ChecksumDoubleScale)
+	case ChecksumDateTime:
+		return "DATE_FORMAT(" + name + ", '%Y-%m-%d %H:%i:%s')"
+	}
+	return "CAST(" + name + " AS CHAR)"
+}
@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+func TestChecksumColumn(t *testing.T) {
+	tests := []struct {
+		col  TableColumn
+		kind ChecksumKind
+		want string
+	}{
+		{TableColumn{Name: "c", Type: schema.TYPE_STRING, RawType: "varchar(20)"}, ChecksumText, "CAST(`c` AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_NUMBER, RawType: "int(11)"}, ChecksumText, "CAST(`c` AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_DATE, RawType: "date"}, ChecksumText, "CAST(`c` AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_SET, RawType: "set('a','b')"}, ChecksumNumber, "CAST(`c` + 0 AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_BIT, RawType: "bit(8)"}, ChecksumNumber, "CAST(`c` + 0 AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"}, ChecksumDecimal,
+			"IF(`c` LIKE '%.%', TRIM(TRAILING '.' FROM TRIM(TRAILING '0' FROM CAST(`c` AS CHAR))), CAST(`c` AS CHAR))"},
+		{TableColumn{Name: "c", Type: schema.TYPE_FLOAT, RawType: "float"}, ChecksumFloat, "CAST(CAST(`c` AS DECIMAL(65, 4)) AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_FLOAT, RawType: "FLOAT(7,3)"}, ChecksumFloat, "CAST(CAST(`c` AS DECIMAL(65, 4)) AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_FLOAT, RawType: "double"}, ChecksumDouble, "CAST(CAST(`c` AS DECIMAL(65, 6)) AS CHAR)"},
+		{TableColumn{Name: "c", Type: schema.TYPE_DATETIME, RawType: "datetime(6)"}, ChecksumDateTime, "DATE_FORMAT(`c`, '%Y-%m-%d %H:%i:%s')"},
+		{TableColumn{Name: "c", Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"}, ChecksumDateTime, "DATE_FORMAT(`c`, '%Y-%m-%d %H:%i:%s')"},
+	}
+	for _, tt := range tests {
+		if got := ChecksumKindOf(&tt.col); got != tt.kind {
+			t.Errorf("ChecksumKindOf(%s) = %d, want %d", tt.col.RawType, got, tt.kind)
+		}
+		if got := checksumColumn(&tt.col); got != tt.want {
+			t.Errorf("checksumColumn(%s) = %s, want %s", tt.col.RawType, got, tt.want)
+		}
+	}
+}
+
+func TestCheckerChunks(t *testing.T) {
+	ids := []int64{1, 2, 3, 5, 8}
+	s := newFakeServer(t, func(q query) (*reply, error) {
+		switch {
+		case q.sql == "SELECT MIN(`id`) FROM `db`.`t`":
+			return &reply{names: []string{"min"}, rows: [][]interface{}{{ids[0]}}}, nil
+		case strings.HasPrefix(q.sql, "SELECT MAX(`id`) FROM (SELECT `id` FROM `db`.`t` WHERE `id` > ?"):
+			lo := argInt(q.args[0])
+			var hi interface{}
+			n := 0
+			for _, id := range ids {
+				if id > lo && n < 2 {
+					hi, n = id, n+1
+				}
+			}
+			return &reply{names: []string{"max"}, rows: [][]interface{}{{hi}}}, nil
+		}
+		return nil, fmt.Errorf("unexpected %s", q.sql)
+	})
+	c := NewClient(s.config())
+	defer c.Close()
+	checker := &Checker{Source: c, ChunkSize: 2}
+	info := &TableInfo{Schema: "db", Name: "t"}
+	chunks, err := checker.chunks(info, &TableColumn{Name: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Chunk{{Hi: "1"}, {Lo: "1", Hi: "3"}, {Lo: "3", Hi: "8"}, {Lo: "8"}}
+	if fmt.Sprint(chunks) != fmt.Sprint(want) {
+		t.Errorf("chunks = %v, want %v", chunks, want)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/go-mysql-org/go-mysql/client"
 	"github.com/go-mysql-org/go-mysql/mysql"
@@ -20,6 +22,9 @@ type Conn struct {
 	ctx      context.Context
 	cfg      *Config
 	cancel   context.CancelFunc
+	db       string
+	lastUsed time.Time
+	pool     *pool
 }
 
 func NewClient(cfg *Config) *Conn {
@@ -31,6 +36,9 @@ func NewClient(cfg *Config) *Conn {
 	}
 	c.cfg = cfg
 	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if cfg.Pool {
+		c.pool = newPool(c)
+	}
 	return c
 
 }
@@ -57,6 +65,9 @@ func NewClientViaSSH(sshAddr, sshUser, sshPassword string, cfg *Config) *Conn {
 	cfg.Dialer = viasshDialer
 	c.cfg = cfg
 	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if cfg.Pool {
+		c.pool = newPool(c)
+	}
 	return c
 
 }
@@ -67,137 +78,188 @@ func (c *Conn) GetTableInfo(db, table string) (*schema.Table, error) {
 }
 
 func (c *Conn) Close() {
+	c.cancel()
+	if c.pool != nil {
+		c.pool.close()
+		return
+	}
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
 	if c.conn == nil {
 		return
 	}
 	c.conn.Close()
+	c.conn = nil
 }
 func (c *Conn) GetConfig() *Config {
 	return c.cfg
 }
 
-func (c *Conn) connect(options ...client.Option) (*client.Conn, error) {
-	ctx, cancel := context.WithTimeout(c.ctx, time.Second*10)
+func (c *Conn) connect(ctx context.Context, db string) (*client.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	return client.ConnectWithDialer(ctx, "", c.cfg.Addr,
-		c.cfg.User, c.cfg.Password, "", c.cfg.Dialer, options...)
-}
-func (c *Conn) UseDB(db string, args ...interface{}) (err error) {
-	c.connLock.Lock()
-	defer c.connLock.Unlock()
-	argF := make([]client.Option, 0)
-	argF = append(argF, func(conn *client.Conn) error {
+	options := []client.Option{func(conn *client.Conn) error {
 		conn.ReadTimeout = c.cfg.ReadTimeout
 		conn.WriteTimeout = c.cfg.WriteTimeout
 		return nil
-	})
+	}}
 	if c.cfg.TLSConfig != nil {
-		argF = append(argF, func(conn *client.Conn) error {
+		options = append(options, func(conn *client.Conn) error {
 			conn.SetTLSConfig(c.cfg.TLSConfig)
 			return nil
 		})
 	}
+	conn, err := client.ConnectWithDialer(ctx, "", c.cfg.Addr,
+		c.cfg.User, c.cfg.Password, db, c.cfg.Dialer, options...)
+	if err != nil {
+		return nil, err
+	}
+	for _, sql := range c.cfg.InitStatements {
+		if _, err := conn.Execute(sql); err != nil {
+			conn.Close()
+			return nil, errors.Annotatef(err, "init %q", sql)
+		}
+	}
+	return conn, nil
+}
+
+// run fn on a connection, a connection found broken before fn is sent is replaced,
+// after fn failed on a bad connection it is retried 3 times only when idempotent
+// and not inside a transaction, a statement that reached the server is never resent
+func (c *Conn) run(ctx context.Context, idempotent bool, fn func(conn *client.Conn) error) (err error) {
+	if c.pool != nil {
+		return c.pool.run(ctx, idempotent, fn)
+	}
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
 
 	retryNum := 3
 	for i := 0; i < retryNum; i++ {
+		if c.conn != nil && c.stale(c.conn, c.lastUsed, idempotent) {
+			inTx := c.conn.IsInTransaction()
+			c.conn.Close()
+			c.conn = nil
+			// the open transaction is lost, the statement must not run without it
+			if inTx {
+				return errors.Annotate(mysql.ErrBadConn, "transaction lost")
+			}
+		}
 		if c.conn == nil {
-			c.conn, err = c.connect(argF...)
+			c.conn, err = c.connect(ctx, c.db)
 			if err != nil {
 				return errors.Trace(err)
 			}
 		}
-
-		err = c.conn.UseDB(db)
-		if err != nil {
-			if mysql.ErrorEqual(err, mysql.ErrBadConn) {
-				c.conn.Close()
-				c.conn = nil
+		retry := idempotent && !c.conn.IsInTransaction()
+		var ok bool
+		ok, err = c.exec(ctx, c.conn, fn)
+		c.lastUsed = time.Now()
+		if !ok {
+			c.conn.Close()
+			c.conn = nil
+			if retry && ctx.Err() == nil {
 				continue
 			}
-			return err
 		}
 		break
 	}
 	return err
 }
 
-func (c *Conn) Execute(cmd string, args ...interface{}) (rr *mysql.Result, err error) {
-	c.connLock.Lock()
-	defer c.connLock.Unlock()
-	argF := make([]client.Option, 0)
-	argF = append(argF, func(conn *client.Conn) error {
-		conn.ReadTimeout = c.cfg.ReadTimeout
-		conn.WriteTimeout = c.cfg.WriteTimeout
-		return nil
-	})
-	if c.cfg.TLSConfig != nil {
-		argF = append(argF, func(conn *client.Conn) error {
-			conn.SetTLSConfig(c.cfg.TLSConfig)
-			return nil
-		})
+// stale pings a connection unused for a second before a statement that can't be retried
+func (c *Conn) stale(conn *client.Conn, lastUsed time.Time, idempotent bool) bool {
+	return !idempotent && time.Since(lastUsed) > time.Second && conn.Ping() != nil
+}
+
+// idempotent statements are retried on a new connection
+func idempotent(cmd string) bool {
+	cmd = strings.TrimLeft(cmd, " \t\r\n(")
+	i := strings.IndexFunc(cmd, func(r rune) bool { return !unicode.IsLetter(r) })
+	if i < 0 {
+		i = len(cmd)
 	}
+	switch strings.ToUpper(cmd[:i]) {
+	case "SELECT", "SHOW", "DESC", "DESCRIBE", "EXPLAIN", "USE":
+		return true
+	}
+	return false
+}
 
-	retryNum := 3
-	for i := 0; i < retryNum; i++ {
-		if c.conn == nil {
-			c.conn, err = c.connect(argF...)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-		}
+// exec fn on conn, when ctx is done the query is killed and conn can't be used again
+func (c *Conn) exec(ctx context.Context, conn *client.Conn, fn func(conn *client.Conn) error) (bool, error) {
+	id := conn.GetConnectionID()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Conn.Conn.Close()
+		c.kill(id)
+	})
+	err := fn(conn)
+	if !stop() {
+		return false, ctx.Err()
+	}
+	if mysql.ErrorEqual(err, mysql.ErrBadConn) {
+		return false, err
+	}
+	return true, err
+}
 
-		rr, err = c.conn.Execute(cmd, args...)
-		if err != nil {
-			if mysql.ErrorEqual(err, mysql.ErrBadConn) {
-				c.conn.Close()
-				c.conn = nil
-				continue
-			}
-			return nil, err
-		}
-		break
+// kill the query still running on the server after the client gave up
+func (c *Conn) kill(id uint32) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := client.ConnectWithDialer(ctx, "", c.cfg.Addr, c.cfg.User, c.cfg.Password, "", c.cfg.Dialer)
+	if err != nil {
+		return
 	}
-	return rr, err
+	defer conn.Close()
+	conn.Execute(fmt.Sprintf("KILL QUERY %d", id))
 }
 
-func (c *Conn) ExecuteSelectStreaming(cmd string, perRowCallback func(row []mysql.FieldValue) error, perResultCallback func(result *mysql.Result) error) (err error) {
-	c.connLock.Lock()
-	defer c.connLock.Unlock()
-	argF := make([]client.Option, 0)
-	argF = append(argF, func(conn *client.Conn) error {
-		conn.ReadTimeout = c.cfg.ReadTimeout
-		conn.WriteTimeout = c.cfg.WriteTimeout
+func (c *Conn) UseDB(db string, args ...interface{}) (err error) {
+	return c.UseDBContext(context.Background(), db)
+}
+
+// UseDBContext the db is kept and used by every new connection
+func (c *Conn) UseDBContext(ctx context.Context, db string) error {
+	if c.pool != nil {
+		return c.pool.useDB(ctx, db)
+	}
+	return c.run(ctx, true, func(conn *client.Conn) error {
+		if err := conn.UseDB(db); err != nil {
+			return err
+		}
+		c.db = db
 		return nil
 	})
-	if c.cfg.TLSConfig != nil {
-		argF = append(argF, func(conn *client.Conn) error {
-			conn.SetTLSConfig(c.cfg.TLSConfig)
-			return nil
-		})
+}
+
+func (c *Conn) Execute(cmd string, args ...interface{}) (rr *mysql.Result, err error) {
+	return c.ExecuteContext(context.Background(), cmd, args...)
+}
+
+// ExecuteContext cancel ctx to kill the query
+func (c *Conn) ExecuteContext(ctx context.Context, cmd string, args ...interface{}) (rr *mysql.Result, err error) {
+	err = c.run(ctx, idempotent(cmd), func(conn *client.Conn) error {
+		rr, err = conn.Execute(cmd, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return rr, nil
+}
 
-	retryNum := 3
-	for i := 0; i < retryNum; i++ {
-		if c.conn == nil {
-			c.conn, err = c.connect(argF...)
-			if err != nil {
-				return errors.Trace(err)
-			}
-		}
+func (c *Conn) ExecuteSelectStreaming(cmd string, perRowCallback func(row []mysql.FieldValue) error, perResultCallback func(result *mysql.Result) error) (err error) {
+	return c.ExecuteSelectStreamingContext(context.Background(), cmd, perRowCallback, perResultCallback)
+}
+
+// ExecuteSelectStreamingContext cancel ctx to kill the query, rows already passed to perRowCallback are kept,
+// it is not retried so no row is passed twice
+func (c *Conn) ExecuteSelectStreamingContext(ctx context.Context, cmd string, perRowCallback func(row []mysql.FieldValue) error, perResultCallback func(result *mysql.Result) error) error {
+	return c.run(ctx, false, func(conn *client.Conn) error {
 		var result mysql.Result
-		err = c.conn.ExecuteSelectStreaming(cmd, &result, perRowCallback, perResultCallback)
-		if err != nil {
-			if mysql.ErrorEqual(err, mysql.ErrBadConn) {
-				c.conn.Close()
-				c.conn = nil
-				continue
-			}
-			return err
-		}
-		break
-	}
-	return err
+		return conn.ExecuteSelectStreaming(cmd, &result, perRowCallback, perResultCallback)
+	})
 }
 
 func (c *Conn) GetNextPage(table, key, startId string, limit int) []string {
@@ -211,13 +273,13 @@ func (c *Conn) GetNextPage(table, key, startId string, limit int) []string {
 	for {
 		ts := time.Now()
 		count++
-		sql := fmt.Sprintf("SELECT MAX(%s) FROM (SELECT %s FROM %s WHERE %s > ? ORDER BY ID LIMIT %d) a", key, key, table, key, limit)
+		sql := fmt.Sprintf("SELECT MAX(%s) FROM (SELECT %s FROM %s WHERE %s > ? ORDER BY %s LIMIT %d) a", key, key, table, key, key, limit)
 
 		r, err := c.Execute(sql, maxid)
 		if err != nil {
 			return nil
 		}
-		maxid = string(r.Values[0][0].AsString())
+		maxid, _ = r.GetString(0, 0)
 		if maxid == "" {
 			break
 		}
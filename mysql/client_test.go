@@ -0,0 +1,69 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"SELECT 1", true},
+		{" \n(select 1)", true},
+		{"show slave status", true},
+		{"USE db", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"UPDATE t SET a = 1", false},
+		{"BEGIN", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := idempotent(tt.cmd); got != tt.want {
+			t.Errorf("idempotent(%q) = %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestInitStatements(t *testing.T) {
+	s := newFakeServer(t, func(q query) (*reply, error) {
+		if q.sql == "SELECT 1" {
+			return &reply{names: []string{"1"}, rows: [][]interface{}{{int64(1)}}}, nil
+		}
+		return nil, nil
+	})
+	cfg := s.config()
+	cfg.InitStatements = []string{"SET NAMES utf8mb4"}
+	parent := NewClient(cfg)
+	defer parent.Close()
+	c := newSessionClient(parent, "SET time_zone = '+00:00'")
+	defer c.Close()
+
+	if _, err := c.Execute("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	first := s.received()[0].conn
+	s.drop(first)
+	// the broken connection is replaced, the session is set up again before the retry
+	if _, err := c.Execute("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	byConn := map[uint32][]string{}
+	for _, q := range s.received() {
+		byConn[q.conn] = append(byConn[q.conn], q.sql)
+	}
+	if len(byConn) != 2 {
+		t.Fatalf("connections = %d, want 2: %q", len(byConn), byConn)
+	}
+	want := []string{"SET NAMES utf8mb4", "SET time_zone = '+00:00'", "SELECT 1"}
+	for id, sqls := range byConn {
+		if strings.Join(sqls, "; ") != strings.Join(want, "; ") {
+			t.Errorf("connection %d = %q, want %q", id, sqls, want)
+		}
+	}
+	if len(cfg.InitStatements) != 1 {
+		t.Errorf("parent InitStatements = %q, want it unchanged", cfg.InitStatements)
+	}
+}
@@ -0,0 +1,540 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// Decoder maps canal rows and ExecuteSelectStreaming rows to maps and structs by column name
+//
+//	type User struct {
+//		Id      uint64    `mysql:"id"`
+//		Name    *string   `mysql:"name"`
+//		Balance big.Rat   `mysql:"balance"`
+//		Created time.Time `mysql:"created_at"`
+//		Tags    []string  `mysql:"tags"`
+//		Extra   json.RawMessage
+//	}
+//
+// untagged fields match the column name case insensitively, `mysql:"-"` is skipped.
+//
+// values by column type
+//
+//	NULL                 nil, zero value or nil pointer
+//	int, unsigned int    int64, uint64
+//	DECIMAL              string, or *big.Rat with DecimalRat
+//	DATETIME, TIMESTAMP  time.Time with fractional seconds, zero dates are time.Time{}
+//	DATE                 time.Time
+//	BIT                  uint64
+//	SET                  []string
+//	ENUM                 string
+//	JSON                 decoded any, json.RawMessage or the field type
+//	BINARY, BLOB         []byte
+type Decoder struct {
+	// DECIMAL as *big.Rat in maps instead of string
+	DecimalRat bool
+	// time zone of DATETIME and TIMESTAMP strings, default time.Local
+	Location *time.Location
+
+	fields sync.Map
+}
+
+var defaultDecoder = &Decoder{}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// DecodeMap row of a canal.RowsEvent, or FieldValues of a streamed row
+func DecodeMap(table *TableInfo, row []interface{}) (map[string]interface{}, error) {
+	return defaultDecoder.Map(table, row)
+}
+
+// Decode row into the struct pointer dst
+func Decode(table *TableInfo, row []interface{}, dst interface{}) error {
+	return defaultDecoder.Struct(table, row, dst)
+}
+
+// FieldValues values of an ExecuteSelectStreaming row for DecodeMap and Decode
+func FieldValues(row []FieldValue) []interface{} {
+	values := make([]interface{}, len(row))
+	for i := range row {
+		values[i] = row[i].Value()
+	}
+	return values
+}
+
+func (d *Decoder) Map(table *TableInfo, row []interface{}) (map[string]interface{}, error) {
+	if len(row) > len(table.Columns) {
+		return nil, fmt.Errorf("%s.%s has %d columns, row has %d", table.Schema, table.Name, len(table.Columns), len(row))
+	}
+	m := make(map[string]interface{}, len(row))
+	for i, value := range row {
+		col := &table.Columns[i]
+		v, err := d.Value(col, value)
+		if err != nil {
+			return nil, err
+		}
+		if raw, ok := v.(json.RawMessage); ok {
+			var f interface{}
+			if err := json.Unmarshal(raw, &f); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			v = f
+		}
+		m[col.Name] = v
+	}
+	return m, nil
+}
+
+func (d *Decoder) Struct(table *TableInfo, row []interface{}, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode destination must be a struct pointer, got %T", dst)
+	}
+	rv = rv.Elem()
+	fields := d.structFields(rv.Type())
+	for i, value := range row {
+		if i >= len(table.Columns) {
+			break
+		}
+		col := &table.Columns[i]
+		index, ok := fields[strings.ToLower(col.Name)]
+		if !ok {
+			continue
+		}
+		v, err := d.normalize(col, value)
+		if err != nil {
+			return err
+		}
+		if err := assign(rv.FieldByIndex(index), v); err != nil {
+			return fmt.Errorf("column %s: %w", col.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) structFields(t reflect.Type) map[string][]int {
+	if f, ok := d.fields.Load(t); ok {
+		return f.(map[string][]int)
+	}
+	fields := map[string][]int{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("mysql"); ok {
+			if tag == "-" {
+				continue
+			}
+			name, _, _ = strings.Cut(tag, ",")
+		}
+		fields[strings.ToLower(name)] = f.Index
+	}
+	d.fields.Store(t, fields)
+	return fields
+}
+
+// decimal kept as text until the destination is known
+type decimal string
+
+// Value normalizes a binlog or text protocol value of col, see Decoder, JSON is json.RawMessage
+func (d *Decoder) Value(col *TableColumn, value interface{}) (interface{}, error) {
+	v, err := d.normalize(col, value)
+	if dv, ok := v.(decimal); ok {
+		if !d.DecimalRat {
+			return string(dv), nil
+		}
+		r, ok := new(big.Rat).SetString(string(dv))
+		if !ok {
+			return nil, fmt.Errorf("column %s: invalid decimal %s", col.Name, dv)
+		}
+		return r, nil
+	}
+	return v, err
+}
+
+func (d *Decoder) normalize(col *TableColumn, value interface{}) (interface{}, error) {
+	if fv, ok := value.(FieldValue); ok {
+		value = fv.Value()
+	}
+	if value == nil {
+		return nil, nil
+	}
+	v, err := d.value(col, value)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", col.Name, err)
+	}
+	return v, nil
+}
+
+func (d *Decoder) value(col *TableColumn, value interface{}) (interface{}, error) {
+	switch col.Type {
+	case schema.TYPE_NUMBER, schema.TYPE_MEDIUM_INT:
+		if col.IsUnsigned {
+			return unsignedValue(col, value)
+		}
+		return signedValue(value)
+	case schema.TYPE_FLOAT:
+		switch v := value.(type) {
+		case float32:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		return strconv.ParseFloat(toString(value), 64)
+	case schema.TYPE_DECIMAL:
+		return decimal(toString(value)), nil
+	case schema.TYPE_ENUM:
+		if v, ok := value.(int64); ok {
+			return enumValue(col, v), nil
+		}
+		return toString(value), nil
+	case schema.TYPE_SET:
+		if v, ok := value.(int64); ok {
+			return setValue(col, v), nil
+		}
+		if s := toString(value); s != "" {
+			return strings.Split(s, ","), nil
+		}
+		return []string{}, nil
+	case schema.TYPE_BIT:
+		return bitValue(value), nil
+	case schema.TYPE_DATETIME, schema.TYPE_TIMESTAMP:
+		if v, ok := value.(time.Time); ok {
+			return v, nil
+		}
+		return parseTime(mysql.TimeFormat, toString(value), d.location())
+	case schema.TYPE_DATE:
+		if v, ok := value.(time.Time); ok {
+			return v, nil
+		}
+		return parseTime(mysqlDateFormat, toString(value), d.location())
+	case schema.TYPE_JSON:
+		switch v := value.(type) {
+		case []byte:
+			return json.RawMessage(v), nil
+		case string:
+			return json.RawMessage(v), nil
+		}
+		b, err := json.Marshal(value)
+		return json.RawMessage(b), err
+	case schema.TYPE_BINARY, schema.TYPE_POINT:
+		return toBytes(value), nil
+	case schema.TYPE_STRING:
+		if isBinaryColumn(col) {
+			return toBytes(value), nil
+		}
+		return toString(value), nil
+	}
+	if b, ok := value.([]byte); ok {
+		return string(b), nil
+	}
+	return value, nil
+}
+
+func (d *Decoder) location() *time.Location {
+	if d.Location != nil {
+		return d.Location
+	}
+	return time.Local
+}
+
+func signedValue(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	}
+	return strconv.ParseInt(toString(value), 10, 64)
+}
+
+// binlog rows carry unsigned columns in the signed type of their width
+func unsignedValue(col *TableColumn, value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case int8:
+		return uint64(uint8(v)), nil
+	case int16:
+		return uint64(uint16(v)), nil
+	case int32:
+		if col.Type == schema.TYPE_MEDIUM_INT {
+			return uint64(uint32(v) & 0xffffff), nil
+		}
+		return uint64(uint32(v)), nil
+	case int64:
+		return uint64(v), nil
+	case int:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	}
+	return strconv.ParseUint(toString(value), 10, 64)
+}
+
+// binlog ENUM is the 1 based index
+func enumValue(col *TableColumn, value int64) string {
+	i := value - 1
+	if i < 0 || i >= int64(len(col.EnumValues)) {
+		return ""
+	}
+	return col.EnumValues[i]
+}
+
+// binlog SET is a bitmask
+func setValue(col *TableColumn, value int64) []string {
+	sets := make([]string, 0, len(col.SetValues))
+	for i, s := range col.SetValues {
+		if value&int64(1<<uint(i)) > 0 {
+			sets = append(sets, s)
+		}
+	}
+	return sets
+}
+
+// binlog BIT is int64, the text protocol sends the big endian bytes
+func bitValue(value interface{}) uint64 {
+	switch v := value.(type) {
+	case int64:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	var n uint64
+	for _, b := range toBytes(value) {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// zero dates are time.Time{}, fractional seconds are kept
+func parseTime(layout, s string, loc *time.Location) (time.Time, error) {
+	if s == "" || strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return []byte(fmt.Sprint(value))
+}
+
+var (
+	scannerType = reflect.TypeFor[sql.Scanner]()
+	timeType    = reflect.TypeFor[time.Time]()
+	ratType     = reflect.TypeFor[big.Rat]()
+	rawType     = reflect.TypeFor[json.RawMessage]()
+)
+
+// assign the normalized value v to the field f
+func assign(f reflect.Value, v interface{}) error {
+	if f.Addr().Type().Implements(scannerType) {
+		if dv, ok := v.(decimal); ok {
+			v = string(dv)
+		}
+		if sv, ok := v.([]string); ok {
+			v = strings.Join(sv, ",")
+		}
+		if rv, ok := v.(json.RawMessage); ok {
+			v = []byte(rv)
+		}
+		return f.Addr().Interface().(sql.Scanner).Scan(v)
+	}
+	if f.Kind() == reflect.Pointer {
+		if v == nil {
+			f.SetZero()
+			return nil
+		}
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		return assign(f.Elem(), v)
+	}
+	if v == nil {
+		f.SetZero()
+		return nil
+	}
+
+	switch f.Type() {
+	case timeType:
+		if t, ok := v.(time.Time); ok {
+			f.Set(reflect.ValueOf(t))
+			return nil
+		}
+	case ratType:
+		r, ok := new(big.Rat).SetString(toString(v))
+		if !ok {
+			return fmt.Errorf("invalid decimal %v", v)
+		}
+		f.Set(reflect.ValueOf(r).Elem())
+		return nil
+	case rawType:
+		f.SetBytes(toBytes(v))
+		return nil
+	}
+
+	if raw, ok := v.(json.RawMessage); ok {
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(string(raw))
+			return nil
+		case reflect.Slice:
+			if f.Type().Elem().Kind() == reflect.Uint8 {
+				f.SetBytes(raw)
+				return nil
+			}
+		}
+		return json.Unmarshal(raw, f.Addr().Interface())
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		switch vv := v.(type) {
+		case []string:
+			f.SetString(strings.Join(vv, ","))
+		case time.Time:
+			f.SetString(vv.Format(time.RFC3339Nano))
+		default:
+			f.SetString(toString(v))
+		}
+		return nil
+	case reflect.Bool:
+		switch vv := v.(type) {
+		case int64:
+			f.SetBool(vv != 0)
+		case uint64:
+			f.SetBool(vv != 0)
+		default:
+			b, err := strconv.ParseBool(toString(v))
+			if err != nil {
+				return err
+			}
+			f.SetBool(b)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch vv := v.(type) {
+		case int64:
+			n = vv
+		case uint64:
+			if vv > 1<<63-1 {
+				return fmt.Errorf("%d overflows %s", vv, f.Type())
+			}
+			n = int64(vv)
+		default:
+			var err error
+			if n, err = strconv.ParseInt(toString(v), 10, 64); err != nil {
+				return err
+			}
+		}
+		if f.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, f.Type())
+		}
+		f.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		switch vv := v.(type) {
+		case uint64:
+			n = vv
+		case int64:
+			if vv < 0 {
+				return fmt.Errorf("%d overflows %s", vv, f.Type())
+			}
+			n = uint64(vv)
+		default:
+			var err error
+			if n, err = strconv.ParseUint(toString(v), 10, 64); err != nil {
+				return err
+			}
+		}
+		if f.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %s", n, f.Type())
+		}
+		f.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		switch vv := v.(type) {
+		case float64:
+			n = vv
+		case int64:
+			n = float64(vv)
+		case uint64:
+			n = float64(vv)
+		default:
+			var err error
+			if n, err = strconv.ParseFloat(toString(v), 64); err != nil {
+				return err
+			}
+		}
+		f.SetFloat(n)
+		return nil
+	case reflect.Slice:
+		switch vv := v.(type) {
+		case []string:
+			if f.Type().Elem().Kind() == reflect.String {
+				f.Set(reflect.ValueOf(vv).Convert(f.Type()))
+				return nil
+			}
+		case []byte:
+			if f.Type().Elem().Kind() == reflect.Uint8 {
+				f.SetBytes(append([]byte(nil), vv...))
+				return nil
+			}
+		case string:
+			if f.Type().Elem().Kind() == reflect.Uint8 {
+				f.SetBytes([]byte(vv))
+				return nil
+			}
+		}
+	case reflect.Interface:
+		if dv, ok := v.(decimal); ok {
+			v = string(dv)
+		}
+		rv := reflect.ValueOf(v)
+		if rv.Type().AssignableTo(f.Type()) {
+			f.Set(rv)
+			return nil
+		}
+	}
+	return fmt.Errorf("can't assign %T to %s", v, f.Type())
+}
@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+func TestDecodeValue(t *testing.T) {
+	bit := TableColumn{Name: "c", Type: schema.TYPE_BIT, RawType: "bit(16)"}
+	tinyint := TableColumn{Name: "c", Type: schema.TYPE_NUMBER, RawType: "tinyint(3) unsigned", IsUnsigned: true}
+	smallint := TableColumn{Name: "c", Type: schema.TYPE_NUMBER, RawType: "smallint(5) unsigned", IsUnsigned: true}
+	mediumint := TableColumn{Name: "c", Type: schema.TYPE_MEDIUM_INT, RawType: "mediumint(8) unsigned", IsUnsigned: true}
+	integer := TableColumn{Name: "c", Type: schema.TYPE_NUMBER, RawType: "int(10) unsigned", IsUnsigned: true}
+	bigint := TableColumn{Name: "c", Type: schema.TYPE_NUMBER, RawType: "bigint(20) unsigned", IsUnsigned: true}
+	signed := TableColumn{Name: "c", Type: schema.TYPE_MEDIUM_INT, RawType: "mediumint(9)"}
+
+	tests := []struct {
+		name  string
+		col   TableColumn
+		value interface{}
+		want  interface{}
+	}{
+		{"bit binlog", bit, int64(258), uint64(258)},
+		{"bit text protocol", bit, []byte{0x01, 0x02}, uint64(258)},
+		{"bit text protocol string", bit, "\x80\x00", uint64(0x8000)},
+		{"bit empty", bit, []byte{}, uint64(0)},
+		{"tinyint unsigned binlog", tinyint, int8(-1), uint64(255)},
+		{"smallint unsigned binlog", smallint, int16(-1), uint64(65535)},
+		// the binlog sign extends mediumint into int32
+		{"mediumint unsigned binlog", mediumint, int32(-1), uint64(16777215)},
+		{"mediumint unsigned binlog high bit", mediumint, int32(-8388608), uint64(8388608)},
+		{"int unsigned binlog", integer, int32(-1), uint64(4294967295)},
+		{"bigint unsigned binlog", bigint, int64(-1), uint64(18446744073709551615)},
+		{"int unsigned text protocol", integer, []byte("4294967295"), uint64(4294967295)},
+		{"bigint unsigned text protocol", bigint, "18446744073709551615", uint64(18446744073709551615)},
+		{"mediumint signed binlog", signed, int32(-1), int64(-1)},
+	}
+	for _, tt := range tests {
+		got, err := defaultDecoder.Value(&tt.col, tt.value)
+		if err != nil {
+			t.Errorf("%s: Value = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Value = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeUnsigned(t *testing.T) {
+	table := &TableInfo{
+		Schema: "db",
+		Name:   "t",
+		Columns: []schema.TableColumn{
+			{Name: "flags", Type: schema.TYPE_BIT, RawType: "bit(8)"},
+			{Name: "small", Type: schema.TYPE_NUMBER, RawType: "tinyint(3) unsigned", IsUnsigned: true},
+			{Name: "medium", Type: schema.TYPE_MEDIUM_INT, RawType: "mediumint(8) unsigned", IsUnsigned: true},
+		},
+	}
+	var row struct {
+		Flags  uint8
+		Small  uint8
+		Medium uint32
+	}
+	if err := Decode(table, []interface{}{int64(0xff), int8(-56), int32(-1)}, &row); err != nil {
+		t.Fatal(err)
+	}
+	if row.Flags != 0xff || row.Small != 200 || row.Medium != 16777215 {
+		t.Errorf("Decode = %+v, want {Flags:255 Small:200 Medium:16777215}", row)
+	}
+}
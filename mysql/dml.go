@@ -1,10 +1,11 @@
 package mysql
 
 import (
-	"fmt"
 	"reflect"
 	"slices"
 	"strings"
+
+	"github.com/go-mysql-org/go-mysql/schema"
 )
 
 type DmlInterface interface {
@@ -13,6 +14,9 @@ type DmlInterface interface {
 	Delete(tableInfo *TableInfo, row []interface{}) (string, []interface{})
 }
 
+// DmlDefault statements of canal rows, names are quoted and generated columns are
+// never written, binary values stay []byte. Tables without a primary key are
+// matched on every stored column, NULL with IS NULL, and one row is changed
 type DmlDefault struct {
 	DmlInterface
 }
@@ -27,17 +31,16 @@ func (d *DmlDefault) Insert(tableInfo *TableInfo, row []interface{}) (string, []
 
 	for idx, v := range row {
 
-		if v == nil {
+		if v == nil || tableInfo.Columns[idx].IsVirtual || tableInfo.Columns[idx].IsStored {
 			continue
 		}
-		field[idx] = tableInfo.Columns[idx].Name
+		field[idx] = backQuote(tableInfo.Columns[idx].Name)
 		pos[idx] = "?"
-		vstr := ValueToString(&tableInfo.Columns[idx], v)
-		value[idx] = vstr
+		value[idx] = dmlValue(&tableInfo.Columns[idx], v)
 
 	}
 	value = DelNilI(value)
-	sql := "insert into " + db + "." + table + " (" + strings.Join(DelNilS(field), ",") + ") values (" + strings.Join(DelNilS(pos), ",") + ")"
+	sql := "insert into " + backQuote(db) + "." + backQuote(table) + " (" + strings.Join(DelNilS(field), ",") + ") values (" + strings.Join(DelNilS(pos), ",") + ")"
 
 	return sql, value
 
@@ -74,22 +77,27 @@ func updateAndDelete(action string, tableInfo *TableInfo, beforeRows, afterRows
 
 	for idx, field := range tableInfo.Columns {
 
-		if slices.Contains(tableInfo.PKColumns, idx) {
-			vstr := ValueToString(&field, beforeRows[idx])
-			pkvalue[idx] = vstr
-			pkpos[idx] = field.Name + " = ?"
+		name := backQuote(field.Name)
+		if isKeyColumn(tableInfo, idx) {
+			pkpos[idx] = name + " = ?"
+			pkvalue[idx] = dmlValue(&field, beforeRows[idx])
+			if beforeRows[idx] == nil {
+				pkpos[idx] = name + " IS NULL"
+			}
 		}
 		if action == "update" && reflect.DeepEqual(beforeRows[idx], afterRows[idx]) {
 			continue
 		}
-		pos[idx] = field.Name + " = ?"
-		vstr := fmt.Sprintf("%v", ValueToString(&field, afterRows[idx]))
+		if field.IsVirtual || field.IsStored {
+			continue
+		}
+		pos[idx] = name + " = ?"
 		if afterRows[idx] == nil {
-			pos[idx] = field.Name + " = NULL"
+			pos[idx] = name + " = NULL"
 			continue
 		}
 
-		value[idx] = vstr
+		value[idx] = dmlValue(&field, afterRows[idx])
 
 	}
 
@@ -97,13 +105,19 @@ func updateAndDelete(action string, tableInfo *TableInfo, beforeRows, afterRows
 	value = DelNilI(value)
 	value = append(value, pkvalue...)
 
+	// rows matched on every column may have duplicates, the binlog changes one of them
+	var limit string
+	if len(tableInfo.PKColumns) == 0 {
+		limit = " LIMIT 1"
+	}
+
 	if action == "update" {
-		sql := "update " + db + "." + table + " set " + strings.Join(DelNilS(pos), ",") + " where " + strings.Join(DelNilS(pkpos), " AND ")
+		sql := "update " + backQuote(db) + "." + backQuote(table) + " set " + strings.Join(DelNilS(pos), ",") + " where " + strings.Join(DelNilS(pkpos), " AND ") + limit
 		return sql, value
 	}
 
 	if action == "delete" {
-		sql := "DELETE FROM " + tableInfo.Schema + "." + tableInfo.Name + " WHERE " + strings.Join((DelNilS(pkpos)), " AND ")
+		sql := "DELETE FROM " + backQuote(db) + "." + backQuote(table) + " WHERE " + strings.Join((DelNilS(pkpos)), " AND ") + limit
 
 		return sql, pkvalue
 	}
@@ -112,6 +126,29 @@ func updateAndDelete(action string, tableInfo *TableInfo, beforeRows, afterRows
 
 }
 
+// tables without primary key are matched on every column
+func isKeyColumn(tableInfo *TableInfo, idx int) bool {
+	if len(tableInfo.PKColumns) == 0 {
+		return !tableInfo.Columns[idx].IsVirtual
+	}
+	return slices.Contains(tableInfo.PKColumns, idx)
+}
+
+// binary and blob values stay []byte, the rest goes through ValueToString
+func dmlValue(col *TableColumn, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if b, ok := value.([]byte); ok && isBinaryColumn(col) {
+		return b
+	}
+	return ValueToString(col, value)
+}
+
+func isBinaryColumn(col *TableColumn) bool {
+	return col.Type == schema.TYPE_BINARY || strings.HasSuffix(col.RawType, "blob")
+}
+
 func DelNilI(s []interface{}) []interface{} {
 	var n []interface{}
 	for _, s_ := range s {
@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+func TestDmlDefault(t *testing.T) {
+	withPK := &TableInfo{Schema: "db", Name: "t", PKColumns: []int{0}, Columns: []schema.TableColumn{
+		{Name: "id", Type: schema.TYPE_NUMBER},
+		{Name: "data", Type: schema.TYPE_BINARY, RawType: "varbinary(16)"},
+		{Name: "total", Type: schema.TYPE_NUMBER, IsVirtual: true},
+		{Name: "n", Type: schema.TYPE_NUMBER, IsStored: true},
+	}}
+	noPK := &TableInfo{Schema: "db", Name: "log", Columns: []schema.TableColumn{
+		{Name: "a", Type: schema.TYPE_NUMBER},
+		{Name: "b", Type: schema.TYPE_STRING},
+		{Name: "v", Type: schema.TYPE_NUMBER, IsVirtual: true},
+	}}
+	d := &DmlDefault{}
+
+	tests := []struct {
+		name  string
+		build func() (string, []interface{})
+		want  string
+		// want args
+		wargs []interface{}
+	}{
+		{
+			name: "insert skips generated columns and keeps binary values",
+			build: func() (string, []interface{}) {
+				return d.Insert(withPK, []interface{}{int64(1), []byte{0, 0xff}, int64(3), int64(4)})
+			},
+			want:  "insert into `db`.`t` (`id`,`data`) values (?,?)",
+			wargs: []interface{}{"1", []byte{0, 0xff}},
+		},
+		{
+			name: "update by primary key",
+			build: func() (string, []interface{}) {
+				return d.Update(withPK, []interface{}{int64(1), []byte{0}, int64(3), int64(4)}, []interface{}{int64(1), []byte{1}, int64(4), int64(5)})
+			},
+			want:  "update `db`.`t` set `data` = ? where `id` = ?",
+			wargs: []interface{}{[]byte{1}, "1"},
+		},
+		{
+			name: "update without primary key matches every column, NULL with IS NULL",
+			build: func() (string, []interface{}) {
+				return d.Update(noPK, []interface{}{int64(1), nil, int64(2)}, []interface{}{int64(2), nil, int64(3)})
+			},
+			want:  "update `db`.`log` set `a` = ? where `a` = ? AND `b` IS NULL LIMIT 1",
+			wargs: []interface{}{"2", "1"},
+		},
+		{
+			name: "delete without primary key",
+			build: func() (string, []interface{}) {
+				return d.Delete(noPK, []interface{}{int64(1), "x", int64(2)})
+			},
+			want:  "DELETE FROM `db`.`log` WHERE `a` = ? AND `b` = ? LIMIT 1",
+			wargs: []interface{}{"1", "x"},
+		},
+	}
+	for _, tt := range tests {
+		sql, args := tt.build()
+		if sql != tt.want {
+			t.Errorf("%s: sql = %s, want %s", tt.name, sql, tt.want)
+		}
+		if !reflect.DeepEqual(args, tt.wargs) {
+			t.Errorf("%s: args = %#v, want %#v", tt.name, args, tt.wargs)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+type DmlMode int
+
+const (
+	// INSERT INTO
+	DmlInsert DmlMode = iota
+	// REPLACE INTO
+	DmlReplace
+	// INSERT INTO ... ON DUPLICATE KEY UPDATE
+	DmlUpsert
+)
+
+type Statement struct {
+	Sql  string
+	Args []interface{}
+}
+
+// DmlTx the statements of one source transaction, BEGIN first and COMMIT last.
+// Id identifies the source transaction, like the binlog position of its commit,
+// LogPos alone repeats across binlog files
+type DmlTx struct {
+	Id         string
+	Statements []Statement
+}
+
+// DmlBatch collects the rows of one source transaction, consecutive inserts
+// into the same table are merged into multi-row statements
+//
+//	b := dml.Batch(mysql.DmlUpsert)
+//	h.SetOnRow(func(e *canal.RowsEvent) error { b.Add(e.Table, e.Action, e.Rows); return nil })
+//	h.SetOnXID(func(header *canal.EventHeader, nextPos canal.Position) error {
+//		return target.ExecTx(ctx, b.Commit(nextPos.String()), "sync.applied_tx")
+//	})
+type DmlBatch struct {
+	dml  *DmlDefault
+	mode DmlMode
+	// rows per multi-row statement, default 1000, at most 65535 placeholders
+	MaxRows int
+
+	stmts []Statement
+	table *TableInfo
+	rows  [][]interface{}
+}
+
+// Batch mode default DmlInsert
+func (d *DmlDefault) Batch(mode ...DmlMode) *DmlBatch {
+	b := &DmlBatch{dml: d, MaxRows: 1000}
+	if len(mode) > 0 {
+		b.mode = mode[0]
+	}
+	return b
+}
+
+// Add canal.RowsEvent rows, action is insert, update or delete
+func (b *DmlBatch) Add(tableInfo *TableInfo, action string, rows [][]interface{}) error {
+	switch action {
+	case "insert":
+		b.Insert(tableInfo, rows...)
+	case "update":
+		for i := 0; i+1 < len(rows); i += 2 {
+			b.Update(tableInfo, rows[i], rows[i+1])
+		}
+	case "delete":
+		for _, row := range rows {
+			b.Delete(tableInfo, row)
+		}
+	default:
+		return fmt.Errorf("unknown action %s", action)
+	}
+	return nil
+}
+
+func (b *DmlBatch) Insert(tableInfo *TableInfo, rows ...[]interface{}) {
+	if b.table != tableInfo {
+		b.flush()
+		b.table = tableInfo
+	}
+	for _, row := range rows {
+		b.rows = append(b.rows, row)
+		if len(b.rows) >= b.maxRows() {
+			b.flush()
+			b.table = tableInfo
+		}
+	}
+}
+
+// Update in DmlReplace and DmlUpsert mode is written as an insert of the after row,
+// unless the primary key changed
+func (b *DmlBatch) Update(tableInfo *TableInfo, beforeRows, afterRows []interface{}) {
+	if b.mode != DmlInsert && len(tableInfo.PKColumns) > 0 && !keyChanged(tableInfo, beforeRows, afterRows) {
+		b.Insert(tableInfo, afterRows)
+		return
+	}
+	b.flush()
+	sql, args := b.dml.Update(tableInfo, beforeRows, afterRows)
+	b.stmts = append(b.stmts, Statement{sql, args})
+}
+
+func (b *DmlBatch) Delete(tableInfo *TableInfo, row []interface{}) {
+	b.flush()
+	sql, args := b.dml.Delete(tableInfo, row)
+	b.stmts = append(b.stmts, Statement{sql, args})
+}
+
+// Commit ends the transaction id and resets the batch, empty transactions have no statements
+func (b *DmlBatch) Commit(id string) *DmlTx {
+	b.flush()
+	tx := &DmlTx{Id: id}
+	if len(b.stmts) > 0 {
+		tx.Statements = make([]Statement, 0, len(b.stmts)+2)
+		tx.Statements = append(tx.Statements, Statement{Sql: "BEGIN"})
+		tx.Statements = append(tx.Statements, b.stmts...)
+		tx.Statements = append(tx.Statements, Statement{Sql: "COMMIT"})
+	}
+	b.stmts = nil
+	return tx
+}
+
+// Rollback drops the pending statements
+func (b *DmlBatch) Rollback() {
+	b.stmts, b.table, b.rows = nil, nil, nil
+}
+
+func (b *DmlBatch) maxRows() int {
+	n := b.MaxRows
+	if n <= 0 {
+		n = 1000
+	}
+	if cols := len(b.table.Columns); cols > 0 {
+		n = min(n, 65535/cols)
+	}
+	return max(n, 1)
+}
+
+func (b *DmlBatch) flush() {
+	if len(b.rows) == 0 {
+		b.table = nil
+		return
+	}
+	table := b.table
+
+	var names, update []string
+	var idx []int
+	for i, col := range table.Columns {
+		if col.IsVirtual || col.IsStored {
+			continue
+		}
+		name := backQuote(col.Name)
+		names = append(names, name)
+		idx = append(idx, i)
+		update = append(update, name+" = VALUES("+name+")")
+	}
+	pos := "(" + strings.TrimSuffix(strings.Repeat("?,", len(idx)), ",") + ")"
+
+	values := make([]string, len(b.rows))
+	args := make([]interface{}, 0, len(b.rows)*len(idx))
+	for r, row := range b.rows {
+		values[r] = pos
+		for _, i := range idx {
+			args = append(args, dmlValue(&table.Columns[i], row[i]))
+		}
+	}
+
+	verb := "INSERT INTO "
+	if b.mode == DmlReplace {
+		verb = "REPLACE INTO "
+	}
+	sql := verb + backQuote(table.Schema) + "." + backQuote(table.Name) + " (" + strings.Join(names, ",") + ") VALUES " + strings.Join(values, ",")
+	if b.mode == DmlUpsert {
+		sql += " ON DUPLICATE KEY UPDATE " + strings.Join(update, ",")
+	}
+	b.stmts = append(b.stmts, Statement{sql, args})
+	b.table, b.rows = nil, nil
+}
+
+func keyChanged(tableInfo *TableInfo, beforeRows, afterRows []interface{}) bool {
+	for _, i := range tableInfo.PKColumns {
+		if ValueToString(&tableInfo.Columns[i], beforeRows[i]) != ValueToString(&tableInfo.Columns[i], afterRows[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecTx runs the statements of tx on one connection, a failed statement rolls back.
+// With applied, a table made by CreateAppliedTable, the id of tx is recorded in the
+// same transaction and a tx already applied is skipped, so a tx whose COMMIT was
+// lost with the connection can be sent again
+func (c *Conn) ExecTx(ctx context.Context, tx *DmlTx, applied ...string) error {
+	if len(tx.Statements) == 0 {
+		return nil
+	}
+	statements := tx.Statements
+	if len(applied) == 1 && tx.Id != "" {
+		record := Statement{"INSERT INTO " + quoteTable(applied[0]) + " (id) VALUES (?)", []interface{}{tx.Id}}
+		statements = append([]Statement{statements[0], record}, statements[1:]...)
+	}
+	err := c.run(ctx, false, func(conn *client.Conn) error {
+		for i, st := range statements {
+			if _, err := conn.Execute(st.Sql, st.Args...); err != nil {
+				conn.Execute("ROLLBACK")
+				var myErr *mysql.MyError
+				if i == 1 && len(statements) > len(tx.Statements) && errors.As(err, &myErr) && myErr.Code == mysql.ER_DUP_ENTRY {
+					// already applied
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("tx %s: %w", tx.Id, err)
+	}
+	return nil
+}
+
+// CreateAppliedTable the table of the ids of the transactions applied by ExecTx, db.table
+func (c *Conn) CreateAppliedTable(ctx context.Context, table string) error {
+	_, err := c.ExecuteContext(ctx, "CREATE TABLE IF NOT EXISTS "+quoteTable(table)+" (id VARCHAR(255) NOT NULL PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	return err
+}
+
+// quoteTable db.table as `db`.`table`
+func quoteTable(table string) string {
+	db, name, ok := strings.Cut(table, ".")
+	if !ok {
+		return backQuote(table)
+	}
+	return backQuote(db) + "." + backQuote(name)
+}
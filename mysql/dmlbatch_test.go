@@ -0,0 +1,98 @@
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+func TestDmlBatch(t *testing.T) {
+	users := &TableInfo{Schema: "db", Name: "users", PKColumns: []int{0}, Columns: []schema.TableColumn{
+		{Name: "id", Type: schema.TYPE_NUMBER},
+		{Name: "name", Type: schema.TYPE_STRING},
+	}}
+
+	tests := []struct {
+		name  string
+		mode  DmlMode
+		build func(b *DmlBatch)
+		// statements between BEGIN and COMMIT
+		want []string
+	}{
+		{
+			name: "inserts merged",
+			mode: DmlInsert,
+			build: func(b *DmlBatch) {
+				b.Add(users, "insert", [][]interface{}{{int64(1), "a"}, {int64(2), "b"}})
+				b.Add(users, "insert", [][]interface{}{{int64(3), "c"}})
+			},
+			want: []string{"INSERT INTO `db`.`users` (`id`,`name`) VALUES (?,?),(?,?),(?,?)"},
+		},
+		{
+			name: "upsert update",
+			mode: DmlUpsert,
+			build: func(b *DmlBatch) {
+				b.Add(users, "update", [][]interface{}{{int64(1), "a"}, {int64(1), "b"}})
+			},
+			want: []string{"INSERT INTO `db`.`users` (`id`,`name`) VALUES (?,?) ON DUPLICATE KEY UPDATE `id` = VALUES(`id`),`name` = VALUES(`name`)"},
+		},
+		{
+			name: "replace key changed",
+			mode: DmlReplace,
+			build: func(b *DmlBatch) {
+				b.Add(users, "update", [][]interface{}{{int64(1), "a"}, {int64(2), "a"}})
+			},
+			want: []string{"UPDATE"},
+		},
+		{
+			name: "delete flushes inserts",
+			mode: DmlReplace,
+			build: func(b *DmlBatch) {
+				b.Add(users, "insert", [][]interface{}{{int64(1), "a"}})
+				b.Add(users, "delete", [][]interface{}{{int64(1), "a"}})
+			},
+			want: []string{"REPLACE INTO `db`.`users` (`id`,`name`) VALUES (?,?)", "DELETE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := (&DmlDefault{}).Batch(tt.mode)
+			tt.build(b)
+			tx := b.Commit("binlog.000001:120")
+			if tx.Id != "binlog.000001:120" {
+				t.Errorf("tx id = %s, want binlog.000001:120", tx.Id)
+			}
+			var got []string
+			for _, st := range tx.Statements {
+				got = append(got, st.Sql)
+			}
+			if len(got) != len(tt.want)+2 || got[0] != "BEGIN" || got[len(got)-1] != "COMMIT" {
+				t.Fatalf("statements = %q, want BEGIN %q COMMIT", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(strings.ToUpper(got[i+1]), strings.ToUpper(want)) {
+					t.Errorf("statement %d = %s, want %s", i, got[i+1], want)
+				}
+			}
+			if tx := b.Commit("next"); len(tx.Statements) != 0 {
+				t.Errorf("batch not reset after Commit: %q", tx.Statements)
+			}
+		})
+	}
+}
+
+func TestQuoteTable(t *testing.T) {
+	tests := []struct {
+		table, want string
+	}{
+		{"sync.applied_tx", "`sync`.`applied_tx`"},
+		{"applied_tx", "`applied_tx`"},
+	}
+	for _, tt := range tests {
+		if got := quoteTable(tt.table); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("quoteTable(%q) = %s, want %s", tt.table, got, tt.want)
+		}
+	}
+}
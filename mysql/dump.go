@@ -0,0 +1,412 @@
+package mysql
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+type DumpFormat string
+
+const (
+	DumpSQL DumpFormat = "sql"
+	DumpCSV DumpFormat = "csv"
+)
+
+const dumpMetadata = "metadata"
+
+// dumpTimeZone session time zone of the dump workers, TIMESTAMP values are
+// written in it and the loader reads them back in the same zone
+const dumpTimeZone = "+00:00"
+
+// DumpMetadata the metadata file, Gtid is the position the dump is consistent with
+type DumpMetadata struct {
+	Started  time.Time  `json:"started"`
+	Finished time.Time  `json:"finished"`
+	Gtid     string     `json:"gtid"`
+	Format   DumpFormat `json:"format"`
+	Compress bool       `json:"compress"`
+	// session time_zone of the TIMESTAMP values, empty in dumps of the server time zone
+	TimeZone string      `json:"time_zone"`
+	Tables   []DumpTable `json:"tables"`
+}
+
+type DumpTable struct {
+	Schema string   `json:"schema"`
+	Table  string   `json:"table"`
+	Rows   int64    `json:"rows"`
+	Files  []string `json:"files"`
+}
+
+// Dumper writes a mydumper style directory
+//
+//	metadata                    json DumpMetadata
+//	db-schema-create.sql
+//	db.table-schema.sql
+//	db.table.00000.sql[.gz]     INSERT batches, or .csv[.gz] with a header line, \N for NULL
+//	                            and \\ for a backslash
+//
+// every worker reads in its own consistent snapshot, connections are opened with
+// the Source config so NewClientViaSSH works the same way
+type Dumper struct {
+	Source *Conn
+	Dir    string
+	// db.table filter, canal.FilterTable().Include(...).Match, nil dumps every table
+	// except the system databases
+	Match  func(table string) bool
+	Format DumpFormat
+	// parallel tables, default 4
+	Threads int
+	// rows per data file, default 100000
+	ChunkRows int
+	// bytes per INSERT statement, default 1MB
+	StatementSize int
+	// gzip data files
+	Compress bool
+}
+
+func (d *Dumper) Dump(ctx context.Context) (*DumpMetadata, error) {
+	if d.Format == "" {
+		d.Format = DumpSQL
+	}
+	if d.Threads <= 0 {
+		d.Threads = 4
+	}
+	if d.ChunkRows <= 0 {
+		d.ChunkRows = 100000
+	}
+	if d.StatementSize <= 0 {
+		d.StatementSize = 1 << 20
+	}
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return nil, err
+	}
+	meta := &DumpMetadata{Started: time.Now(), Format: d.Format, Compress: d.Compress, TimeZone: dumpTimeZone}
+
+	lock := newSessionClient(d.Source)
+	defer lock.Close()
+	tables, err := d.tables(ctx, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	// workers start their snapshot while the global read lock is held
+	if _, err := lock.ExecuteContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+		return nil, err
+	}
+	workers := make([]*Conn, d.Threads)
+	defer func() {
+		for _, w := range workers {
+			if w != nil {
+				w.Close()
+			}
+		}
+	}()
+	for i := range workers {
+		workers[i] = newSessionClient(d.Source, "SET time_zone = '"+dumpTimeZone+"'")
+		for _, sql := range []string{
+			"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+			"START TRANSACTION /*!40100 WITH CONSISTENT SNAPSHOT */",
+		} {
+			if _, err := workers[i].ExecuteContext(ctx, sql); err != nil {
+				return nil, err
+			}
+		}
+	}
+	r, err := lock.ExecuteContext(ctx, "SELECT @@GLOBAL.GTID_EXECUTED")
+	if err != nil {
+		return nil, err
+	}
+	meta.Gtid, _ = r.GetString(0, 0)
+	if err := d.dumpSchema(ctx, lock, tables); err != nil {
+		return nil, err
+	}
+	if _, err := lock.ExecuteContext(ctx, "UNLOCK TABLES"); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan *DumpTable)
+	errs := make([]error, len(workers))
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				if err := d.dumpTable(ctx, w, t); err != nil {
+					errs[i] = fmt.Errorf("dump %s.%s: %w", t.Schema, t.Table, err)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+send:
+	for i := range tables {
+		select {
+		case queue <- &tables[i]:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	meta.Tables = tables
+	meta.Finished = time.Now()
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return meta, os.WriteFile(filepath.Join(d.Dir, dumpMetadata), b, 0644)
+}
+
+func (d *Dumper) tables(ctx context.Context, c *Conn) ([]DumpTable, error) {
+	r, err := c.ExecuteContext(ctx, "SELECT TABLE_SCHEMA, TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' "+
+		"AND TABLE_SCHEMA NOT IN ('mysql', 'sys', 'information_schema', 'performance_schema') ORDER BY TABLE_SCHEMA, TABLE_NAME")
+	if err != nil {
+		return nil, err
+	}
+	var tables []DumpTable
+	for i := range r.Values {
+		db, _ := r.GetString(i, 0)
+		table, _ := r.GetString(i, 1)
+		if d.Match != nil && !d.Match(db+"."+table) {
+			continue
+		}
+		tables = append(tables, DumpTable{Schema: db, Table: table})
+	}
+	return tables, nil
+}
+
+func (d *Dumper) dumpSchema(ctx context.Context, c *Conn, tables []DumpTable) error {
+	dbs := map[string]bool{}
+	for _, t := range tables {
+		if !dbs[t.Schema] {
+			dbs[t.Schema] = true
+			r, err := c.ExecuteContext(ctx, "SHOW CREATE DATABASE "+backQuote(t.Schema))
+			if err != nil {
+				return err
+			}
+			sql, _ := r.GetString(0, 1)
+			if err := os.WriteFile(filepath.Join(d.Dir, t.Schema+"-schema-create.sql"), []byte(sql+";\n"), 0644); err != nil {
+				return err
+			}
+		}
+		r, err := c.ExecuteContext(ctx, "SHOW CREATE TABLE "+backQuote(t.Schema)+"."+backQuote(t.Table))
+		if err != nil {
+			return err
+		}
+		sql, _ := r.GetString(0, 1)
+		if err := os.WriteFile(filepath.Join(d.Dir, t.Schema+"."+t.Table+"-schema.sql"), []byte(sql+";\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dumper) dumpTable(ctx context.Context, c *Conn, t *DumpTable) error {
+	info, err := c.GetTableInfo(t.Schema, t.Table)
+	if err != nil {
+		return err
+	}
+	// generated columns can't be inserted
+	var columns []string
+	for _, col := range info.Columns {
+		if col.IsVirtual || col.IsStored {
+			continue
+		}
+		columns = append(columns, backQuote(col.Name))
+	}
+	cols := strings.Join(columns, ", ")
+
+	w := &chunkWriter{d: d, table: t, insert: "INSERT INTO " + backQuote(t.Schema) + "." + backQuote(t.Table) + " (" + cols + ") VALUES\n"}
+	for _, col := range info.Columns {
+		if !col.IsVirtual && !col.IsStored {
+			w.header = append(w.header, col.Name)
+		}
+	}
+	err = c.ExecuteSelectStreamingContext(ctx, "SELECT "+cols+" FROM "+backQuote(t.Schema)+"."+backQuote(t.Table), w.write, nil)
+	return errors.Join(err, w.close())
+}
+
+type chunkWriter struct {
+	d      *Dumper
+	table  *DumpTable
+	insert string
+	header []string
+
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+	csv  *csv.Writer
+	rows int
+	// bytes of the open INSERT, 0 when none
+	stmt int
+}
+
+func (w *chunkWriter) open() error {
+	name := fmt.Sprintf("%s.%s.%05d.%s", w.table.Schema, w.table.Table, len(w.table.Files), w.d.Format)
+	if w.d.Compress {
+		name += ".gz"
+	}
+	f, err := os.Create(filepath.Join(w.d.Dir, name))
+	if err != nil {
+		return err
+	}
+	w.file = f
+	var out io.Writer = f
+	if w.d.Compress {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.buf = bufio.NewWriter(out)
+	w.table.Files = append(w.table.Files, name)
+	w.rows = 0
+	if w.d.Format == DumpCSV {
+		w.csv = csv.NewWriter(w.buf)
+		return w.csv.Write(w.header)
+	}
+	return nil
+}
+
+func (w *chunkWriter) write(row []mysql.FieldValue) error {
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.d.Format == DumpCSV {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = csvValue(v)
+		}
+		if err := w.csv.Write(record); err != nil {
+			return err
+		}
+	} else {
+		var b strings.Builder
+		if w.stmt == 0 {
+			b.WriteString(w.insert)
+		} else {
+			b.WriteString(",\n")
+		}
+		b.WriteString("(")
+		for i, v := range row {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(sqlValue(v))
+		}
+		b.WriteString(")")
+		w.stmt += b.Len()
+		if _, err := w.buf.WriteString(b.String()); err != nil {
+			return err
+		}
+		if w.stmt >= w.d.StatementSize {
+			if err := w.endStatement(); err != nil {
+				return err
+			}
+		}
+	}
+	w.rows++
+	w.table.Rows++
+	if w.rows >= w.d.ChunkRows {
+		return w.close()
+	}
+	return nil
+}
+
+func (w *chunkWriter) endStatement() error {
+	w.stmt = 0
+	_, err := w.buf.WriteString(";\n")
+	return err
+}
+
+func (w *chunkWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	var errs []error
+	if w.stmt > 0 {
+		errs = append(errs, w.endStatement())
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		errs = append(errs, w.csv.Error())
+	}
+	errs = append(errs, w.buf.Flush())
+	if w.gz != nil {
+		errs = append(errs, w.gz.Close())
+	}
+	errs = append(errs, w.file.Close())
+	w.file, w.gz, w.buf, w.csv = nil, nil, nil, nil
+	return errors.Join(errs...)
+}
+
+func sqlValue(v mysql.FieldValue) string {
+	switch v.Type {
+	case mysql.FieldValueTypeNull:
+		return "NULL"
+	case mysql.FieldValueTypeUnsigned:
+		return strconv.FormatUint(v.AsUint64(), 10)
+	case mysql.FieldValueTypeSigned:
+		return strconv.FormatInt(v.AsInt64(), 10)
+	case mysql.FieldValueTypeFloat:
+		return strconv.FormatFloat(v.AsFloat64(), 'g', -1, 64)
+	}
+	return quoteLiteral(v.AsString())
+}
+
+// csvValue backslashes are doubled so a string \N is not read as NULL
+func csvValue(v mysql.FieldValue) string {
+	if v.Type == mysql.FieldValueTypeNull {
+		return `\N`
+	}
+	if v.Type == mysql.FieldValueTypeString {
+		return strings.ReplaceAll(string(v.AsString()), `\`, `\\`)
+	}
+	return fmt.Sprint(v.Value())
+}
+
+// quoteLiteral binary values that are not valid utf8 are written as hex
+func quoteLiteral(b []byte) string {
+	if !utf8.Valid(b) {
+		return "0x" + hex.EncodeToString(b)
+	}
+	return "'" + mysql.Escape(string(b)) + "'"
+}
+
+// newSessionClient a non pooled client with the config of c, for statements that
+// need one session like locks and transactions, init is run after the
+// InitStatements of c on every connection
+func newSessionClient(c *Conn, init ...string) *Conn {
+	cfg := *c.GetConfig()
+	cfg.Pool = false
+	cfg.InitStatements = append(slices.Clip(cfg.InitStatements), init...)
+	return NewClient(&cfg)
+}
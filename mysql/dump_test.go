@@ -0,0 +1,57 @@
+package mysql
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestDumpCSVRoundTrip(t *testing.T) {
+	d := &Dumper{Dir: t.TempDir(), Format: DumpCSV, ChunkRows: 10}
+	table := &DumpTable{Schema: "db", Table: "t"}
+	w := &chunkWriter{d: d, table: table, header: []string{"s"}}
+
+	str := func(s string) mysql.FieldValue {
+		return mysql.NewFieldValue(mysql.FieldValueTypeString, 0, []byte(s))
+	}
+	rows := []struct {
+		value mysql.FieldValue
+		want  string
+	}{
+		{mysql.NewFieldValue(mysql.FieldValueTypeNull, 0, nil), "NULL"},
+		{str(`\N`), `'\\N'`},
+		{str(`a\b`), `'a\\b'`},
+		{str(`\\N`), `'\\\\N'`},
+		{str("a,\"b\"\nc"), `'a,\"b\"\nc'`},
+		{mysql.NewFieldValue(mysql.FieldValueTypeSigned, uint64(1<<64-3), nil), "'-3'"},
+	}
+	for _, row := range rows {
+		if err := w.write([]mysql.FieldValue{row.value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(d.Dir, table.Files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(rows)+1 || records[0][0] != "s" {
+		t.Fatalf("csv = %q, want a header and %d rows", records, len(rows))
+	}
+	for i, row := range rows {
+		if got := csvLiteral(records[i+1][0]); got != row.want {
+			t.Errorf("row %d: csvLiteral(%q) = %s, want %s", i, records[i+1][0], got, row.want)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type MasterStatus struct {
+	File            string
+	Position        uint64
+	BinlogDoDB      string
+	BinlogIgnoreDB  string
+	ExecutedGtidSet string
+}
+
+type ReplicaStatus struct {
+	Channel             string
+	SourceHost          string
+	SourcePort          int
+	SourceUser          string
+	SourceServerId      uint32
+	SourceUUID          string
+	SourceLogFile       string
+	ReadSourceLogPos    uint64
+	RelaySourceLogFile  string
+	ExecSourceLogPos    uint64
+	IORunning           string
+	SQLRunning          string
+	SecondsBehindSource int64 // -1 when NULL, replication is not running
+	LastIOErrno         int
+	LastIOError         string
+	LastSQLErrno        int
+	LastSQLError        string
+	RetrievedGtidSet    string
+	ExecutedGtidSet     string
+	AutoPosition        bool
+}
+
+// Running both replication threads are running
+func (s ReplicaStatus) Running() bool {
+	return s.IORunning == "Yes" && s.SQLRunning == "Yes"
+}
+
+type BinaryLog struct {
+	Name      string
+	Size      uint64
+	Encrypted bool
+}
+
+// BinlogSettings the server settings canal depends on
+type BinlogSettings struct {
+	Version                string
+	ServerId               uint32
+	ServerUUID             string
+	LogBin                 bool
+	BinlogFormat           string
+	BinlogRowImage         string
+	GtidMode               string
+	EnforceGtidConsistency string
+	ReadOnly               bool
+}
+
+// ReplicaHost a replica connected to this server
+type ReplicaHost struct {
+	ServerId    uint32
+	Host        string
+	Port        int
+	SourceId    uint32
+	ReplicaUUID string
+}
+
+func (h ReplicaHost) Addr() string {
+	return net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+}
+
+// TopologyNode a server and the replicas found below it
+type TopologyNode struct {
+	Addr     string
+	Settings *BinlogSettings
+	// replication channels of this server, empty on the top source
+	Replication []ReplicaStatus
+	Replicas    []*TopologyNode
+	// the server could not be inspected, the other fields may be empty
+	Error string
+}
+
+// MasterStatus SHOW BINARY LOG STATUS, SHOW MASTER STATUS before 8.2
+func (c *Conn) MasterStatus(ctx context.Context) (*MasterStatus, error) {
+	r, err := c.ExecuteContext(ctx, "SHOW BINARY LOG STATUS")
+	if err != nil {
+		r, err = c.ExecuteContext(ctx, "SHOW MASTER STATUS")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Values) == 0 {
+		return nil, errors.New("binary log is disabled")
+	}
+	st := &MasterStatus{}
+	st.File, _ = r.GetStringByName(0, "File")
+	st.Position, _ = r.GetUintByName(0, "Position")
+	st.BinlogDoDB, _ = r.GetStringByName(0, "Binlog_Do_DB")
+	st.BinlogIgnoreDB, _ = r.GetStringByName(0, "Binlog_Ignore_DB")
+	st.ExecutedGtidSet, _ = r.GetStringByName(0, "Executed_Gtid_Set")
+	return st, nil
+}
+
+// ReplicaStatus SHOW REPLICA STATUS, one entry per channel, empty when not a replica
+func (c *Conn) ReplicaStatus(ctx context.Context) ([]ReplicaStatus, error) {
+	r, err := c.ExecuteContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		r, err = c.ExecuteContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// SHOW SLAVE STATUS names the columns Master/Slave
+	str := func(i int, name string) string {
+		for _, n := range []string{name, strings.NewReplacer("Source", "Master", "Replica", "Slave").Replace(name)} {
+			if s, err := r.GetStringByName(i, n); err == nil {
+				return s
+			}
+		}
+		return ""
+	}
+	num := func(i int, name string) uint64 {
+		n, _ := strconv.ParseUint(str(i, name), 10, 64)
+		return n
+	}
+
+	list := make([]ReplicaStatus, len(r.Values))
+	for i := range r.Values {
+		lag := int64(-1)
+		if s := str(i, "Seconds_Behind_Source"); s != "" {
+			lag, _ = strconv.ParseInt(s, 10, 64)
+		}
+		list[i] = ReplicaStatus{
+			Channel:             str(i, "Channel_Name"),
+			SourceHost:          str(i, "Source_Host"),
+			SourcePort:          int(num(i, "Source_Port")),
+			SourceUser:          str(i, "Source_User"),
+			SourceServerId:      uint32(num(i, "Source_Server_Id")),
+			SourceUUID:          str(i, "Source_UUID"),
+			SourceLogFile:       str(i, "Source_Log_File"),
+			ReadSourceLogPos:    num(i, "Read_Source_Log_Pos"),
+			RelaySourceLogFile:  str(i, "Relay_Source_Log_File"),
+			ExecSourceLogPos:    num(i, "Exec_Source_Log_Pos"),
+			IORunning:           str(i, "Replica_IO_Running"),
+			SQLRunning:          str(i, "Replica_SQL_Running"),
+			SecondsBehindSource: lag,
+			LastIOErrno:         int(num(i, "Last_IO_Errno")),
+			LastIOError:         str(i, "Last_IO_Error"),
+			LastSQLErrno:        int(num(i, "Last_SQL_Errno")),
+			LastSQLError:        str(i, "Last_SQL_Error"),
+			RetrievedGtidSet:    str(i, "Retrieved_Gtid_Set"),
+			ExecutedGtidSet:     str(i, "Executed_Gtid_Set"),
+			AutoPosition:        str(i, "Auto_Position") == "1",
+		}
+	}
+	return list, nil
+}
+
+// BinaryLogs SHOW BINARY LOGS
+func (c *Conn) BinaryLogs(ctx context.Context) ([]BinaryLog, error) {
+	r, err := c.ExecuteContext(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return nil, err
+	}
+	list := make([]BinaryLog, len(r.Values))
+	for i := range r.Values {
+		list[i].Name, _ = r.GetStringByName(i, "Log_name")
+		list[i].Size, _ = r.GetUintByName(i, "File_size")
+		if s, err := r.GetStringByName(i, "Encrypted"); err == nil {
+			list[i].Encrypted = s == "Yes"
+		}
+	}
+	return list, nil
+}
+
+func (c *Conn) BinlogSettings(ctx context.Context) (*BinlogSettings, error) {
+	r, err := c.ExecuteContext(ctx, "SELECT @@version, @@server_id, @@server_uuid, @@log_bin, @@binlog_format, "+
+		"@@binlog_row_image, @@gtid_mode, @@enforce_gtid_consistency, @@read_only")
+	if err != nil {
+		return nil, err
+	}
+	s := &BinlogSettings{}
+	s.Version, _ = r.GetString(0, 0)
+	id, _ := r.GetUint(0, 1)
+	s.ServerId = uint32(id)
+	s.ServerUUID, _ = r.GetString(0, 2)
+	logBin, _ := r.GetInt(0, 3)
+	s.LogBin = logBin == 1
+	s.BinlogFormat, _ = r.GetString(0, 4)
+	s.BinlogRowImage, _ = r.GetString(0, 5)
+	s.GtidMode, _ = r.GetString(0, 6)
+	s.EnforceGtidConsistency, _ = r.GetString(0, 7)
+	readOnly, _ := r.GetInt(0, 8)
+	s.ReadOnly = readOnly == 1
+	return s, nil
+}
+
+// Replicas SHOW REPLICAS, a replica without report_host is looked up among the
+// hosts of the binlog dump connections: the host whose @@server_id at the port
+// of the replica is its Server_Id. Replicas not found keep an empty Host
+func (c *Conn) Replicas(ctx context.Context) ([]ReplicaHost, error) {
+	r, err := c.ExecuteContext(ctx, "SHOW REPLICAS")
+	if err != nil {
+		r, err = c.ExecuteContext(ctx, "SHOW SLAVE HOSTS")
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := func(i int, names ...string) string {
+		for _, n := range names {
+			if s, err := r.GetStringByName(i, n); err == nil {
+				return s
+			}
+		}
+		return ""
+	}
+	var list []ReplicaHost
+	var missing []int
+	for i := range r.Values {
+		id, _ := strconv.ParseUint(name(i, "Server_Id", "Server_id"), 10, 32)
+		port, _ := strconv.Atoi(name(i, "Port"))
+		source, _ := strconv.ParseUint(name(i, "Source_Id", "Master_id"), 10, 32)
+		h := ReplicaHost{ServerId: uint32(id), Host: name(i, "Host"), Port: port, SourceId: uint32(source), ReplicaUUID: name(i, "Replica_UUID", "Slave_UUID")}
+		if h.Host == "" {
+			missing = append(missing, len(list))
+		}
+		list = append(list, h)
+	}
+	if len(missing) == 0 {
+		return list, nil
+	}
+
+	p, err := c.ExecuteContext(ctx, "SELECT HOST FROM INFORMATION_SCHEMA.PROCESSLIST WHERE COMMAND IN ('Binlog Dump', 'Binlog Dump GTID')")
+	if err != nil {
+		return list, err
+	}
+	var hosts []string
+	for i := range p.Values {
+		addr, _ := p.GetString(i, 0)
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if slices.Contains(hosts, host) || slices.ContainsFunc(list, func(h ReplicaHost) bool { return h.Host == host }) {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	for _, i := range missing {
+		for _, host := range hosts {
+			id, err := c.serverId(ctx, net.JoinHostPort(host, strconv.Itoa(list[i].Port)))
+			if err == nil && id == list[i].ServerId {
+				list[i].Host = host
+				break
+			}
+		}
+	}
+	return list, nil
+}
+
+// serverId @@server_id of addr, connected with the user, password and dialer of c
+func (c *Conn) serverId(ctx context.Context, addr string) (uint32, error) {
+	cfg := *c.cfg
+	cfg.Addr = addr
+	cfg.Pool = false
+	conn := NewClient(&cfg)
+	defer conn.Close()
+	r, err := conn.ExecuteContext(ctx, "SELECT @@server_id")
+	if err != nil {
+		return 0, err
+	}
+	id, err := r.GetUint(0, 0)
+	return uint32(id), err
+}
+
+// Topology walks the replicas with the user, password and dialer of c
+func (c *Conn) Topology(ctx context.Context) *TopologyNode {
+	seen := map[string]bool{}
+	return c.topology(ctx, c.cfg.Addr, seen)
+}
+
+func (c *Conn) topology(ctx context.Context, addr string, seen map[string]bool) *TopologyNode {
+	node := &TopologyNode{Addr: addr}
+	// master-master and other cycles end at a node already walked
+	if seen[addr] {
+		return nil
+	}
+	seen[addr] = true
+	var err error
+	if node.Settings, err = c.BinlogSettings(ctx); err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	if seen[node.Settings.ServerUUID] {
+		return nil
+	}
+	seen[node.Settings.ServerUUID] = true
+	if node.Replication, err = c.ReplicaStatus(ctx); err != nil {
+		node.Error = err.Error()
+	}
+	replicas, err := c.Replicas(ctx)
+	if err != nil {
+		node.Error = err.Error()
+	}
+	for _, h := range replicas {
+		if h.Host == "" {
+			node.Replicas = append(node.Replicas, &TopologyNode{Error: fmt.Sprintf("replica server_id %d has no report_host and no binlog dump connection of it was found", h.ServerId)})
+			continue
+		}
+		if seen[h.Addr()] || h.ReplicaUUID != "" && seen[h.ReplicaUUID] {
+			continue
+		}
+		cfg := *c.cfg
+		cfg.Addr = h.Addr()
+		cfg.Pool = false
+		replica := NewClient(&cfg)
+		child := replica.topology(ctx, cfg.Addr, seen)
+		replica.Close()
+		if child != nil {
+			node.Replicas = append(node.Replicas, child)
+		}
+	}
+	return node
+}
+
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Preflight the settings and privileges canal needs
+type Preflight struct {
+	Checks []Check
+}
+
+func (p *Preflight) OK() bool {
+	return !slices.ContainsFunc(p.Checks, func(c Check) bool { return !c.OK })
+}
+
+// Err lists the failed checks, nil when all passed
+func (p *Preflight) Err() error {
+	var errs []error
+	for _, c := range p.Checks {
+		if !c.OK {
+			errs = append(errs, fmt.Errorf("%s: %s", c.Name, c.Detail))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Preflight) String() string {
+	var b strings.Builder
+	for _, c := range p.Checks {
+		state := "ok"
+		if !c.OK {
+			state = "FAIL"
+		}
+		fmt.Fprintf(&b, "%-4s %s %s\n", state, c.Name, c.Detail)
+	}
+	return b.String()
+}
+
+func (p *Preflight) add(name string, ok bool, detail string) {
+	p.Checks = append(p.Checks, Check{Name: name, OK: ok, Detail: detail})
+}
+
+// Preflight checks binlog settings and the grants of the current user
+//
+//	log_bin ON, binlog_format ROW, binlog_row_image FULL, gtid_mode ON, enforce_gtid_consistency ON, server_id set
+//	REPLICATION SLAVE, REPLICATION CLIENT, SELECT, RELOAD (FLUSH TABLES WITH READ LOCK of the full export)
+func (c *Conn) Preflight(ctx context.Context) (*Preflight, error) {
+	s, err := c.BinlogSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := &Preflight{}
+	p.add("log_bin", s.LogBin, "binary log must be enabled, log_bin is OFF")
+	p.add("binlog_format", strings.EqualFold(s.BinlogFormat, "ROW"), "must be ROW, is "+s.BinlogFormat)
+	p.add("binlog_row_image", strings.EqualFold(s.BinlogRowImage, "FULL"), "must be FULL, is "+s.BinlogRowImage)
+	p.add("gtid_mode", strings.EqualFold(s.GtidMode, "ON"), "must be ON, is "+s.GtidMode)
+	p.add("enforce_gtid_consistency", strings.EqualFold(s.EnforceGtidConsistency, "ON"), "must be ON, is "+s.EnforceGtidConsistency)
+	p.add("server_id", s.ServerId != 0, "server_id must not be 0")
+	for i := range p.Checks {
+		if p.Checks[i].OK {
+			p.Checks[i].Detail = ""
+		}
+	}
+
+	grants, err := c.grants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, priv := range []struct{ name, alias string }{
+		{"REPLICATION SLAVE", "REPLICATION REPLICA"},
+		{"REPLICATION CLIENT", ""},
+		{"SELECT", ""},
+		{"RELOAD", ""},
+	} {
+		ok := grants.has(priv.name) || (priv.alias != "" && grants.has(priv.alias))
+		detail := ""
+		if !ok {
+			detail = "GRANT " + priv.name + " ON *.* TO the canal user"
+		}
+		p.add("privilege "+priv.name, ok, detail)
+	}
+	return p, nil
+}
+
+// global privileges of the current user
+type grantSet map[string]bool
+
+func (g grantSet) has(priv string) bool {
+	return g["ALL PRIVILEGES"] || g[priv]
+}
+
+func (c *Conn) grants(ctx context.Context) (grantSet, error) {
+	r, err := c.ExecuteContext(ctx, "SHOW GRANTS FOR CURRENT_USER()")
+	if err != nil {
+		return nil, err
+	}
+	g := grantSet{}
+	for i := range r.Values {
+		line, _ := r.GetString(i, 0)
+		privs, on, ok := strings.Cut(strings.TrimPrefix(line, "GRANT "), " ON ")
+		if !ok || !strings.HasPrefix(on, "*.*") {
+			continue
+		}
+		for _, priv := range strings.Split(privs, ",") {
+			g[strings.ToUpper(strings.TrimSpace(priv))] = true
+		}
+	}
+	return g, nil
+}
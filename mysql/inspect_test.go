@@ -0,0 +1,54 @@
+package mysql
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// replicaServer answers SELECT @@server_id with id
+func replicaServer(t *testing.T, id uint64) int {
+	s := newFakeServer(t, func(q query) (*reply, error) {
+		return &reply{names: []string{"@@server_id"}, rows: [][]interface{}{{id}}}, nil
+	})
+	_, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func TestReplicas(t *testing.T) {
+	portA, portB := replicaServer(t, 2), replicaServer(t, 4)
+	names := []string{"Server_Id", "Host", "Port", "Source_Id", "Replica_UUID"}
+	source := newFakeServer(t, func(q query) (*reply, error) {
+		switch q.sql {
+		case "SHOW REPLICAS":
+			return &reply{names: names, rows: [][]interface{}{
+				{"2", "", strconv.Itoa(portA), "1", "uuid-2"},
+				{"3", "r3", "3306", "1", "uuid-3"},
+				{"4", "", strconv.Itoa(portB), "1", "uuid-4"},
+				// answers at portA with server_id 2
+				{"5", "", strconv.Itoa(portA), "1", "uuid-5"},
+			}}, nil
+		case "SELECT HOST FROM INFORMATION_SCHEMA.PROCESSLIST WHERE COMMAND IN ('Binlog Dump', 'Binlog Dump GTID')":
+			return &reply{names: []string{"HOST"}, rows: [][]interface{}{{"r3:50000"}, {"127.0.0.1:50001"}, {"127.0.0.1:50002"}}}, nil
+		}
+		return nil, nil
+	})
+	c := NewClient(source.config())
+	defer c.Close()
+
+	list, err := c.Replicas(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32]string{2: "127.0.0.1", 3: "r3", 4: "127.0.0.1", 5: ""}
+	if len(list) != len(want) {
+		t.Fatalf("replicas = %+v, want the %d of SHOW REPLICAS", list, len(want))
+	}
+	for _, h := range list {
+		if h.Host != want[h.ServerId] {
+			t.Errorf("server_id %d host = %q, want %q", h.ServerId, h.Host, want[h.ServerId])
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package mysql
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Loader restores a directory written by Dumper, data files are loaded in
+// parallel with foreign key and unique checks disabled
+type Loader struct {
+	Target *Conn
+	Dir    string
+	// db.table filter, canal.FilterTable().Include(...).Match, nil loads every table
+	Match func(table string) bool
+	// parallel files, default 4
+	Threads int
+	// drop tables that already exist, otherwise CREATE TABLE fails
+	DropTable bool
+	// rows per INSERT when loading csv, default 1000
+	BatchRows int
+}
+
+type loadFile struct {
+	table *DumpTable
+	name  string
+}
+
+// Load returns the metadata of the dump, start replication from its Gtid
+func (l *Loader) Load(ctx context.Context) (*DumpMetadata, error) {
+	if l.Threads <= 0 {
+		l.Threads = 4
+	}
+	if l.BatchRows <= 0 {
+		l.BatchRows = 1000
+	}
+	b, err := os.ReadFile(filepath.Join(l.Dir, dumpMetadata))
+	if err != nil {
+		return nil, err
+	}
+	meta := &DumpMetadata{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("%s: %w", dumpMetadata, err)
+	}
+
+	var tables []*DumpTable
+	for i, t := range meta.Tables {
+		if l.Match == nil || l.Match(t.Schema+"."+t.Table) {
+			tables = append(tables, &meta.Tables[i])
+		}
+	}
+	if err := l.loadSchema(ctx, tables, meta.TimeZone); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan loadFile)
+	errs := make([]error, l.Threads)
+	var wg sync.WaitGroup
+	for i := range l.Threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := newSessionClient(l.Target, loadSession(meta.TimeZone)...)
+			defer c.Close()
+			for f := range queue {
+				if err := l.loadFile(ctx, c, f, meta.Format); err != nil {
+					errs[i] = fmt.Errorf("load %s: %w", f.name, err)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+send:
+	for _, t := range tables {
+		for _, name := range t.Files {
+			select {
+			case queue <- loadFile{table: t, name: name}:
+			case <-ctx.Done():
+				break send
+			}
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return meta, ctx.Err()
+}
+
+// loadSession init statements of the load sessions, timeZone of the dump,
+// empty keeps the server time zone
+func loadSession(timeZone string) []string {
+	stmts := []string{"SET FOREIGN_KEY_CHECKS = 0", "SET UNIQUE_CHECKS = 0"}
+	if timeZone != "" {
+		stmts = append(stmts, "SET time_zone = "+quoteLiteral([]byte(timeZone)))
+	}
+	return stmts
+}
+
+func (l *Loader) loadSchema(ctx context.Context, tables []*DumpTable, timeZone string) error {
+	c := newSessionClient(l.Target, loadSession(timeZone)...)
+	defer c.Close()
+
+	dbs := map[string]bool{}
+	for _, t := range tables {
+		if !dbs[t.Schema] {
+			dbs[t.Schema] = true
+			sql, err := os.ReadFile(filepath.Join(l.Dir, t.Schema+"-schema-create.sql"))
+			if err != nil {
+				return err
+			}
+			create := strings.Replace(strings.TrimSuffix(strings.TrimSpace(string(sql)), ";"), "CREATE DATABASE ", "CREATE DATABASE IF NOT EXISTS ", 1)
+			if _, err := c.ExecuteContext(ctx, create); err != nil {
+				return err
+			}
+		}
+		sql, err := os.ReadFile(filepath.Join(l.Dir, t.Schema+"."+t.Table+"-schema.sql"))
+		if err != nil {
+			return err
+		}
+		if err := c.UseDBContext(ctx, t.Schema); err != nil {
+			return err
+		}
+		if l.DropTable {
+			if _, err := c.ExecuteContext(ctx, "DROP TABLE IF EXISTS "+backQuote(t.Schema)+"."+backQuote(t.Table)); err != nil {
+				return err
+			}
+		}
+		if _, err := c.ExecuteContext(ctx, strings.TrimSuffix(strings.TrimSpace(string(sql)), ";")); err != nil {
+			return fmt.Errorf("create %s.%s: %w", t.Schema, t.Table, err)
+		}
+	}
+	return nil
+}
+
+func (l *Loader) loadFile(ctx context.Context, c *Conn, f loadFile, format DumpFormat) error {
+	file, err := os.Open(filepath.Join(l.Dir, f.name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(f.name, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if format == DumpCSV {
+		return l.loadCSV(ctx, c, f.table, r)
+	}
+	return loadSQL(ctx, c, r)
+}
+
+// loadSQL newlines in values are escaped, a line ending with ; ends the statement
+func loadSQL(ctx context.Context, c *Conn, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	var stmt strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		stmt.WriteString(line)
+		if strings.HasSuffix(line, ";\n") || (err == io.EOF && strings.TrimSpace(stmt.String()) != "") {
+			sql := strings.TrimSuffix(strings.TrimSpace(stmt.String()), ";")
+			stmt.Reset()
+			if _, err := c.ExecuteContext(ctx, sql); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *Loader) loadCSV(ctx context.Context, c *Conn, t *DumpTable, r io.Reader) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = backQuote(name)
+	}
+	insert := "INSERT INTO " + backQuote(t.Schema) + "." + backQuote(t.Table) + " (" + strings.Join(columns, ", ") + ") VALUES "
+
+	var rows []string
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		_, err := c.ExecuteContext(ctx, insert+strings.Join(rows, ","))
+		rows = rows[:0]
+		return err
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		values := make([]string, len(record))
+		for i, v := range record {
+			values[i] = csvLiteral(v)
+		}
+		rows = append(rows, "("+strings.Join(values, ",")+")")
+		if len(rows) >= l.BatchRows {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// csvLiteral sql literal of a csvValue
+func csvLiteral(v string) string {
+	if v == `\N` {
+		return "NULL"
+	}
+	return quoteLiteral([]byte(strings.ReplaceAll(v, `\\`, `\`)))
+}
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -23,15 +24,32 @@ type Dialer = client.Dialer
 // type Conn = client.Conn
 type SelectPerRowCallback func(client *Conn, table *TableInfo, row []mysql.FieldValue) error
 
+// Pool makes Conn a pool of up to PoolMaxAlive connections, default 10, the
+// Pool* settings are only used in pooled mode. Statements of a pooled Conn may
+// run on different connections, so session state like locks, SET variables and
+// transactions needs ExecTx or a Conn without Pool
 type Config struct {
 	Addr         string
 	User         string
 	Password     string
+	Pool         bool
 	PoolMaxAlive int
 	Dialer       client.Dialer
 	TLSConfig    *tls.Config
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+
+	// connections kept open even when idle
+	PoolMinAlive int
+	// idle connections above PoolMinAlive are closed after PoolIdleTimeout, default 5m
+	PoolIdleTimeout time.Duration
+	// connections are replaced after PoolMaxLifetime, 0 is unlimited
+	PoolMaxLifetime time.Duration
+	// idle connections are pinged every PoolHealthCheck, default 30s
+	PoolHealthCheck time.Duration
+
+	// run on every new connection, like SET time_zone, so a reconnect keeps the session
+	InitStatements []string
 }
 
 func RewriteMysqlQueryColumn(c *Conn, schema, table string) string {
@@ -148,22 +166,15 @@ func FetchTableSchema(c *Conn, database, table string, tables_schema map[string]
 }
 func FetchMasterStatus(c *Conn) (string, error) {
 
-	r, err := c.Execute("SHOW MASTER STATUS;")
-
+	st, err := c.MasterStatus(context.Background())
 	if err != nil {
 
 		return "", err
 	}
-
-	binlog_file, _ := r.GetStringByName(0, "File")
-	binlog_position, _ := r.GetIntByName(0, "Position")
-	binlog_do_db, _ := r.GetStringByName(0, "Binlog_Do_DB")
-	binlog_ignore_db, _ := r.GetStringByName(0, "Binlog_Ignore_DB")
-	executed_gtid_set, _ := r.GetStringByName(0, "Executed_Gtid_Set")
 	data_version := 1
 
-	fmt.Println(data_version, binlog_file, binlog_position, binlog_do_db, binlog_ignore_db, executed_gtid_set)
-	return executed_gtid_set, nil
+	fmt.Println(data_version, st.File, st.Position, st.BinlogDoDB, st.BinlogIgnoreDB, st.ExecutedGtidSet)
+	return st.ExecutedGtidSet, nil
 }
 
 func ValueToString(col *schema.TableColumn, value interface{}) string {
@@ -181,38 +192,19 @@ func valueType(col *schema.TableColumn, value interface{}) interface{} {
 		switch value := value.(type) {
 		case int64:
 			// for binlog, ENUM may be int64, but for dump, enum is string
-			eNum := value - 1
-			if eNum < 0 || eNum >= int64(len(col.EnumValues)) {
-				// we insert invalid enum value before, so return empty
-				fmt.Printf("invalid binlog enum index %d, for enum %v\n", eNum, col.EnumValues)
-				return ""
-			}
-
-			return col.EnumValues[eNum]
+			return enumValue(col, value)
 		}
 	case schema.TYPE_SET:
 		switch value := value.(type) {
 		case int64:
 			// for binlog, SET may be int64, but for dump, SET is string
-			bitmask := value
-			sets := make([]string, 0, len(col.SetValues))
-			for i, s := range col.SetValues {
-				if bitmask&int64(1<<uint(i)) > 0 {
-					sets = append(sets, s)
-				}
-			}
-			return strings.Join(sets, ",")
+			return strings.Join(setValue(col, value), ",")
 		}
 	case schema.TYPE_BIT:
 		switch value := value.(type) {
 		case string:
 			// for binlog, BIT is int64, but for dump, BIT is string
-			// for dump 0x01 is for 1, \0 is for 0
-			if value == "\x01" {
-				return int64(1)
-			}
-
-			return int64(0)
+			return int64(bitValue(value))
 		}
 	case schema.TYPE_STRING:
 		switch value := value.(type) {
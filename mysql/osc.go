@@ -0,0 +1,490 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// OnlineAlter changes a table without locking it, like gh-ost
+//
+//  1. create the ghost table _table_gho and ALTER it
+//  2. tail the binlog of the table with canal and apply the rows to the ghost table
+//  3. copy the rows in primary key chunks with INSERT IGNORE ... SELECT, throttled
+//  4. cut-over: LOCK TABLES table WRITE, wait until the binlog is applied, then
+//     RENAME table TO _table_del, _table_gho TO table is released first
+//
+// the source needs binlog_format ROW, binlog_row_image FULL and gtid_mode ON, the
+// table a single column primary key kept by the ALTER
+//
+//	err := (&mysql.OnlineAlter{Conn: cli, Schema: "db", Table: "t", Alter: "ADD COLUMN c INT"}).Run(ctx)
+type OnlineAlter struct {
+	Conn   *Conn
+	Schema string
+	Table  string
+	// ALTER TABLE clauses
+	Alter string
+	// rows per copy chunk, default 1000
+	ChunkSize int
+
+	// the copy pauses while a replica lags more than MaxLag, default 10s
+	Replicas []*Conn
+	MaxLag   time.Duration
+	// the copy pauses while Threads_running of the source is above, 0 is disabled
+	MaxThreadsRunning int
+	// wait between throttle checks, default 1s
+	ThrottleInterval time.Duration
+
+	// lock_wait_timeout of the cut-over and how long the locked table waits for the binlog, default 3s
+	CutOverTimeout time.Duration
+	// cut-over attempts, default 5
+	CutOverRetries int
+	// keep _table_del after the cut-over
+	KeepOld bool
+
+	log    *slog.Logger
+	info   *TableInfo
+	pk     *TableColumn
+	ghost  string
+	old    string
+	shared []string
+	// the ghost table was created by this run
+	created bool
+}
+
+func (o *OnlineAlter) defaults() {
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = 1000
+	}
+	if o.MaxLag <= 0 {
+		o.MaxLag = 10 * time.Second
+	}
+	if o.ThrottleInterval <= 0 {
+		o.ThrottleInterval = time.Second
+	}
+	if o.CutOverTimeout <= 0 {
+		o.CutOverTimeout = 3 * time.Second
+	}
+	if o.CutOverRetries <= 0 {
+		o.CutOverRetries = 5
+	}
+	o.log = slog.Default().With("table", o.Schema+"."+o.Table)
+}
+
+func (o *OnlineAlter) name(table string) string {
+	return backQuote(o.Schema) + "." + backQuote(table)
+}
+
+func (o *OnlineAlter) Run(ctx context.Context) error {
+	o.defaults()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the ghost table is dropped unless the cut-over renamed it, so the next run can create it again
+	var cutOver bool
+	defer func() {
+		if o.created && !cutOver {
+			if _, err := o.Conn.Execute("DROP TABLE IF EXISTS " + o.name(o.ghost)); err != nil {
+				o.log.Error("drop ghost table", "error", err)
+			}
+		}
+	}()
+	if err := o.prepare(ctx); err != nil {
+		return err
+	}
+
+	// canal formats TIMESTAMP values in UTC, kept when the applier reconnects
+	applier := newSessionClient(o.Conn, "SET time_zone = '+00:00'")
+	defer applier.Close()
+	c, err := o.tail(applier)
+	if err != nil {
+		return err
+	}
+	gset, err := c.GetMasterGTIDSet()
+	if err != nil {
+		c.Close()
+		return err
+	}
+	tailErr := make(chan error, 1)
+	go func() {
+		tailErr <- c.StartFromGTID(gset)
+	}()
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+	defer c.Close()
+
+	copyErr := make(chan error, 1)
+	go func() {
+		copyErr <- o.copy(ctx)
+	}()
+	select {
+	case err = <-copyErr:
+	case err = <-tailErr:
+		if err == nil {
+			err = errors.New("binlog tail stopped")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err = o.cutOver(ctx, c.SyncedGTIDSet)
+		if err == nil || i+1 >= o.CutOverRetries || ctx.Err() != nil {
+			break
+		}
+		o.log.Warn("cut-over", "attempt", i+1, "error", err)
+	}
+	if err != nil {
+		return fmt.Errorf("cut-over: %w", err)
+	}
+	cutOver = true
+	o.log.Info("cut-over done")
+	if !o.KeepOld {
+		_, err = o.Conn.ExecuteContext(ctx, "DROP TABLE IF EXISTS "+o.name(o.old))
+	}
+	return err
+}
+
+// prepare creates the ghost table and the column list shared by both tables
+func (o *OnlineAlter) prepare(ctx context.Context) error {
+	var err error
+	o.info, err = o.Conn.GetTableInfo(o.Schema, o.Table)
+	if err != nil {
+		return err
+	}
+	if o.pk, err = pkColumn(o.info); err != nil {
+		return err
+	}
+	o.ghost = "_" + o.Table + "_gho"
+	o.old = "_" + o.Table + "_del"
+	o.created = false
+	if _, err := o.Conn.ExecuteContext(ctx, "CREATE TABLE "+o.name(o.ghost)+" LIKE "+o.name(o.Table)); err != nil {
+		return err
+	}
+	o.created = true
+	if _, err := o.Conn.ExecuteContext(ctx, "ALTER TABLE "+o.name(o.ghost)+" "+o.Alter); err != nil {
+		return err
+	}
+	ghost, err := o.Conn.GetTableInfo(o.Schema, o.ghost)
+	if err != nil {
+		return err
+	}
+	if c := ghost.FindColumn(o.pk.Name); c < 0 || len(ghost.PKColumns) != 1 || ghost.PKColumns[0] != c {
+		return fmt.Errorf("alter must keep the primary key %s", o.pk.Name)
+	}
+	o.shared = nil
+	for _, col := range o.info.Columns {
+		if col.IsVirtual || col.IsStored {
+			continue
+		}
+		if i := ghost.FindColumn(col.Name); i >= 0 && !ghost.Columns[i].IsVirtual && !ghost.Columns[i].IsStored {
+			o.shared = append(o.shared, col.Name)
+		}
+	}
+	return nil
+}
+
+func (o *OnlineAlter) tail(applier *Conn) (*canal.Canal, error) {
+	cfg := canal.NewDefaultConfig()
+	cfg.Addr = o.Conn.cfg.Addr
+	cfg.User = o.Conn.cfg.User
+	cfg.Password = o.Conn.cfg.Password
+	cfg.Dialer = o.Conn.cfg.Dialer
+	cfg.Dump.ExecutionPath = ""
+	cfg.IncludeTableRegex = []string{"^" + regexp.QuoteMeta(o.Schema) + `\.` + regexp.QuoteMeta(o.Table) + "$"}
+	cfg.TimestampStringLocation = time.UTC
+	cfg.Logger = o.log
+	c, err := canal.NewCanal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.SetEventHandler(&oscHandler{o: o, applier: applier})
+	return c, nil
+}
+
+// copy chunks (lo, hi] up to the max primary key at start, later rows come from the binlog
+func (o *OnlineAlter) copy(ctx context.Context) error {
+	key := backQuote(o.pk.Name)
+	r, err := o.Conn.ExecuteContext(ctx, "SELECT MAX("+key+") FROM "+o.name(o.Table))
+	if err != nil {
+		return err
+	}
+	end, _ := r.GetString(0, 0)
+	if end == "" {
+		return nil
+	}
+
+	cols := make([]string, len(o.shared))
+	for i, name := range o.shared {
+		cols[i] = backQuote(name)
+	}
+	list := strings.Join(cols, ", ")
+	var copied int64
+	chunk := Chunk{}
+	for {
+		if err := o.throttle(ctx); err != nil {
+			return err
+		}
+		where, args := chunk.Where(key)
+		r, err := o.Conn.ExecuteContext(ctx, "SELECT MAX("+key+") FROM (SELECT "+key+" FROM "+o.name(o.Table)+
+			" WHERE "+where+" AND "+key+" <= ? ORDER BY "+key+" LIMIT "+strconv.Itoa(o.ChunkSize)+") a", append(args, end)...)
+		if err != nil {
+			return err
+		}
+		chunk.Hi, _ = r.GetString(0, 0)
+		if chunk.Hi == "" {
+			break
+		}
+		where, args = chunk.Where(key)
+		r, err = o.Conn.ExecuteContext(ctx, "INSERT IGNORE INTO "+o.name(o.ghost)+" ("+list+") SELECT "+list+" FROM "+o.name(o.Table)+
+			" FORCE INDEX (PRIMARY) WHERE "+where+" LOCK IN SHARE MODE", args...)
+		if err != nil {
+			return err
+		}
+		copied += int64(r.AffectedRows)
+		o.log.Debug("copy", "chunk", chunk.String(), "rows", copied)
+		chunk = Chunk{Lo: chunk.Hi}
+	}
+	o.log.Info("copy done", "rows", copied)
+	return nil
+}
+
+// throttle waits while a replica lags or the source is busy
+func (o *OnlineAlter) throttle(ctx context.Context) error {
+	for {
+		reason, err := o.throttled(ctx)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			return nil
+		}
+		o.log.Info("throttled", "reason", reason)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(o.ThrottleInterval):
+		}
+	}
+}
+
+func (o *OnlineAlter) throttled(ctx context.Context) (string, error) {
+	if o.MaxThreadsRunning > 0 {
+		r, err := o.Conn.ExecuteContext(ctx, "SHOW GLOBAL STATUS LIKE 'Threads_running'")
+		if err != nil {
+			return "", err
+		}
+		n, _ := r.GetInt(0, 1)
+		if n > int64(o.MaxThreadsRunning) {
+			return fmt.Sprintf("threads_running %d > %d", n, o.MaxThreadsRunning), nil
+		}
+	}
+	for _, replica := range o.Replicas {
+		lag, err := replicaLag(ctx, replica)
+		if err != nil {
+			return "", err
+		}
+		if lag < 0 || lag > o.MaxLag {
+			return fmt.Sprintf("replica %s lag %s", replica.cfg.Addr, lag), nil
+		}
+	}
+	return "", nil
+}
+
+// replicaLag Seconds_Behind_Source, -1 when replication is stopped
+func replicaLag(ctx context.Context, c *Conn) (time.Duration, error) {
+	list, err := c.ReplicaStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	lag := time.Duration(-1)
+	for _, st := range list {
+		if st.SecondsBehindSource < 0 {
+			return -1, nil
+		}
+		lag = max(lag, time.Duration(st.SecondsBehindSource)*time.Second)
+	}
+	return lag, nil
+}
+
+// cutOver the locked table has no writes, once the binlog is applied the RENAME
+// is queued behind the lock and runs before any waiting DML when it is released,
+// synced is the gtid set the binlog tail has applied
+func (o *OnlineAlter) cutOver(ctx context.Context, synced func() mysql.GTIDSet) error {
+	timeout := "SET SESSION lock_wait_timeout = " + strconv.Itoa(int(max(o.CutOverTimeout/time.Second, 1)))
+	lock := newSessionClient(o.Conn, timeout)
+	defer lock.Close()
+	rename := newSessionClient(o.Conn, timeout)
+	defer rename.Close()
+
+	if _, err := lock.ExecuteContext(ctx, "LOCK TABLES "+o.name(o.Table)+" WRITE"); err != nil {
+		return err
+	}
+	unlocked := false
+	unlock := func() {
+		if !unlocked {
+			unlocked = true
+			lock.Execute("UNLOCK TABLES")
+		}
+	}
+	defer unlock()
+
+	r, err := lock.ExecuteContext(ctx, "SELECT @@GLOBAL.GTID_EXECUTED")
+	if err != nil {
+		return err
+	}
+	s, _ := r.GetString(0, 0)
+	target, err := mysql.ParseMysqlGTIDSet(s)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(o.CutOverTimeout)
+	for {
+		if set := synced(); set != nil && set.Contain(target) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("binlog apply did not catch up within CutOverTimeout")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	r, err = rename.ExecuteContext(ctx, "SELECT CONNECTION_ID()")
+	if err != nil {
+		return err
+	}
+	id, _ := r.GetInt(0, 0)
+	renamed := make(chan error, 1)
+	go func() {
+		_, err := rename.ExecuteContext(ctx, "RENAME TABLE "+o.name(o.Table)+" TO "+o.name(o.old)+", "+o.name(o.ghost)+" TO "+o.name(o.Table))
+		renamed <- err
+	}()
+
+	// the RENAME must be waiting on the lock before it is released
+	for {
+		r, err := o.Conn.ExecuteContext(ctx, "SELECT STATE FROM INFORMATION_SCHEMA.PROCESSLIST WHERE ID = ?", id)
+		if err != nil {
+			return err
+		}
+		if len(r.Values) > 0 {
+			state, _ := r.GetString(0, 0)
+			if strings.Contains(state, "metadata lock") {
+				break
+			}
+		}
+		select {
+		case err := <-renamed:
+			return fmt.Errorf("rename did not wait for the lock: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline.Add(o.CutOverTimeout)) {
+			return errors.New("rename is not waiting on the table lock")
+		}
+	}
+	unlock()
+	return <-renamed
+}
+
+type oscHandler struct {
+	canal.DummyEventHandler
+	o       *OnlineAlter
+	applier *Conn
+}
+
+func (h *oscHandler) String() string { return "OnlineAlter" }
+
+// rows are applied to the ghost table with REPLACE and DELETE by primary key
+func (h *oscHandler) OnRow(e *canal.RowsEvent) error {
+	o := h.o
+	pk := e.Table.FindColumn(o.pk.Name)
+	if pk < 0 {
+		return fmt.Errorf("primary key %s not in binlog table", o.pk.Name)
+	}
+	del := "DELETE FROM " + o.name(o.ghost) + " WHERE " + backQuote(o.pk.Name) + " = ?"
+
+	replace := func(row []interface{}) error {
+		cols := make([]string, 0, len(o.shared))
+		args := make([]interface{}, 0, len(o.shared))
+		for _, name := range o.shared {
+			i := e.Table.FindColumn(name)
+			if i < 0 || i >= len(row) {
+				continue
+			}
+			cols = append(cols, backQuote(name))
+			args = append(args, oscValue(&e.Table.Columns[i], row[i]))
+		}
+		sql := "REPLACE INTO " + o.name(o.ghost) + " (" + strings.Join(cols, ",") + ") VALUES (" + strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",") + ")"
+		_, err := h.applier.Execute(sql, args...)
+		return err
+	}
+
+	switch e.Action {
+	case canal.InsertAction:
+		for _, row := range e.Rows {
+			if err := replace(row); err != nil {
+				return err
+			}
+		}
+	case canal.DeleteAction:
+		for _, row := range e.Rows {
+			if _, err := h.applier.Execute(del, oscValue(&e.Table.Columns[pk], row[pk])); err != nil {
+				return err
+			}
+		}
+	case canal.UpdateAction:
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			before, after := e.Rows[i], e.Rows[i+1]
+			if fmt.Sprint(before[pk]) != fmt.Sprint(after[pk]) {
+				if _, err := h.applier.Execute(del, oscValue(&e.Table.Columns[pk], before[pk])); err != nil {
+					return err
+				}
+			}
+			if err := replace(after); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// oscValue binlog value in a form mysql reads back the same
+func oscValue(col *TableColumn, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch col.Type {
+	case schema.TYPE_NUMBER, schema.TYPE_MEDIUM_INT:
+		if col.IsUnsigned {
+			if v, err := unsignedValue(col, value); err == nil {
+				return v
+			}
+		}
+	case schema.TYPE_ENUM:
+		if v, ok := value.(int64); ok {
+			return enumValue(col, v)
+		}
+	case schema.TYPE_SET:
+		if v, ok := value.(int64); ok {
+			return strings.Join(setValue(col, v), ",")
+		}
+	case schema.TYPE_BIT:
+		return bitValue(value)
+	}
+	return value
+}
@@ -0,0 +1,179 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const oscGTID = "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-10"
+
+func argInt(v interface{}) int64 {
+	n, _ := strconv.ParseInt(fmt.Sprintf("%s", v), 10, 64)
+	return n
+}
+
+func TestOnlineAlterCopy(t *testing.T) {
+	ids := []int64{1, 2, 3, 5, 8}
+	var inserts [][]int64
+	s := newFakeServer(t, func(q query) (*reply, error) {
+		switch {
+		case q.sql == "SELECT MAX(`id`) FROM `db`.`t`":
+			return &reply{names: []string{"max"}, rows: [][]interface{}{{ids[len(ids)-1]}}}, nil
+		case strings.HasPrefix(q.sql, "SELECT MAX(`id`) FROM (SELECT"):
+			lo := int64(0)
+			if strings.Contains(q.sql, "`id` > ?") {
+				lo = argInt(q.args[0])
+			}
+			end := argInt(q.args[len(q.args)-1])
+			var hi interface{}
+			n := 0
+			for _, id := range ids {
+				if id > lo && id <= end && n < 2 {
+					hi, n = id, n+1
+				}
+			}
+			return &reply{names: []string{"max"}, rows: [][]interface{}{{hi}}}, nil
+		case strings.HasPrefix(q.sql, "INSERT IGNORE INTO `db`.`_t_gho` (`id`, `v`) SELECT `id`, `v` FROM `db`.`t`"):
+			var bounds []int64
+			for _, arg := range q.args {
+				bounds = append(bounds, argInt(arg))
+			}
+			inserts = append(inserts, bounds)
+			return &reply{affected: 2}, nil
+		}
+		return nil, fmt.Errorf("unexpected %s", q.sql)
+	})
+	c := NewClient(s.config())
+	defer c.Close()
+	o := &OnlineAlter{Conn: c, Schema: "db", Table: "t", ChunkSize: 2}
+	o.defaults()
+	o.pk = &TableColumn{Name: "id"}
+	o.ghost = "_t_gho"
+	o.shared = []string{"id", "v"}
+
+	if err := o.copy(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// (-inf, 2], (2, 5], (5, 8]
+	want := [][]int64{{2}, {2, 5}, {5, 8}}
+	if fmt.Sprint(inserts) != fmt.Sprint(want) {
+		t.Errorf("copy chunks = %v, want %v", inserts, want)
+	}
+}
+
+// cutOverServer the lock and rename sessions of a cut-over, the RENAME waits for UNLOCK TABLES
+type cutOverServer struct {
+	mu       sync.Mutex
+	order    []string
+	waiting  bool
+	unlocked chan struct{}
+}
+
+func (c *cutOverServer) handle(q query) (*reply, error) {
+	c.mu.Lock()
+	c.order = append(c.order, q.sql)
+	c.mu.Unlock()
+	switch {
+	case q.sql == "SELECT @@GLOBAL.GTID_EXECUTED":
+		return &reply{names: []string{"gtid"}, rows: [][]interface{}{{oscGTID}}}, nil
+	case q.sql == "SELECT CONNECTION_ID()":
+		return &reply{names: []string{"id"}, rows: [][]interface{}{{int64(q.conn)}}}, nil
+	case strings.HasPrefix(q.sql, "SELECT STATE FROM INFORMATION_SCHEMA.PROCESSLIST"):
+		c.mu.Lock()
+		state := ""
+		if c.waiting {
+			state = "Waiting for table metadata lock"
+		}
+		c.mu.Unlock()
+		return &reply{names: []string{"STATE"}, rows: [][]interface{}{{state}}}, nil
+	case strings.HasPrefix(q.sql, "RENAME TABLE"):
+		c.mu.Lock()
+		c.waiting = true
+		c.mu.Unlock()
+		<-c.unlocked
+		return nil, nil
+	case q.sql == "UNLOCK TABLES":
+		close(c.unlocked)
+	}
+	return nil, nil
+}
+
+func (c *cutOverServer) index(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, sql := range c.order {
+		if strings.HasPrefix(sql, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestOnlineAlterCutOver(t *testing.T) {
+	target, err := mysql.ParseMysqlGTIDSet(oscGTID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	behind, _ := mysql.ParseMysqlGTIDSet("3E11FA47-71CA-11E1-9E33-C80AA9429562:1-9")
+
+	t.Run("rename queued behind the lock", func(t *testing.T) {
+		cs := &cutOverServer{unlocked: make(chan struct{})}
+		s := newFakeServer(t, cs.handle)
+		c := NewClient(s.config())
+		defer c.Close()
+		o := &OnlineAlter{Conn: c, Schema: "db", Table: "t", CutOverTimeout: time.Second}
+		o.defaults()
+		o.ghost, o.old = "_t_gho", "_t_del"
+
+		// the binlog tail catches up after a few polls
+		var polls int
+		synced := func() mysql.GTIDSet {
+			if polls++; polls < 3 {
+				return behind
+			}
+			return target
+		}
+		if err := o.cutOver(context.Background(), synced); err != nil {
+			t.Fatal(err)
+		}
+		lock, rename, unlock := cs.index("LOCK TABLES `db`.`t` WRITE"), cs.index("RENAME TABLE `db`.`t` TO `db`.`_t_del`, `db`.`_t_gho` TO `db`.`t`"), cs.index("UNLOCK TABLES")
+		if lock < 0 || rename < 0 || unlock < 0 || !(lock < rename && rename < unlock) {
+			t.Errorf("order = %q, want LOCK, RENAME, UNLOCK", cs.order)
+		}
+		// every session sets lock_wait_timeout on connect
+		sessions := map[uint32]bool{}
+		for _, q := range s.received() {
+			if q.sql == "SET SESSION lock_wait_timeout = 1" {
+				sessions[q.conn] = true
+			}
+		}
+		if len(sessions) != 2 {
+			t.Errorf("sessions with lock_wait_timeout = %d, want 2", len(sessions))
+		}
+	})
+
+	t.Run("binlog behind", func(t *testing.T) {
+		cs := &cutOverServer{unlocked: make(chan struct{})}
+		s := newFakeServer(t, cs.handle)
+		c := NewClient(s.config())
+		defer c.Close()
+		o := &OnlineAlter{Conn: c, Schema: "db", Table: "t", CutOverTimeout: 100 * time.Millisecond}
+		o.defaults()
+		o.ghost, o.old = "_t_gho", "_t_del"
+
+		err := o.cutOver(context.Background(), func() mysql.GTIDSet { return behind })
+		if err == nil || !strings.Contains(err.Error(), "catch up") {
+			t.Errorf("cutOver = %v, want the catch up error", err)
+		}
+		if cs.index("RENAME") >= 0 || cs.index("UNLOCK TABLES") < 0 {
+			t.Errorf("order = %q, want UNLOCK and no RENAME", cs.order)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package mysql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/juju/errors"
+)
+
+type pooledConn struct {
+	*client.Conn
+	created  time.Time
+	lastUsed time.Time
+}
+
+// pool of connections made by Conn.connect, so the ssh dialer works the same way
+type pool struct {
+	c   *Conn
+	max int
+
+	mu sync.Mutex
+	// connections open, idle or in use, at most max
+	open int
+	idle []*pooledConn
+	// closed and replaced when a connection is returned or closed
+	wake   chan struct{}
+	db     string
+	closed bool
+}
+
+func newPool(c *Conn) *pool {
+	max := c.cfg.PoolMaxAlive
+	if max <= 0 {
+		max = 10
+	}
+	p := &pool{c: c, max: max, wake: make(chan struct{})}
+	go p.maintain()
+	return p
+}
+
+func (p *pool) idleTimeout() time.Duration {
+	if p.c.cfg.PoolIdleTimeout > 0 {
+		return p.c.cfg.PoolIdleTimeout
+	}
+	return 5 * time.Minute
+}
+
+func (p *pool) expired(pc *pooledConn, now time.Time) bool {
+	lifetime := p.c.cfg.PoolMaxLifetime
+	return lifetime > 0 && now.Sub(pc.created) > lifetime
+}
+
+// broadcast wakes the waiters of get, p.mu is held
+func (p *pool) broadcast() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// release a closed connection, p.mu is held
+func (p *pool) release() {
+	p.open--
+	p.broadcast()
+}
+
+func (p *pool) get(ctx context.Context) (*pooledConn, error) {
+	for {
+		now := time.Now()
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errors.New("mysql pool closed")
+		}
+		db := p.db
+		if n := len(p.idle); n > 0 {
+			pc := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			if p.expired(pc, now) {
+				p.release()
+				p.mu.Unlock()
+				pc.Close()
+				continue
+			}
+			p.mu.Unlock()
+			if pc.GetDB() != db {
+				if err := pc.UseDB(db); err != nil {
+					pc.Close()
+					p.mu.Lock()
+					p.release()
+					p.mu.Unlock()
+					return nil, err
+				}
+			}
+			return pc, nil
+		}
+		if p.open < p.max {
+			p.open++
+			p.mu.Unlock()
+			conn, err := p.c.connect(ctx, db)
+			if err != nil {
+				p.mu.Lock()
+				p.release()
+				p.mu.Unlock()
+				return nil, errors.Trace(err)
+			}
+			return &pooledConn{Conn: conn, created: now, lastUsed: now}, nil
+		}
+		wake := p.wake
+		p.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// put returns pc to the pool, broken connections are closed
+func (p *pool) put(pc *pooledConn, broken bool) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if broken || p.closed || p.expired(pc, now) {
+		pc.Close()
+		p.release()
+		return
+	}
+	pc.lastUsed = now
+	p.idle = append(p.idle, pc)
+	p.broadcast()
+}
+
+func (p *pool) run(ctx context.Context, idempotent bool, fn func(conn *client.Conn) error) (err error) {
+	retryNum := 3
+	for i := 0; i < retryNum; i++ {
+		var pc *pooledConn
+		pc, err = p.get(ctx)
+		if err != nil {
+			return err
+		}
+		if p.c.stale(pc.Conn, pc.lastUsed, idempotent) {
+			// found before fn is sent, not counted as a retry
+			p.put(pc, true)
+			i--
+			continue
+		}
+		retry := idempotent && !pc.IsInTransaction()
+		var ok bool
+		ok, err = p.c.exec(ctx, pc.Conn, fn)
+		p.put(pc, !ok)
+		if !ok && retry && ctx.Err() == nil {
+			continue
+		}
+		break
+	}
+	return err
+}
+
+// useDB checks db exists and makes it the default of every connection
+func (p *pool) useDB(ctx context.Context, db string) error {
+	err := p.run(ctx, true, func(conn *client.Conn) error {
+		return conn.UseDB(db)
+	})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.db = db
+	p.mu.Unlock()
+	return nil
+}
+
+// maintain closes idle and expired connections, pings the rest and keeps PoolMinAlive open
+func (p *pool) maintain() {
+	interval := p.c.cfg.PoolHealthCheck
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.check()
+		select {
+		case <-p.c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// take pc out of idle, false when get took it already, p.mu is held
+func (p *pool) take(pc *pooledConn) bool {
+	for i := range p.idle {
+		if p.idle[i] == pc {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// check pings the idle connections one at a time, the others stay available to get
+func (p *pool) check() {
+	now := time.Now()
+	p.mu.Lock()
+	idle := append([]*pooledConn(nil), p.idle...)
+	db := p.db
+	p.mu.Unlock()
+
+	// idle is oldest first, the most recently used are kept
+	kept := 0
+	for i := len(idle) - 1; i >= 0; i-- {
+		pc := idle[i]
+		p.mu.Lock()
+		if !p.take(pc) {
+			p.mu.Unlock()
+			continue
+		}
+		extra := kept >= p.c.cfg.PoolMinAlive
+		if p.expired(pc, now) || (extra && now.Sub(pc.lastUsed) > p.idleTimeout()) {
+			p.release()
+			p.mu.Unlock()
+			pc.Close()
+			continue
+		}
+		p.mu.Unlock()
+
+		err := pc.Ping()
+		p.mu.Lock()
+		if err != nil || p.closed {
+			p.release()
+			p.mu.Unlock()
+			pc.Close()
+			continue
+		}
+		kept++
+		// back in front of the connections used since
+		p.idle = append([]*pooledConn{pc}, p.idle...)
+		p.broadcast()
+		p.mu.Unlock()
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed || p.open >= p.c.cfg.PoolMinAlive || p.open >= p.max {
+			p.mu.Unlock()
+			return
+		}
+		p.open++
+		p.mu.Unlock()
+
+		conn, err := p.c.connect(p.c.ctx, db)
+		p.mu.Lock()
+		if err != nil || p.closed {
+			p.release()
+			p.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		now := time.Now()
+		p.idle = append(p.idle, &pooledConn{Conn: conn, created: now, lastUsed: now})
+		p.broadcast()
+		p.mu.Unlock()
+	}
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.open -= len(idle)
+	p.broadcast()
+	p.mu.Unlock()
+	for _, pc := range idle {
+		pc.Close()
+	}
+}
+
+// PoolStats connections in use and idle, both 0 when not pooled
+func (c *Conn) PoolStats() (inUse, idle int) {
+	if c.pool == nil {
+		return 0, 0
+	}
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	return c.pool.open - len(c.pool.idle), len(c.pool.idle)
+}
@@ -0,0 +1,158 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func TestRetry(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pool %v", pooled), func(t *testing.T) {
+			var s *fakeServer
+			var mu sync.Mutex
+			dropped := map[string]bool{}
+			s = newFakeServer(t, func(q query) (*reply, error) {
+				// the first run of every statement loses its connection on the server
+				mu.Lock()
+				first := !dropped[q.sql]
+				dropped[q.sql] = true
+				mu.Unlock()
+				if first {
+					s.drop(q.conn)
+					return nil, nil
+				}
+				if q.sql == "SELECT 1" {
+					return &reply{names: []string{"1"}, rows: [][]interface{}{{int64(1)}}}, nil
+				}
+				return &reply{affected: 1}, nil
+			})
+			cfg := s.config()
+			cfg.Pool = pooled
+			c := NewClient(cfg)
+			defer c.Close()
+
+			r, err := c.Execute("SELECT 1")
+			if err != nil {
+				t.Fatalf("SELECT 1 = %v, want it retried on a new connection", err)
+			}
+			if v, _ := r.GetInt(0, 0); v != 1 {
+				t.Errorf("SELECT 1 = %d, want 1", v)
+			}
+			if got := len(s.sqls("SELECT 1")); got != 2 {
+				t.Errorf("SELECT 1 sent %d times, want 2", got)
+			}
+
+			if _, err := c.Execute("UPDATE t SET a = 1"); !mysql.ErrorEqual(err, mysql.ErrBadConn) {
+				t.Errorf("UPDATE = %v, want the bad connection error", err)
+			}
+			if got := len(s.sqls("UPDATE")); got != 1 {
+				t.Errorf("UPDATE sent %d times, want 1", got)
+			}
+			// the next statement gets a working connection
+			if _, err := c.Execute("UPDATE t SET a = 1"); err != nil {
+				t.Errorf("UPDATE after the broken connection = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestTransactionLost(t *testing.T) {
+	s := newFakeServer(t, func(q query) (*reply, error) {
+		switch q.sql {
+		case "BEGIN", "UPDATE t SET a = 1":
+			return &reply{status: mysql.SERVER_STATUS_IN_TRANS}, nil
+		case "SELECT 1":
+			return &reply{names: []string{"1"}, rows: [][]interface{}{{int64(1)}}}, nil
+		}
+		return nil, nil
+	})
+	c := NewClient(s.config())
+	defer c.Close()
+
+	if _, err := c.Execute("BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	s.drop(s.received()[0].conn)
+
+	// not retried inside the transaction even though SELECT is idempotent
+	if _, err := c.Execute("SELECT 1"); !mysql.ErrorEqual(err, mysql.ErrBadConn) {
+		t.Errorf("SELECT 1 in the transaction = %v, want the bad connection error", err)
+	}
+	if got := len(s.sqls("SELECT 1")); got != 0 {
+		t.Errorf("SELECT 1 received %d times, want 0", got)
+	}
+
+	if _, err := c.Execute("BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	s.drop(s.received()[len(s.received())-1].conn)
+	// idle for more than a second, the ping before the UPDATE finds the connection gone
+	c.lastUsed = time.Now().Add(-2 * time.Second)
+	_, err := c.Execute("UPDATE t SET a = 1")
+	if err == nil || !strings.Contains(err.Error(), "transaction lost") {
+		t.Errorf("UPDATE = %v, want the transaction lost error", err)
+	}
+	if got := len(s.sqls("UPDATE")); got != 0 {
+		t.Errorf("UPDATE received %d times, want 0 without its transaction", got)
+	}
+}
+
+func TestKillQuery(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pool %v", pooled), func(t *testing.T) {
+			killed := make(chan struct{})
+			var once sync.Once
+			s := newFakeServer(t, func(q query) (*reply, error) {
+				switch {
+				case q.sql == "SELECT SLEEP(10)":
+					select {
+					case <-killed:
+					case <-time.After(10 * time.Second):
+					}
+					return &reply{names: []string{"SLEEP(10)"}, rows: [][]interface{}{{int64(1)}}}, nil
+				case strings.HasPrefix(q.sql, "KILL QUERY "):
+					once.Do(func() { close(killed) })
+				}
+				return nil, nil
+			})
+			cfg := s.config()
+			cfg.Pool = pooled
+			c := NewClient(cfg)
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if _, err := c.ExecuteContext(ctx, "SELECT SLEEP(10)"); err != context.DeadlineExceeded {
+				t.Errorf("SELECT SLEEP(10) = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("SELECT SLEEP(10) returned after %v, want it to return on the deadline", d)
+			}
+			select {
+			case <-killed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("KILL QUERY not received")
+			}
+			var id uint32
+			for _, q := range s.received() {
+				if q.sql == "SELECT SLEEP(10)" {
+					id = q.conn
+				}
+			}
+			want := fmt.Sprintf("KILL QUERY %d", id)
+			if got := s.sqls("KILL QUERY "); len(got) != 1 || got[0] != want {
+				t.Errorf("kill = %q, want %q", got, want)
+			}
+			if got := len(s.sqls("SELECT SLEEP(10)")); got != 1 {
+				t.Errorf("SELECT SLEEP(10) sent %d times, want 1 after the deadline", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package mysql
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/server"
+)
+
+// query a statement received by fakeServer, args are nil for a text query
+type query struct {
+	conn uint32
+	sql  string
+	args []interface{}
+}
+
+// reply of fakeServer, a result set when names is set
+type reply struct {
+	names    []string
+	rows     [][]interface{}
+	affected uint64
+	// like mysql.SERVER_STATUS_IN_TRANS after BEGIN
+	status uint16
+}
+
+// fakeServer answers every statement with handle, the client side of the
+// package is tested against it
+type fakeServer struct {
+	addr   string
+	handle func(q query) (*reply, error)
+
+	mu      sync.Mutex
+	queries []query
+	conns   map[uint32]net.Conn
+}
+
+func newFakeServer(t *testing.T, handle func(q query) (*reply, error)) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{addr: l.Addr().String(), handle: handle, conns: map[uint32]net.Conn{}}
+	srv := server.NewServer("8.0.11", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_NATIVE_PASSWORD, nil, nil)
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		l.Close()
+		s.mu.Lock()
+		for _, c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer c.Close()
+				h := &fakeHandler{s: s}
+				conn, err := srv.NewConn(c, "root", "", h)
+				if err != nil {
+					return
+				}
+				h.id = conn.ConnectionID()
+				s.mu.Lock()
+				s.conns[h.id] = c
+				s.mu.Unlock()
+				for !conn.Closed() {
+					if err := conn.HandleCommand(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) config() *Config {
+	return &Config{Addr: s.addr, User: "root"}
+}
+
+// drop closes the connection of id without telling the client
+func (s *fakeServer) drop(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.conns[id]; ok {
+		c.Close()
+	}
+}
+
+// received statements, the handshake and init statements included
+func (s *fakeServer) received() []query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]query(nil), s.queries...)
+}
+
+// sqls of the received statements that start with prefix
+func (s *fakeServer) sqls(prefix string) []string {
+	var list []string
+	for _, q := range s.received() {
+		if strings.HasPrefix(q.sql, prefix) {
+			list = append(list, q.sql)
+		}
+	}
+	return list
+}
+
+type fakeHandler struct {
+	server.EmptyHandler
+	s  *fakeServer
+	id uint32
+}
+
+func (h *fakeHandler) UseDB(db string) error { return nil }
+
+func (h *fakeHandler) HandleQuery(sql string) (*mysql.Result, error) {
+	return h.result(query{conn: h.id, sql: sql}, false)
+}
+
+func (h *fakeHandler) HandleStmtPrepare(sql string) (int, int, interface{}, error) {
+	return strings.Count(sql, "?"), 0, nil, nil
+}
+
+func (h *fakeHandler) HandleStmtExecute(context interface{}, sql string, args []interface{}) (*mysql.Result, error) {
+	return h.result(query{conn: h.id, sql: sql, args: args}, true)
+}
+
+func (h *fakeHandler) HandleStmtClose(context interface{}) error { return nil }
+
+func (h *fakeHandler) result(q query, binary bool) (*mysql.Result, error) {
+	h.s.mu.Lock()
+	h.s.queries = append(h.s.queries, q)
+	h.s.mu.Unlock()
+	rep, err := h.s.handle(q)
+	if err != nil || rep == nil {
+		return nil, err
+	}
+	if rep.names == nil {
+		return &mysql.Result{AffectedRows: rep.affected, Status: rep.status}, nil
+	}
+	rs, err := mysql.BuildSimpleResultset(rep.names, rep.rows, binary)
+	if err != nil {
+		return nil, err
+	}
+	return mysql.NewResult(rs), nil
+}
+
+func TestFakeServer(t *testing.T) {
+	s := newFakeServer(t, func(q query) (*reply, error) {
+		if q.sql == "SELECT 1" {
+			return &reply{names: []string{"1"}, rows: [][]interface{}{{int64(1)}}}, nil
+		}
+		return nil, nil
+	})
+	c := NewClient(s.config())
+	defer c.Close()
+	r, err := c.Execute("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := r.GetInt(0, 0); v != 1 {
+		t.Errorf("SELECT 1 = %d, want 1", v)
+	}
+}
@@ -0,0 +1,320 @@
+package promwrite
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// WriteStats of the X-Prometheus-Remote-Write-*-Written response headers,
+// Confirmed is false when the receiver did not send them
+type WriteStats = remote.WriteResponseStats
+
+type MetricType = writev2.Metadata_MetricType
+
+const (
+	TypeUnknown        = writev2.Metadata_METRIC_TYPE_UNSPECIFIED
+	TypeCounter        = writev2.Metadata_METRIC_TYPE_COUNTER
+	TypeGauge          = writev2.Metadata_METRIC_TYPE_GAUGE
+	TypeHistogram      = writev2.Metadata_METRIC_TYPE_HISTOGRAM
+	TypeGaugeHistogram = writev2.Metadata_METRIC_TYPE_GAUGEHISTOGRAM
+	TypeSummary        = writev2.Metadata_METRIC_TYPE_SUMMARY
+	TypeInfo           = writev2.Metadata_METRIC_TYPE_INFO
+	TypeStateSet       = writev2.Metadata_METRIC_TYPE_STATESET
+)
+
+// MaxSeries and MaxBytes flush on Append, 0 is 2000 series and 1MiB of
+// uncompressed request. Interval flushes in the background, 0 disables it
+type BatchConfig struct {
+	MaxSeries int
+	MaxBytes  int
+	Interval  time.Duration
+	// errors of the background flush, default fmt.Println
+	OnError func(err error)
+}
+
+type BatchStats struct {
+	Requests   uint64
+	Failed     uint64
+	Series     uint64
+	Samples    uint64
+	Histograms uint64
+	Exemplars  uint64
+	// sum of the response headers
+	Written WriteStats
+	// series waiting for the next flush
+	Pending int
+}
+
+// Batch accumulates many series into one Remote-Write 2.0 request
+//
+//	b := m.NewBatch(promwrite.BatchConfig{Interval: 10 * time.Second})
+//	defer b.Close()
+//	a := b.Name("http_requests", "total", "requests served").Type(promwrite.TypeCounter)
+//	a.Label("code", "200").SetValue(42).Append()
+//	a.Label("code", "500").SetValue(1).Exemplar(1, 0, "trace_id", "4bf92f35").Append()
+type Batch struct {
+	m   *metric
+	cfg BatchConfig
+
+	mu     sync.Mutex
+	s      writev2.SymbolsTable
+	series []writev2.TimeSeries
+	// series of a labels hash, samples of the same series are merged
+	index map[uint64][]seriesRef
+	size  int
+	stats BatchStats
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (m *metric) NewBatch(config ...BatchConfig) *Batch {
+	var cfg BatchConfig
+	if len(config) == 1 {
+		cfg = config[0]
+	}
+	if cfg.MaxSeries <= 0 {
+		cfg.MaxSeries = 2000
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	if cfg.OnError == nil {
+		cfg.OnError = func(err error) { fmt.Println(err) }
+	}
+	b := &Batch{
+		m:     m,
+		cfg:   cfg,
+		s:     writev2.NewSymbolTable(),
+		index: map[uint64][]seriesRef{},
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if cfg.Interval > 0 {
+		go b.run()
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+func (b *Batch) run() {
+	defer close(b.done)
+	t := time.NewTicker(b.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+			if _, err := b.Flush(); err != nil {
+				b.cfg.OnError(err)
+			}
+		}
+	}
+}
+
+// Name starts an Appender of metric name, name_unit when unit is set
+func (b *Batch) Name(name, unit string, help ...string) *Appender {
+	_name := name
+	if unit != "" {
+		_name = name + "_" + unit
+	}
+	return &Appender{b: b, name: _name, unit: unit, help: strings.Join(help, " ")}
+}
+
+// Flush sends the pending series in one request
+func (b *Batch) Flush() (WriteStats, error) {
+	b.mu.Lock()
+	if len(b.series) == 0 {
+		b.mu.Unlock()
+		return WriteStats{}, nil
+	}
+	w := &WriteRequest{Symbols: b.s.Symbols(), Timeseries: b.series}
+	var samples, histograms, exemplars int
+	for _, ts := range b.series {
+		samples += len(ts.Samples)
+		histograms += len(ts.Histograms)
+		exemplars += len(ts.Exemplars)
+	}
+	data, err := w.Marshal()
+	b.s.Reset()
+	b.series = nil
+	b.index = map[uint64][]seriesRef{}
+	b.size = 0
+	b.mu.Unlock()
+	if err != nil {
+		return WriteStats{}, err
+	}
+
+	stats, err := b.m.send(snappy.Encode(nil, data))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Requests++
+	if err != nil {
+		b.stats.Failed++
+		return stats, err
+	}
+	b.stats.Series += uint64(len(w.Timeseries))
+	b.stats.Samples += uint64(samples)
+	b.stats.Histograms += uint64(histograms)
+	b.stats.Exemplars += uint64(exemplars)
+	b.stats.Written = b.stats.Written.Add(stats)
+	return stats, nil
+}
+
+func (b *Batch) Stats() BatchStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.stats
+	s.Pending = len(b.series)
+	return s
+}
+
+// Close stops the interval flush and sends the pending series
+func (b *Batch) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	<-b.done
+	_, err := b.Flush()
+	return err
+}
+
+// seriesRef the labels of b.series[i], a hash hit is only the same series when
+// the labels are equal
+type seriesRef struct {
+	labels labels.Labels
+	i      int
+}
+
+// add symbolizes one series, b.mu is held
+func (b *Batch) add(a *Appender, lbs labels.Labels) {
+	meta := writev2.Metadata{Type: a.typ, HelpRef: b.s.Symbolize(a.help), UnitRef: b.s.Symbolize(a.unit)}
+	exemplars := make([]writev2.Exemplar, len(a.exemplars))
+	for i, e := range a.exemplars {
+		exemplars[i] = writev2.Exemplar{LabelsRefs: b.s.SymbolizeLabels(e.labels, nil), Value: e.value, Timestamp: e.ts}
+	}
+
+	hash := lbs.Hash()
+	for _, ref := range b.index[hash] {
+		if !labels.Equal(ref.labels, lbs) {
+			continue
+		}
+		ts := &b.series[ref.i]
+		before := ts.Size()
+		ts.Samples = append(ts.Samples, a.samples...)
+		ts.Histograms = append(ts.Histograms, a.histograms...)
+		ts.Exemplars = append(ts.Exemplars, exemplars...)
+		ts.Metadata = meta
+		b.size += ts.Size() - before
+		return
+	}
+	ts := writev2.TimeSeries{
+		LabelsRefs: b.s.SymbolizeLabels(lbs, nil),
+		Samples:    append([]writev2.Sample(nil), a.samples...),
+		Histograms: append([]writev2.Histogram(nil), a.histograms...),
+		Exemplars:  exemplars,
+		Metadata:   meta,
+	}
+	b.index[hash] = append(b.index[hash], seriesRef{labels: lbs, i: len(b.series)})
+	b.series = append(b.series, ts)
+	b.size += ts.Size()
+	lbs.Range(func(l labels.Label) { b.size += len(l.Name) + len(l.Value) })
+}
+
+// Appender builds the series of one metric, it is reused after Append and not safe
+// for concurrent use, take one Appender per goroutine
+type Appender struct {
+	b          *Batch
+	name       string
+	unit       string
+	help       string
+	typ        MetricType
+	lables     labels.ScratchBuilder
+	samples    []writev2.Sample
+	histograms []writev2.Histogram
+	exemplars  []exemplar
+}
+
+type exemplar struct {
+	labels labels.Labels
+	value  float64
+	ts     int64
+}
+
+func (a *Appender) Type(t MetricType) *Appender {
+	a.typ = t
+	return a
+}
+
+func (a *Appender) Label(name, value string) *Appender {
+	a.lables.Add(name, value)
+	return a
+}
+
+func (a *Appender) SetValue(value float64, ts ...int64) *Appender {
+	a.samples = append(a.samples, writev2.Sample{Value: value, Timestamp: timestamp(ts)})
+	return a
+}
+
+// SetHistogram a native histogram sample
+func (a *Appender) SetHistogram(h *histogram.Histogram, ts ...int64) *Appender {
+	a.histograms = append(a.histograms, writev2.FromIntHistogram(timestamp(ts), h))
+	return a
+}
+
+func (a *Appender) SetFloatHistogram(h *histogram.FloatHistogram, ts ...int64) *Appender {
+	a.histograms = append(a.histograms, writev2.FromFloatHistogram(timestamp(ts), h))
+	return a
+}
+
+// Exemplar of the series, ts 0 is now, labels are name value pairs like "trace_id", "4bf92f35"
+func (a *Appender) Exemplar(value float64, ts int64, lbs ...string) *Appender {
+	if ts == 0 {
+		ts = time.Now().UnixMilli()
+	}
+	lbs = lbs[:len(lbs)&^1]
+	a.exemplars = append(a.exemplars, exemplar{labels: labels.FromStrings(lbs...), value: value, ts: ts})
+	return a
+}
+
+// Append adds the series to the batch and resets the labels and samples,
+// the batch is flushed when it is above MaxSeries or MaxBytes
+func (a *Appender) Append() error {
+	a.lables.Add("__name__", a.name)
+	a.lables.Sort()
+	lbs := a.lables.Labels()
+
+	b := a.b
+	b.mu.Lock()
+	b.add(a, lbs)
+	full := len(b.series) >= b.cfg.MaxSeries || b.size >= b.cfg.MaxBytes
+	b.mu.Unlock()
+
+	a.lables.Reset()
+	a.samples = a.samples[:0]
+	a.histograms = a.histograms[:0]
+	a.exemplars = a.exemplars[:0]
+
+	if full {
+		_, err := b.Flush()
+		return err
+	}
+	return nil
+}
+
+func timestamp(ts []int64) int64 {
+	if len(ts) == 1 {
+		return ts[0]
+	}
+	return time.Now().UnixMilli()
+}
@@ -0,0 +1,31 @@
+package promwrite
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+func TestBatchAddCollision(t *testing.T) {
+	b := &Batch{s: writev2.NewSymbolTable(), index: map[uint64][]seriesRef{}}
+	a := &Appender{b: b, name: "up"}
+	first := labels.FromStrings("__name__", "up", "job", "a")
+	second := labels.FromStrings("__name__", "up", "job", "b")
+
+	a.SetValue(1, 1000)
+	b.add(a, first)
+	// second lands in the bucket of first as if the hashes collided
+	b.index[second.Hash()] = b.index[first.Hash()]
+	b.add(a, second)
+	if len(b.series) != 2 {
+		t.Fatalf("series: got %d, want 2 for a hash collision", len(b.series))
+	}
+	b.add(a, second.Copy())
+	if got := len(b.series[1].Samples); got != 2 {
+		t.Errorf("samples of job b: got %d, want 2", got)
+	}
+	if got := len(b.series[0].Samples); got != 1 {
+		t.Errorf("samples of job a: got %d, want 1", got)
+	}
+}
@@ -0,0 +1,94 @@
+package promwrite
+
+import (
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+type Protocol int
+
+const (
+	// 2.0, and 1.0 from the first 415 Unsupported Media Type on
+	ProtocolAuto Protocol = iota
+	// io.prometheus.write.v2.Request
+	ProtocolV2
+	// prompb.WriteRequest, older VictoriaMetrics, Mimir, Thanos receive
+	ProtocolV1
+)
+
+func (p Protocol) contentType() string {
+	if p == ProtocolV1 {
+		return "application/x-protobuf"
+	}
+	return "application/x-protobuf;proto=io.prometheus.write.v2.Request"
+}
+
+func (p Protocol) version() string {
+	if p == ProtocolV1 {
+		return remote.RemoteWriteVersion1HeaderValue
+	}
+	return remote.RemoteWriteVersion20HeaderValue
+}
+
+// encode body, a snappy v2 request, for p
+func encode(body []byte, p Protocol) ([]byte, error) {
+	if p != ProtocolV1 {
+		return body, nil
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	var req writev2.Request
+	if err := req.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	data, err = toV1(&req).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// toV1 resolves the symbols, metadata is sent once per metric name
+func toV1(req *writev2.Request) *prompb.WriteRequest {
+	w := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries))}
+	var b labels.ScratchBuilder
+	seen := map[string]bool{}
+	for _, ts := range req.Timeseries {
+		lbs := ts.ToLabels(&b, req.Symbols)
+		s := prompb.TimeSeries{Labels: prompb.FromLabels(lbs, nil)}
+		for _, v := range ts.Samples {
+			s.Samples = append(s.Samples, prompb.Sample{Value: v.Value, Timestamp: v.Timestamp})
+		}
+		for _, h := range ts.Histograms {
+			if h.IsFloatHistogram() {
+				s.Histograms = append(s.Histograms, prompb.FromFloatHistogram(h.Timestamp, h.ToFloatHistogram()))
+				continue
+			}
+			s.Histograms = append(s.Histograms, prompb.FromIntHistogram(h.Timestamp, h.ToIntHistogram()))
+		}
+		for _, e := range ts.Exemplars {
+			ex := e.ToExemplar(&b, req.Symbols)
+			s.Exemplars = append(s.Exemplars, prompb.Exemplar{Labels: prompb.FromLabels(ex.Labels, nil), Value: ex.Value, Timestamp: ex.Ts})
+		}
+		w.Timeseries = append(w.Timeseries, s)
+
+		name := lbs.Get(labels.MetricName)
+		md := ts.Metadata
+		if seen[name] || md.Type == TypeUnknown && md.HelpRef == 0 && md.UnitRef == 0 {
+			continue
+		}
+		seen[name] = true
+		w.Metadata = append(w.Metadata, prompb.MetricMetadata{
+			Type:             prompb.MetricMetadata_MetricType(md.Type),
+			MetricFamilyName: name,
+			Help:             req.Symbols[md.HelpRef],
+			Unit:             req.Symbols[md.UnitRef],
+		})
+	}
+	return w
+}
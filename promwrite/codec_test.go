@@ -0,0 +1,146 @@
+package promwrite
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+func TestToV1(t *testing.T) {
+	h := &histogram.Histogram{Count: 2, Sum: 3, Schema: 0, ZeroThreshold: 0.001,
+		PositiveSpans: []histogram.Span{{Offset: 0, Length: 1}}, PositiveBuckets: []int64{2}}
+
+	tests := []struct {
+		name   string
+		series func(st *writev2.SymbolsTable) []writev2.TimeSeries
+		want   *prompb.WriteRequest
+	}{
+		{
+			name: "sample with metadata",
+			series: func(st *writev2.SymbolsTable) []writev2.TimeSeries {
+				return []writev2.TimeSeries{{
+					LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "up", "job", "a"), nil),
+					Samples:    []writev2.Sample{{Value: 1, Timestamp: 1000}},
+					Metadata:   writev2.Metadata{Type: TypeGauge, HelpRef: st.Symbolize("target up"), UnitRef: st.Symbolize("")},
+				}}
+			},
+			want: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+				}},
+				Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "target up"}},
+			},
+		},
+		{
+			name: "metadata once per name",
+			series: func(st *writev2.SymbolsTable) []writev2.TimeSeries {
+				md := writev2.Metadata{Type: TypeCounter, HelpRef: st.Symbolize("requests")}
+				return []writev2.TimeSeries{
+					{LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "req_total", "code", "200"), nil), Samples: []writev2.Sample{{Value: 5, Timestamp: 1}}, Metadata: md},
+					{LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "req_total", "code", "500"), nil), Samples: []writev2.Sample{{Value: 1, Timestamp: 1}}, Metadata: md},
+				}
+			},
+			want: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{Labels: []prompb.Label{{Name: "__name__", Value: "req_total"}, {Name: "code", Value: "200"}}, Samples: []prompb.Sample{{Value: 5, Timestamp: 1}}},
+					{Labels: []prompb.Label{{Name: "__name__", Value: "req_total"}, {Name: "code", Value: "500"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 1}}},
+				},
+				Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "req_total", Help: "requests"}},
+			},
+		},
+		{
+			name: "no metadata",
+			series: func(st *writev2.SymbolsTable) []writev2.TimeSeries {
+				return []writev2.TimeSeries{{
+					LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "up"), nil),
+					Samples:    []writev2.Sample{{Value: 0, Timestamp: 2}},
+				}}
+			},
+			want: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 0, Timestamp: 2}}}},
+			},
+		},
+		{
+			name: "histogram and exemplar",
+			series: func(st *writev2.SymbolsTable) []writev2.TimeSeries {
+				return []writev2.TimeSeries{{
+					LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "latency"), nil),
+					Histograms: []writev2.Histogram{writev2.FromIntHistogram(3000, h)},
+					Exemplars:  []writev2.Exemplar{{LabelsRefs: st.SymbolizeLabels(labels.FromStrings("trace_id", "4bf9"), nil), Value: 1.5, Timestamp: 3000}},
+				}}
+			},
+			want: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{{
+					Labels:     []prompb.Label{{Name: "__name__", Value: "latency"}},
+					Histograms: []prompb.Histogram{prompb.FromIntHistogram(3000, h)},
+					Exemplars:  []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "4bf9"}}, Value: 1.5, Timestamp: 3000}},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := writev2.NewSymbolTable()
+			series := tt.series(&st)
+			req := &writev2.Request{Timeseries: series, Symbols: st.Symbols()}
+			got := toV1(req)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toV1:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	st := writev2.NewSymbolTable()
+	req := &writev2.Request{Timeseries: []writev2.TimeSeries{{
+		LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "up"), nil),
+		Samples:    []writev2.Sample{{Value: 1, Timestamp: 1}},
+	}}, Symbols: st.Symbols()}
+	data, err := req.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := snappy.Encode(nil, data)
+
+	tests := []struct {
+		name     string
+		protocol Protocol
+		v1       bool
+	}{
+		{name: "auto", protocol: ProtocolAuto},
+		{name: "v2", protocol: ProtocolV2},
+		{name: "v1", protocol: ProtocolV1, v1: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encode(body, tt.protocol)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if !tt.v1 {
+				if !reflect.DeepEqual(got, body) {
+					t.Errorf("v2 body was changed")
+				}
+				return
+			}
+			var w prompb.WriteRequest
+			data, err := snappy.Decode(nil, got)
+			if err != nil {
+				t.Fatalf("snappy: %v", err)
+			}
+			if err := w.Unmarshal(data); err != nil {
+				t.Fatalf("unmarshal v1: %v", err)
+			}
+			if len(w.Timeseries) != 1 || w.Timeseries[0].Labels[0].Value != "up" {
+				t.Errorf("v1 request: got %+v", w)
+			}
+		})
+	}
+}
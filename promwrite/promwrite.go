@@ -5,13 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"time"
+	"net/http"
+	"sync"
 
-	"github.com/golang/snappy"
-	"github.com/prometheus/prometheus/model/labels"
 	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
 	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/zhujintao/kit-go/ssh"
+	"github.com/zhujintao/kit-go/utils/spool"
 	"resty.dev/v3"
 )
 
@@ -19,21 +19,36 @@ type WriteRequest = writev2.Request
 type HttpRequest = resty.Request
 
 type metric struct {
-	cli *HttpRequest
+	// mu guards cli, one request reused by every send
+	mu       sync.Mutex
+	cli      *HttpRequest
+	spool    *spool.Spool
+	protocol Protocol
+	// a 415 of ProtocolAuto, 1.0 is sent from then on
+	fallback bool
 }
+
+// label sends one series per request, Batch groups many
 type label struct {
-	m       *metric
-	name    string
-	uint    string
-	help    string
-	s       writev2.SymbolsTable
-	lables  labels.ScratchBuilder
-	samples []writev2.Sample
+	b *Batch
+	a *Appender
 }
 
 // v1 /api/v1/write, viaSsh via ssh
 func NewMetric(url string, user, password string, viaSsh ...string) *metric {
 
+	s := newClient(user, password, viaSsh...).R().
+		SetHeader("Content-Encoding", "snappy").
+		SetHeader("User-Agent", "promwrite/0.0.1").
+		SetURL(url)
+
+	return &metric{cli: s}
+
+}
+
+// newClient with basic auth, dialing through ssh when viaSsh is addr, user, password
+func newClient(user, password string, viaSsh ...string) *resty.Client {
+
 	var sshaddr, sshuser, sshpasswd string
 	if len(viaSsh) == 3 {
 		sshaddr = viaSsh[0]
@@ -54,87 +69,129 @@ func NewMetric(url string, user, password string, viaSsh ...string) *metric {
 			return sshcli.Dial(network, addr)
 		}
 	}
+	return cli
+}
 
-	s := cli.R().
-		SetHeader("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v2.Request").
-		SetHeader("Content-Encoding", "snappy").
-		SetHeader(remote.RemoteWriteVersionHeader, remote.RemoteWriteVersion20HeaderValue).
-		SetHeader("User-Agent", "promwrite/0.0.1").
-		SetURL(url)
-
-	return &metric{cli: s}
+// SetProtocol default ProtocolAuto
+func (m *metric) SetProtocol(p Protocol) *metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.protocol = p
+	m.fallback = false
+	return m
+}
 
+func (l *label) Type(t MetricType) *label {
+	l.a.Type(t)
+	return l
 }
 
 func (l *label) Label(name, value string) *label {
 
-	l.lables.Add(name, value)
+	l.a.Label(name, value)
 
 	return l
 }
 func (l *label) SetValue(value float64, ts ...int64) *label {
-	_ts := time.Now().UnixMilli()
 
-	if len(ts) == 1 {
-		_ts = ts[0]
-	}
-	l.samples = append(l.samples, writev2.Sample{Value: value, Timestamp: _ts})
+	l.a.SetValue(value, ts...)
 
 	return l
 
 }
 func (l *label) Send() error {
 
-	l.lables.Add("__name__", l.name)
-	l.lables.Sort()
-	ref := l.s.SymbolizeLabels(l.lables.Labels(), nil)
-	uint := l.s.Symbolize(l.uint)
-	help := l.s.Symbolize(l.help)
-
-	w := &WriteRequest{}
-	w.Symbols = l.s.Symbols()
-	w.Timeseries = []writev2.TimeSeries{{
-		LabelsRefs: ref,
-		Samples:    l.samples,
-		Metadata:   writev2.Metadata{HelpRef: help, UnitRef: uint},
-	}}
-
-	data, err := w.Marshal()
-	if err != nil {
-		fmt.Println(err)
+	if err := l.a.Append(); err != nil {
 		return err
 	}
-	l.s.Reset()
-	l.lables.Reset()
+	_, err := l.b.Flush()
+	return err
+}
 
-	l.samples = l.samples[:0]
+// SetSpool bodies failing with a network error, 429 or 5xx are written to s and
+// replayed in order before the next Send
+func (m *metric) SetSpool(s *spool.Spool) *metric {
+	m.spool = s
+	return m
+}
 
-	resutl, err := l.m.cli.SetBody(snappy.Encode(nil, data)).Post(l.m.cli.URL)
-	if err != nil {
-		fmt.Println(err)
-		return err
+// send stats are zero when the body is spooled
+func (m *metric) send(body []byte) (WriteStats, error) {
+	if m.spool != nil && m.spool.Len() > 0 {
+		err := m.spool.Replay(func(record []byte) error {
+			_, retry, err := m.post(record)
+			if err != nil && !retry {
+				fmt.Println("drop spooled:", err)
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return WriteStats{}, m.write(body, err)
+		}
 	}
-	if resutl.StatusCode() != 204 {
-		fmt.Println(resutl.Status(), resutl.String())
-		return errors.New(resutl.String())
+	stats, retry, err := m.post(body)
+	if err != nil && retry && m.spool != nil {
+		return WriteStats{}, m.write(body, err)
+	}
+	return stats, err
+}
 
+// write spools body after a failed send
+func (m *metric) write(body []byte, sendErr error) error {
+	if err := m.spool.Write(body); err != nil {
+		fmt.Println(err)
+		return sendErr
 	}
+	fmt.Println("spooled:", sendErr)
 	return nil
 }
 
-func (m *metric) Name(name, unit string, help ...string) *label {
-
-	_name := name
-	if unit != "" {
-		_name = name + "_" + unit
+// post reports whether a failure is worth a retry, body is a snappy v2 request
+func (m *metric) post(body []byte) (WriteStats, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		p := m.protocol
+		if p == ProtocolAuto {
+			p = ProtocolV2
+			if m.fallback {
+				p = ProtocolV1
+			}
+		}
+		data, err := encode(body, p)
+		if err != nil {
+			fmt.Println(err)
+			return WriteStats{}, false, err
+		}
+		resutl, err := m.cli.
+			SetHeader("Content-Type", p.contentType()).
+			SetHeader(remote.RemoteWriteVersionHeader, p.version()).
+			SetBody(data).Post(m.cli.URL)
+		if err != nil {
+			fmt.Println(err)
+			return WriteStats{}, true, err
+		}
+		if resutl.StatusCode() == http.StatusUnsupportedMediaType && m.protocol == ProtocolAuto && !m.fallback {
+			fmt.Println("remote write 2.0 unsupported, fallback to 1.0:", resutl.String())
+			m.fallback = true
+			continue
+		}
+		if resutl.StatusCode()/100 != 2 {
+			fmt.Println(resutl.Status(), resutl.String())
+			return WriteStats{}, resutl.StatusCode() == 429 || resutl.StatusCode() >= 500, errors.New(resutl.String())
+		}
+		stats, err := remote.ParseWriteResponseStats(resutl.RawResponse)
+		if err != nil {
+			fmt.Println(err)
+		}
+		return stats, false, nil
 	}
+}
 
-	return &label{
-		m:      m,
-		s:      writev2.NewSymbolTable(),
-		lables: labels.ScratchBuilder{},
-		name:   _name,
-		uint:   unit,
-	}
+func (m *metric) Name(name, unit string, help ...string) *label {
+
+	b := m.NewBatch()
+	return &label{b: b, a: b.Name(name, unit, help...)}
 
 }
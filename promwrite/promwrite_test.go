@@ -0,0 +1,76 @@
+package promwrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+func TestFallback(t *testing.T) {
+	v2 := ProtocolV2.contentType()
+	v1 := ProtocolV1.contentType()
+
+	tests := []struct {
+		name     string
+		protocol Protocol
+		// content types the receiver accepts
+		accept []string
+		// content types of the requests of two sends
+		want    []string
+		wantErr bool
+	}{
+		{name: "auto v2 receiver", protocol: ProtocolAuto, accept: []string{v2, v1}, want: []string{v2, v2}},
+		{name: "auto v1 receiver", protocol: ProtocolAuto, accept: []string{v1}, want: []string{v2, v1, v1}},
+		{name: "v2 only", protocol: ProtocolV2, accept: []string{v1}, want: []string{v2, v2}, wantErr: true},
+		{name: "v1", protocol: ProtocolV1, accept: []string{v1}, want: []string{v1, v1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var got []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ct := r.Header.Get("Content-Type")
+				mu.Lock()
+				got = append(got, ct)
+				mu.Unlock()
+				if r.Header.Get("Content-Encoding") != "snappy" {
+					t.Errorf("Content-Encoding: got %s, want snappy", r.Header.Get("Content-Encoding"))
+				}
+				want := ProtocolV2.version()
+				if ct == v1 {
+					want = ProtocolV1.version()
+				}
+				if got := r.Header.Get(remote.RemoteWriteVersionHeader); got != want {
+					t.Errorf("%s: got %s, want %s", remote.RemoteWriteVersionHeader, got, want)
+				}
+				for _, a := range tt.accept {
+					if a == ct {
+						w.WriteHeader(http.StatusNoContent)
+						return
+					}
+				}
+				http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			}))
+			defer srv.Close()
+
+			m := NewMetric(srv.URL, "", "").SetProtocol(tt.protocol)
+			for i := 0; i < 2; i++ {
+				err := m.Name("up", "").Type(TypeGauge).Label("job", "test").SetValue(1).Send()
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Send: got %v, want error %v", err, tt.wantErr)
+				}
+				if err != nil && !strings.Contains(err.Error(), "unsupported") {
+					t.Errorf("Send: unexpected error %v", err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requests:\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package promwrite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"resty.dev/v3"
+)
+
+// QueryClient reads back from prometheus, the http api /api/v1/query, query_range,
+// series, labels and the remote read /api/v1/read
+type QueryClient struct {
+	cli *resty.Client
+	url string
+}
+
+// QueryResult one of Vector, Matrix, Scalar, String by Type
+type QueryResult struct {
+	Type     model.ValueType
+	Vector   model.Vector
+	Matrix   model.Matrix
+	Scalar   *model.Scalar
+	String   *model.String
+	Warnings []string
+}
+
+// APIError of a non 2xx response, ErrorType bad_data, timeout, execution ...
+type APIError struct {
+	StatusCode int
+	ErrorType  string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("prometheus %d %s: %s", e.StatusCode, e.ErrorType, e.Message)
+}
+
+type QueryOption func(v url.Values)
+
+// WithTimeout evaluation timeout of the server
+func WithTimeout(d time.Duration) QueryOption {
+	return func(v url.Values) { v.Set("timeout", model.Duration(d).String()) }
+}
+
+// WithLimit max series returned, prometheus 2.54+
+func WithLimit(n int) QueryOption {
+	return func(v url.Values) { v.Set("limit", strconv.Itoa(n)) }
+}
+
+// WithMatch series selectors of Labels and LabelValues
+func WithMatch(match ...string) QueryOption {
+	return func(v url.Values) { v["match[]"] = append(v["match[]"], match...) }
+}
+
+func WithParam(key, value string) QueryOption {
+	return func(v url.Values) { v.Set(key, value) }
+}
+
+// url http://prometheus:9090, viaSsh via ssh
+func NewQueryClient(url string, user, password string, viaSsh ...string) *QueryClient {
+	cli := newClient(user, password, viaSsh...).SetHeader("User-Agent", "promwrite/0.0.1")
+	return &QueryClient{cli: cli, url: strings.TrimRight(url, "/")}
+}
+
+// Query instant query at ts, zero ts is now
+func (c *QueryClient) Query(ctx context.Context, query string, ts time.Time, opts ...QueryOption) (*QueryResult, error) {
+	v := url.Values{"query": {query}}
+	if !ts.IsZero() {
+		v.Set("time", formatTime(ts))
+	}
+	return c.query(ctx, "/api/v1/query", v, opts)
+}
+
+func (c *QueryClient) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration, opts ...QueryOption) (*QueryResult, error) {
+	v := url.Values{
+		"query": {query},
+		"start": {formatTime(start)},
+		"end":   {formatTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	}
+	return c.query(ctx, "/api/v1/query_range", v, opts)
+}
+
+// Series label sets matching any of match
+func (c *QueryClient) Series(ctx context.Context, start, end time.Time, match ...string) ([]model.LabelSet, error) {
+	v := url.Values{"match[]": match}
+	setRange(v, start, end)
+	var data []model.LabelSet
+	_, err := c.post(ctx, "/api/v1/series", v, &data)
+	return data, err
+}
+
+func (c *QueryClient) Labels(ctx context.Context, start, end time.Time, opts ...QueryOption) ([]string, error) {
+	v := url.Values{}
+	setRange(v, start, end)
+	var data []string
+	_, err := c.post(ctx, "/api/v1/labels", apply(v, opts), &data)
+	return data, err
+}
+
+func (c *QueryClient) LabelValues(ctx context.Context, name string, start, end time.Time, opts ...QueryOption) ([]string, error) {
+	v := url.Values{}
+	setRange(v, start, end)
+	var data []string
+	resp, err := c.cli.R().SetContext(ctx).SetQueryParamsFromValues(apply(v, opts)).
+		Get(c.url + "/api/v1/label/" + url.PathEscape(name) + "/values")
+	if err != nil {
+		return nil, err
+	}
+	_, err = decode(resp, &data)
+	return data, err
+}
+
+func (c *QueryClient) query(ctx context.Context, path string, v url.Values, opts []QueryOption) (*QueryResult, error) {
+	var data struct {
+		ResultType model.ValueType `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	warnings, err := c.post(ctx, path, apply(v, opts), &data)
+	if err != nil {
+		return nil, err
+	}
+	r := &QueryResult{Type: data.ResultType, Warnings: warnings}
+	switch data.ResultType {
+	case model.ValVector:
+		err = json.Unmarshal(data.Result, &r.Vector)
+	case model.ValMatrix:
+		err = json.Unmarshal(data.Result, &r.Matrix)
+	case model.ValScalar:
+		r.Scalar = &model.Scalar{}
+		err = json.Unmarshal(data.Result, r.Scalar)
+	case model.ValString:
+		r.String = &model.String{}
+		err = json.Unmarshal(data.Result, r.String)
+	default:
+		err = fmt.Errorf("unknown result type %q", data.ResultType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// post form encoded, queries longer than an url are fine
+func (c *QueryClient) post(ctx context.Context, path string, v url.Values, data any) ([]string, error) {
+	resp, err := c.cli.R().SetContext(ctx).SetFormDataFromValues(v).Post(c.url + path)
+	if err != nil {
+		return nil, err
+	}
+	return decode(resp, data)
+}
+
+// decode the api envelope {"status","data","errorType","error","warnings"}
+func decode(resp *resty.Response, data any) ([]string, error) {
+	var r struct {
+		Status    string          `json:"status"`
+		Data      json.RawMessage `json:"data"`
+		ErrorType string          `json:"errorType"`
+		Error     string          `json:"error"`
+		Warnings  []string        `json:"warnings"`
+	}
+	if err := json.Unmarshal(resp.Bytes(), &r); err != nil {
+		if !resp.IsSuccess() {
+			return nil, &APIError{StatusCode: resp.StatusCode(), Message: strings.TrimSpace(resp.String())}
+		}
+		return nil, err
+	}
+	if r.Status != "success" {
+		return r.Warnings, &APIError{StatusCode: resp.StatusCode(), ErrorType: r.ErrorType, Message: r.Error}
+	}
+	return r.Warnings, json.Unmarshal(r.Data, data)
+}
+
+func apply(v url.Values, opts []QueryOption) url.Values {
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func setRange(v url.Values, start, end time.Time) {
+	if !start.IsZero() {
+		v.Set("start", formatTime(start))
+	}
+	if !end.IsZero() {
+		v.Set("end", formatTime(end))
+	}
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1e3, 'f', -1, 64)
+}
+
+// Read remote read of selector, {job="node",instance=~"10.0.*"}, between start and end.
+// Streamed xor chunks are asked first, prometheus 2.13+, samples otherwise
+func (c *QueryClient) Read(ctx context.Context, selector string, start, end time.Time) (model.Matrix, error) {
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	q, err := remote.ToQuery(start.UnixMilli(), end.UnixMilli(), matchers, nil)
+	if err != nil {
+		return nil, err
+	}
+	req := &prompb.ReadRequest{
+		Queries:               []*prompb.Query{q},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS, prompb.ReadRequest_SAMPLES},
+	}
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.cli.R().SetContext(ctx).SetDoNotParseResponse(true).
+		SetHeader("Content-Type", "application/x-protobuf").
+		SetHeader("Content-Encoding", "snappy").
+		SetHeader("X-Prometheus-Remote-Read-Version", "0.1.0").
+		SetBody(snappy.Encode(nil, data)).
+		Post(c.url + "/api/v1/read")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !resp.IsSuccess() {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, &APIError{StatusCode: resp.StatusCode(), Message: strings.TrimSpace(string(body))}
+	}
+
+	if strings.HasPrefix(resp.Header().Get("Content-Type"), "application/x-streamed-protobuf") {
+		return readChunked(resp.Body, q.StartTimestampMs, q.EndTimestampMs)
+	}
+	return readSamples(resp.Body)
+}
+
+// readChunked decodes prometheus.ChunkedReadResponse frames, chunks may overlap
+// and cover more than the query range
+func readChunked(r io.Reader, mint, maxt int64) (model.Matrix, error) {
+	cr := remote.NewChunkedReader(r, config.DefaultChunkedReadLimit, nil)
+	var m model.Matrix
+	for {
+		var res prompb.ChunkedReadResponse
+		err := cr.NextProto(&res)
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, cs := range res.ChunkedSeries {
+			ss := &model.SampleStream{Metric: toMetric(cs.Labels)}
+			last := int64(-1 << 63)
+			// a series longer than one frame is sent again with the next chunks
+			if n := len(m); n > 0 && m[n-1].Metric.Equal(ss.Metric) {
+				ss = m[n-1]
+				last = lastTimestamp(ss)
+			} else {
+				m = append(m, ss)
+			}
+			for _, chk := range cs.Chunks {
+				c, err := chunkenc.FromData(chunkenc.Encoding(chk.Type), chk.Data)
+				if err != nil {
+					return nil, err
+				}
+				it := c.Iterator(nil)
+				for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+					t := it.AtT()
+					if t <= last || t < mint || t > maxt {
+						continue
+					}
+					last = t
+					if vt == chunkenc.ValFloat {
+						_, v := it.At()
+						ss.Values = append(ss.Values, model.SamplePair{Timestamp: model.Time(t), Value: model.SampleValue(v)})
+						continue
+					}
+					_, fh := it.AtFloatHistogram(nil)
+					ss.Histograms = append(ss.Histograms, model.SampleHistogramPair{Timestamp: model.Time(t), Histogram: toSampleHistogram(fh)})
+				}
+				if err := it.Err(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+// lastTimestamp of the samples of ss read so far
+func lastTimestamp(ss *model.SampleStream) int64 {
+	last := int64(-1 << 63)
+	if n := len(ss.Values); n > 0 {
+		last = int64(ss.Values[n-1].Timestamp)
+	}
+	if n := len(ss.Histograms); n > 0 && int64(ss.Histograms[n-1].Timestamp) > last {
+		last = int64(ss.Histograms[n-1].Timestamp)
+	}
+	return last
+}
+
+func readSamples(r io.Reader) (model.Matrix, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	var res prompb.ReadResponse
+	if err := res.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	var m model.Matrix
+	for _, qr := range res.Results {
+		for _, ts := range qr.Timeseries {
+			ss := &model.SampleStream{Metric: toMetric(ts.Labels)}
+			for _, s := range ts.Samples {
+				ss.Values = append(ss.Values, model.SamplePair{Timestamp: model.Time(s.Timestamp), Value: model.SampleValue(s.Value)})
+			}
+			for _, h := range ts.Histograms {
+				ss.Histograms = append(ss.Histograms, model.SampleHistogramPair{Timestamp: model.Time(h.Timestamp), Histogram: toSampleHistogram(h.ToFloatHistogram())})
+			}
+			m = append(m, ss)
+		}
+	}
+	return m, nil
+}
+
+func toMetric(lbs []prompb.Label) model.Metric {
+	m := make(model.Metric, len(lbs))
+	for _, l := range lbs {
+		m[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return m
+}
+
+// toSampleHistogram the buckets of the http api, boundaries 0 (a,b] 1 [a,b) 2 (a,b) 3 [a,b]
+func toSampleHistogram(fh *histogram.FloatHistogram) *model.SampleHistogram {
+	h := &model.SampleHistogram{Count: model.FloatString(fh.Count), Sum: model.FloatString(fh.Sum)}
+	it := fh.AllBucketIterator()
+	for it.Next() {
+		b := it.At()
+		if b.Count == 0 {
+			continue
+		}
+		boundaries := int32(2)
+		switch {
+		case b.LowerInclusive && b.UpperInclusive:
+			boundaries = 3
+		case b.LowerInclusive:
+			boundaries = 1
+		case b.UpperInclusive:
+			boundaries = 0
+		}
+		h.Buckets = append(h.Buckets, &model.HistogramBucket{
+			Boundaries: boundaries,
+			Lower:      model.FloatString(b.Lower),
+			Upper:      model.FloatString(b.Upper),
+			Count:      model.FloatString(b.Count),
+		})
+	}
+	return h
+}
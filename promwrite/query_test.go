@@ -0,0 +1,143 @@
+package promwrite
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+func TestQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/api/v1/query":
+			if got := r.PostForm.Get("query"); got != "up" {
+				t.Errorf("query: got %s, want up", got)
+			}
+			if got := r.PostForm.Get("limit"); got != "10" {
+				t.Errorf("limit: got %s, want 10", got)
+			}
+			w.Write([]byte(`{"status":"success","warnings":["w"],"data":{"resultType":"vector","result":[{"metric":{"job":"node"},"value":[1700000000,"1"]}]}}`))
+		case "/api/v1/query_range":
+			if got := r.PostForm.Get("step"); got != "15" {
+				t.Errorf("step: got %s, want 15", got)
+			}
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"node"},"values":[[1700000000,"1"],[1700000015,"2"]]}]}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+		}
+	}))
+	defer srv.Close()
+	c := NewQueryClient(srv.URL, "", "")
+	ctx := context.Background()
+
+	r, err := c.Query(ctx, "up", time.Time{}, WithLimit(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != model.ValVector || len(r.Vector) != 1 || r.Vector[0].Metric["job"] != "node" || r.Vector[0].Value != 1 {
+		t.Errorf("Query: got %v %v, want the vector of job node", r.Type, r.Vector)
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("warnings: got %v, want [w]", r.Warnings)
+	}
+
+	r, err = c.QueryRange(ctx, "up", time.Unix(1700000000, 0), time.Unix(1700000015, 0), 15*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != model.ValMatrix || len(r.Matrix) != 1 || len(r.Matrix[0].Values) != 2 {
+		t.Errorf("QueryRange: got %v %v, want a matrix of 2 values", r.Type, r.Matrix)
+	}
+
+	_, err = c.Labels(ctx, time.Time{}, time.Time{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.ErrorType != "bad_data" {
+		t.Errorf("Labels: got %v, want a bad_data APIError", err)
+	}
+}
+
+// xorChunk of the samples at ts with value ts/1000
+func xorChunk(t *testing.T, ts ...int64) prompb.Chunk {
+	t.Helper()
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range ts {
+		app.Append(v, float64(v/1000))
+	}
+	return prompb.Chunk{MinTimeMs: ts[0], MaxTimeMs: ts[len(ts)-1], Type: prompb.Chunk_XOR, Data: c.Bytes()}
+}
+
+func TestReadChunked(t *testing.T) {
+	lbs := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}}
+	// the series spans two frames, the second chunk overlaps the first
+	frames := []prompb.ChunkedReadResponse{
+		{ChunkedSeries: []*prompb.ChunkedSeries{{Labels: lbs, Chunks: []prompb.Chunk{xorChunk(t, 1000, 2000, 3000)}}}},
+		{ChunkedSeries: []*prompb.ChunkedSeries{{Labels: lbs, Chunks: []prompb.Chunk{xorChunk(t, 3000, 4000, 5000)}}}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+		cw := remote.NewChunkedWriter(w, w.(http.Flusher))
+		for i := range frames {
+			b, err := proto.Marshal(&frames[i])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			cw.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	m, err := NewQueryClient(srv.URL, "", "").Read(context.Background(), `{job="node"}`, time.UnixMilli(2000), time.UnixMilli(4000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 {
+		t.Fatalf("series: got %d, want 1", len(m))
+	}
+	want := []model.SamplePair{{Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}, {Timestamp: 4000, Value: 4}}
+	if len(m[0].Values) != len(want) {
+		t.Fatalf("values: got %v, want %v", m[0].Values, want)
+	}
+	for i := range want {
+		if !m[0].Values[i].Equal(&want[i]) {
+			t.Errorf("value %d: got %v, want %v", i, m[0].Values[i], want[i])
+		}
+	}
+}
+
+func TestReadSamples(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := &prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: []*prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+		}}}}}
+		b, _ := res.Marshal()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(snappy.Encode(nil, b))
+	}))
+	defer srv.Close()
+
+	m, err := NewQueryClient(srv.URL, "", "").Read(context.Background(), "up", time.UnixMilli(0), time.UnixMilli(2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || m[0].Metric["__name__"] != "up" || len(m[0].Values) != 1 || m[0].Values[0].Value != 1 {
+		t.Errorf("Read: got %v, want up 1 @1000", m)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 
 	"github.com/robertkrimen/otto"
 	"github.com/zhujintao/kit-go/rocketmq/client"
@@ -163,7 +165,10 @@ func (ns *nameserver) GetTopicConsumeGroupList(topic string) []string {
 	//ReqQueryTopicConsumeByWho
 	cmd := remote.NewRemotingCommand(int16(300), NewCustomHeader("topic", topic), nil)
 	response, err := ns.remote.InvokeSync(ns.ctx, ns.brokerAddr(), cmd)
-	fmt.Println(string(response.Body), err)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
 	var data map[string][]string
 	json.Unmarshal(response.Body, &data)
 
@@ -208,6 +213,61 @@ func (ns *nameserver) GetConsumeStats(group, topic string) {
 	fmt.Println(string(response.Body), err)
 }
 
+// QueueOffset of a consumer group on one message queue
+type QueueOffset struct {
+	BrokerName     string
+	QueueId        int
+	Topic          string
+	BrokerOffset   int64
+	ConsumerOffset int64
+}
+
+// Lag messages not consumed yet
+func (q QueueOffset) Lag() int64 {
+	return max(q.BrokerOffset-q.ConsumerOffset, 0)
+}
+
+// the offsetTable keys are objects, not json, fastjson writes the fields sorted
+var offsetTableRe = regexp.MustCompile(`\{"brokerName":"([^"]*)","queueId":(\d+),"topic":"([^"]*)"\}:\{"brokerOffset":(\d+),"consumerOffset":(\d+)`)
+
+// ConsumeOffsets of group on topic from every master broker
+func (ns *nameserver) ConsumeOffsets(group, topic string) ([]QueueOffset, error) {
+	if len(ns.broker) == 0 {
+		ns.GetClusterInfo()
+	}
+	var list []QueueOffset
+	for _, b := range ns.broker {
+		addr, ok := b.BrokerAddresses[0]
+		if !ok {
+			continue
+		}
+		//ReqGetConsumeStats
+		cmd := remote.NewRemotingCommand(int16(208), NewCustomHeader("consumerGroup", group).Add("topic", topic), nil)
+		response, err := ns.remote.InvokeSync(ns.ctx, addr, cmd)
+		if err != nil {
+			return nil, err
+		}
+		if response.Code != 0 {
+			return nil, fmt.Errorf("consume stats %s %s: %s", group, topic, response.Remark)
+		}
+		list = append(list, parseOffsetTable(response.Body)...)
+	}
+	return list, nil
+}
+
+// parseOffsetTable the queues of a ConsumeStats body
+func parseOffsetTable(body []byte) []QueueOffset {
+	var list []QueueOffset
+	for _, m := range offsetTableRe.FindAllStringSubmatch(string(body), -1) {
+		q := QueueOffset{BrokerName: m[1], Topic: m[3]}
+		q.QueueId, _ = strconv.Atoi(m[2])
+		q.BrokerOffset, _ = strconv.ParseInt(m[4], 10, 64)
+		q.ConsumerOffset, _ = strconv.ParseInt(m[5], 10, 64)
+		list = append(list, q)
+	}
+	return list
+}
+
 func (ns *nameserver) GetConsumerRunningInfo(group, clientId string) {
 	//ReqGetConsumerRunningInfo
 	cmd := remote.NewRemotingCommand(int16(307), NewCustomHeader("consumerGroup", group).Add("clientId", clientId).Add("jstackEnable", "false"), nil)
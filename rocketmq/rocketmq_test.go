@@ -0,0 +1,24 @@
+package rocketmq
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOffsetTable(t *testing.T) {
+	// ConsumeStats of fastjson, the keys of offsetTable are objects
+	body := `{"consumeTps":0.0,"offsetTable":{{"brokerName":"broker-a","queueId":0,"topic":"orders"}:{"brokerOffset":120,"consumerOffset":100,"lastTimestamp":1700000000000},` +
+		`{"brokerName":"broker-a","queueId":1,"topic":"orders"}:{"brokerOffset":50,"consumerOffset":60,"lastTimestamp":0}}}`
+	got := parseOffsetTable([]byte(body))
+	want := []QueueOffset{
+		{BrokerName: "broker-a", QueueId: 0, Topic: "orders", BrokerOffset: 120, ConsumerOffset: 100},
+		{BrokerName: "broker-a", QueueId: 1, Topic: "orders", BrokerOffset: 50, ConsumerOffset: 60},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseOffsetTable: got %+v, want %+v", got, want)
+	}
+	// the consumer offset is ahead after a broker offset reset
+	if lag := []int64{got[0].Lag(), got[1].Lag()}; lag[0] != 20 || lag[1] != 0 {
+		t.Errorf("Lag: got %v, want [20 0]", lag)
+	}
+}
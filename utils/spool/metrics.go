@@ -0,0 +1,40 @@
+package spool
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// WriteMetrics the Stats of spools in the prometheus text format, labeled by Dir
+func WriteMetrics(w io.Writer, spools ...*Spool) {
+	stats := make([]Stats, len(spools))
+	for i, s := range spools {
+		stats[i] = s.Stats()
+	}
+	metrics := []struct {
+		name, typ, help string
+		value           func(st Stats) float64
+	}{
+		{"spool_segments", "gauge", "segment files", func(st Stats) float64 { return float64(st.Segments) }},
+		{"spool_bytes", "gauge", "bytes of all segments", func(st Stats) float64 { return float64(st.Bytes) }},
+		{"spool_records", "gauge", "records waiting to be replayed", func(st Stats) float64 { return float64(st.Records) }},
+		{"spool_evicted_records_total", "counter", "records lost to MaxSize, MaxAge or a corrupt segment", func(st Stats) float64 { return float64(st.Evicted) }},
+		{"spool_written_records_total", "counter", "records written since Open", func(st Stats) float64 { return float64(st.Written) }},
+		{"spool_replayed_records_total", "counter", "records replayed since Open", func(st Stats) float64 { return float64(st.Replayed) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for i, st := range stats {
+			fmt.Fprintf(w, "%s{dir=%q} %g\n", m.name, spools[i].cfg.Dir, m.value(st))
+		}
+	}
+}
+
+// Handler serves WriteMetrics of spools
+func Handler(spools ...*Spool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w, spools...)
+	})
+}
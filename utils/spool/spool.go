@@ -0,0 +1,382 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentExt = ".seg"
+
+// Config Dir is created, one spool per directory
+type Config struct {
+	Dir string
+	// bytes of a segment file, default 8MiB
+	SegmentSize int64
+	// bytes of all segments, the oldest are deleted above it, default 256MiB
+	MaxSize int64
+	// segments older are deleted, default 24h
+	MaxAge time.Duration
+}
+
+type Stats struct {
+	Segments int
+	Bytes    int64
+	Records  int
+	// records lost to MaxSize, MaxAge or a corrupt segment
+	Evicted int
+	// records written and replayed since Open
+	Written  uint64
+	Replayed uint64
+}
+
+type segment struct {
+	path    string
+	seq     uint64
+	size    int64
+	records int
+	created time.Time
+	// records already replayed, not persisted, a restart replays them again
+	replayed int
+}
+
+// Spool is an on-disk queue of records in segment files, written when a send
+// fails and replayed in order when the endpoint is back. Delivery is at least once.
+//
+//	s, _ := spool.Open(spool.Config{Dir: "/var/spool/loki"})
+//	if err := post(body); err != nil { s.Write(body) }
+//	s.Replay(post)
+type Spool struct {
+	cfg Config
+
+	mu       sync.Mutex
+	segments []*segment
+	cur      *os.File
+	w        *bufio.Writer
+	nextSeq  uint64
+	evicted  int
+	written  uint64
+	replayed uint64
+}
+
+// Open loads the segments of cfg.Dir, a torn record at the end of a segment is dropped
+func Open(cfg Config) (*Spool, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("spool dir must be defined")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 8 << 20
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 256 << 20
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 24 * time.Hour
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{cfg: cfg}
+
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{path: filepath.Join(cfg.Dir, name), seq: seq}
+		if err := seg.scan(); err != nil {
+			return nil, err
+		}
+		if seg.records == 0 {
+			os.Remove(seg.path)
+			continue
+		}
+		s.segments = append(s.segments, seg)
+		s.nextSeq = max(s.nextSeq, seq+1)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	s.evict()
+	return s, nil
+}
+
+// scan counts the records and truncates a torn tail
+func (seg *segment) scan() error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	seg.created = info.ModTime()
+
+	r := bufio.NewReader(f)
+	var off int64
+	for {
+		n, err := readRecord(r, nil)
+		if err != nil {
+			break
+		}
+		off += n
+		seg.records++
+	}
+	seg.size = off
+	if off < info.Size() {
+		return f.Truncate(off)
+	}
+	return nil
+}
+
+// record: length uint32, crc32 uint32, data
+func readRecord(r io.Reader, fn func([]byte) error) (int64, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(h[:4])
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(h[4:]) {
+		return 0, fmt.Errorf("spool record crc mismatch")
+	}
+	if fn != nil {
+		if err := fn(data); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(h)) + int64(n), nil
+}
+
+// Write appends a record, a new segment is started above SegmentSize.
+// A failed write leaves no partial record behind
+func (s *Spool) Write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cur == nil || s.tail().size >= s.cfg.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	var h [8]byte
+	binary.BigEndian.PutUint32(h[:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(h[4:], crc32.ChecksumIEEE(record))
+	if err := s.append(h[:], record); err != nil {
+		s.discard()
+		return err
+	}
+	seg := s.tail()
+	seg.size += int64(len(h) + len(record))
+	seg.records++
+	s.written++
+	s.evict()
+	return nil
+}
+
+func (s *Spool) append(h, record []byte) error {
+	if _, err := s.w.Write(h); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(record); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// discard the part of a failed record already written, the segment is truncated back
+// to its last record, or sealed when that fails so the next Write rotates, s.mu is held
+func (s *Spool) discard() {
+	seg := s.tail()
+	if err := s.cur.Truncate(seg.size); err != nil {
+		s.cur.Close()
+		s.cur, s.w = nil, nil
+		return
+	}
+	// a failed bufio.Writer keeps its error
+	s.w = bufio.NewWriter(s.cur)
+}
+
+func (s *Spool) tail() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate seals the current segment and starts a new one, s.mu is held
+func (s *Spool) rotate() error {
+	if err := s.seal(); err != nil {
+		return err
+	}
+	seg := &segment{path: filepath.Join(s.cfg.Dir, fmt.Sprintf("%020d%s", s.nextSeq, segmentExt)), seq: s.nextSeq, created: time.Now()}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.nextSeq++
+	s.cur, s.w = f, bufio.NewWriter(f)
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+// seal closes the segment being written, s.mu is held
+func (s *Spool) seal() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if e := s.cur.Sync(); err == nil {
+		err = e
+	}
+	if e := s.cur.Close(); err == nil {
+		err = e
+	}
+	s.cur, s.w = nil, nil
+	return err
+}
+
+// evict deletes the oldest sealed segments above MaxSize or older than MaxAge, s.mu is held
+func (s *Spool) evict() {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		if s.cur != nil && seg == s.tail() {
+			return
+		}
+		if total <= s.cfg.MaxSize && time.Since(seg.created) <= s.cfg.MaxAge {
+			return
+		}
+		os.Remove(seg.path)
+		total -= seg.size
+		s.evicted += seg.records - seg.replayed
+		s.segments = s.segments[1:]
+	}
+}
+
+// Replay calls fn with the records from the oldest, a replayed segment is deleted.
+// It stops at the first error of fn and returns it, the record is replayed next time
+func (s *Spool) Replay(fn func(record []byte) error) error {
+	s.mu.Lock()
+	s.evict()
+	segments := append([]*segment(nil), s.segments...)
+	s.mu.Unlock()
+
+	for _, seg := range segments {
+		if err := s.replaySegment(seg, fn); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.cur != nil && seg == s.tail() {
+			// written meanwhile, the next Replay continues
+			if seg.replayed < seg.records {
+				s.mu.Unlock()
+				continue
+			}
+			s.seal()
+		}
+		for i, v := range s.segments {
+			if v == seg {
+				s.segments = append(s.segments[:i], s.segments[i+1:]...)
+				break
+			}
+		}
+		s.mu.Unlock()
+		os.Remove(seg.path)
+	}
+	return nil
+}
+
+func (s *Spool) replaySegment(seg *segment, fn func([]byte) error) error {
+	s.mu.Lock()
+	records, replayed := seg.records, seg.replayed
+	s.mu.Unlock()
+	if replayed >= records {
+		return nil
+	}
+
+	f, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for i := 0; i < records; i++ {
+		var fnErr error
+		_, err := readRecord(r, func(b []byte) error {
+			if i < replayed {
+				return nil
+			}
+			fnErr = fn(b)
+			return fnErr
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		s.mu.Lock()
+		if err != nil {
+			s.evicted += seg.records - seg.replayed
+			seg.replayed = seg.records
+			s.mu.Unlock()
+			return nil
+		}
+		if i >= replayed {
+			seg.replayed = i + 1
+			s.replayed++
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Len records waiting to be replayed
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, seg := range s.segments {
+		n += seg.records - seg.replayed
+	}
+	return n
+}
+
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Stats{Segments: len(s.segments), Evicted: s.evicted, Written: s.written, Replayed: s.replayed}
+	for _, seg := range s.segments {
+		st.Bytes += seg.size
+		st.Records += seg.records - seg.replayed
+	}
+	return st
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seal()
+}
@@ -0,0 +1,168 @@
+package spool
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeRecords(t *testing.T, s *Spool, records ...string) {
+	t.Helper()
+	for _, r := range records {
+		if err := s.Write([]byte(r)); err != nil {
+			t.Fatalf("Write %q: %v", r, err)
+		}
+	}
+}
+
+func replayAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var got []string
+	if err := s.Replay(func(record []byte) error {
+		got = append(got, string(record))
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return got
+}
+
+func onlySegment(t *testing.T, dir string) string {
+	t.Helper()
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(paths) != 1 {
+		t.Fatalf("segments: got %d, want 1", len(paths))
+	}
+	return paths[0]
+}
+
+func TestTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage the segment holding a, b and c
+		tear func(data []byte) []byte
+		want []string
+	}{
+		{name: "intact", tear: func(data []byte) []byte { return data }, want: []string{"a", "b", "c"}},
+		{name: "half header", tear: func(data []byte) []byte { return append(data, 0, 0, 0) }, want: []string{"a", "b", "c"}},
+		{name: "half record", tear: func(data []byte) []byte { return data[:len(data)-1] }, want: []string{"a", "b"}},
+		{name: "crc mismatch", tear: func(data []byte) []byte {
+			data = bytes.Clone(data)
+			data[len(data)-1] ^= 0xff
+			return data
+		}, want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := Open(Config{Dir: dir})
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			writeRecords(t, s, "a", "b", "c")
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			path := onlySegment(t, dir)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.tear(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err = Open(Config{Dir: dir})
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer s.Close()
+			if got := s.Len(); got != len(tt.want) {
+				t.Errorf("Len: got %d, want %d", got, len(tt.want))
+			}
+			// appended after the torn tail was truncated
+			writeRecords(t, s, "d")
+			if got, want := replayAll(t, s), append(tt.want, "d"); !reflect.DeepEqual(got, want) {
+				t.Errorf("records: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReplayStops(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir(), SegmentSize: 32})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+	var records []string
+	for i := 0; i < 10; i++ {
+		records = append(records, fmt.Sprintf("record-%d", i))
+	}
+	writeRecords(t, s, records...)
+
+	var got []string
+	failed := fmt.Errorf("endpoint down")
+	err = s.Replay(func(record []byte) error {
+		if len(got) == 4 {
+			return failed
+		}
+		got = append(got, string(record))
+		return nil
+	})
+	if err != failed {
+		t.Fatalf("Replay error: got %v, want %v", err, failed)
+	}
+	got = append(got, replayAll(t, s)...)
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("records: got %v, want %v", got, records)
+	}
+	if st := s.Stats(); st.Records != 0 || st.Replayed != uint64(len(records)) {
+		t.Errorf("stats: got %+v, want 0 records %d replayed", st, len(records))
+	}
+}
+
+func TestEvict(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir(), SegmentSize: 16, MaxSize: 64})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+	for i := 0; i < 20; i++ {
+		writeRecords(t, s, strings.Repeat("x", 8))
+	}
+	st := s.Stats()
+	if st.Bytes > 64+16 {
+		t.Errorf("bytes: got %d, want <= %d", st.Bytes, 64+16)
+	}
+	if st.Evicted == 0 || st.Evicted+st.Records != 20 {
+		t.Errorf("evicted: got %+v, want %d in total", st, 20)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+	writeRecords(t, s, "a", "b")
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf, s)
+	for _, want := range []string{
+		fmt.Sprintf("spool_records{dir=%q} 2\n", dir),
+		fmt.Sprintf("spool_written_records_total{dir=%q} 2\n", dir),
+		"# TYPE spool_bytes gauge\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics miss %q\n%s", want, buf.String())
+		}
+	}
+}